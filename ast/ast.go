@@ -17,6 +17,9 @@ type Node interface {
 
 	// String returns this object as a string.
 	String() string
+
+	// Pos returns the position of the node in the source.
+	Pos() token.Position
 }
 
 // Statement represents a single statement.
@@ -39,6 +42,15 @@ type Program struct {
 	// Statements is the set of statements which the program is comprised
 	// of.
 	Statements []Statement
+
+	// Locals names the slots for variables local to blocks at the top
+	// level, like foreach loops, which the evaluator's resolver adds to
+	// the environment the program runs in.
+	Locals []string
+
+	// Resolved is set once the resolver has resolved the program without
+	// finding any mistakes, so the evaluator doesn't resolve it again.
+	Resolved bool
 }
 
 // TokenLiteral returns the literal token of our program.
@@ -49,6 +61,14 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+// Pos returns the position of the first statement in our program.
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 // String returns this object as a string.
 func (p *Program) String() string {
 	var out bytes.Buffer
@@ -66,6 +86,10 @@ type MutableStatement struct {
 	// Name is the name of the variable to which we're assigning
 	Name *Identifier
 
+	// Pattern unpacks the value into several variables instead of
+	// Name, which is nil.
+	Pattern *Pattern
+
 	// Value is the thing we're storing in the variable.
 	Value Expression
 }
@@ -75,11 +99,18 @@ func (ls *MutableStatement) statementNode() {}
 // TokenLiteral returns the literal token.
 func (ls *MutableStatement) TokenLiteral() string { return ls.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (ls *MutableStatement) Pos() token.Position { return ls.Token.Pos }
+
 // String returns this object as a string.
 func (ls *MutableStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.TokenLiteral())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.TokenLiteral())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -97,6 +128,10 @@ type LetStatement struct {
 	// Name is the name of the variable we're setting
 	Name *Identifier
 
+	// Pattern unpacks the value into several variables instead of
+	// Name, which is nil.
+	Pattern *Pattern
+
 	// Value contains the value which is to be set
 	Value Expression
 }
@@ -106,11 +141,18 @@ func (ls *LetStatement) statementNode() {}
 // TokenLiteral returns the literal token.
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
 // String returns this object as a string.
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.TokenLiteral())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.TokenLiteral())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -126,6 +168,18 @@ type Identifier struct {
 
 	// Value is the name of the identifier
 	Value string
+
+	// Local is where the variable lives, if it's local to a function or
+	// a block. It's set by the evaluator's resolver, and nil for globals
+	// and builtins.
+	Local *Local
+}
+
+// Local is the place of a local variable: how many frames out from the
+// current one it is, and its slot in that frame.
+type Local struct {
+	Depth int
+	Slot  int
 }
 
 func (i *Identifier) expressionNode() {}
@@ -133,6 +187,9 @@ func (i *Identifier) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
 // String returns this object as a string.
 func (i *Identifier) String() string {
 	return i.Value
@@ -152,6 +209,9 @@ func (rs *ReturnStatement) statementNode() {}
 // TokenLiteral returns the literal token.
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
 // String returns this object as a string.
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -163,6 +223,57 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// BreakStatement stores a break-statement, which exits a loop early.
+type BreakStatement struct {
+	// Token contains the literal token.
+	Token token.Token
+
+	// Label is the label of the loop to break out of, if any.
+	Label string
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+// TokenLiteral returns the literal token.
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+
+// Pos returns the position of the node in the source.
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
+// String returns this object as a string.
+func (bs *BreakStatement) String() string {
+	if bs.Label != "" {
+		return bs.TokenLiteral() + " " + bs.Label + ";"
+	}
+	return bs.TokenLiteral() + ";"
+}
+
+// ContinueStatement stores a continue-statement, which skips to the
+// next iteration of a loop.
+type ContinueStatement struct {
+	// Token contains the literal token.
+	Token token.Token
+
+	// Label is the label of the loop to continue, if any.
+	Label string
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+// TokenLiteral returns the literal token.
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+
+// Pos returns the position of the node in the source.
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
+// String returns this object as a string.
+func (cs *ContinueStatement) String() string {
+	if cs.Label != "" {
+		return cs.TokenLiteral() + " " + cs.Label + ";"
+	}
+	return cs.TokenLiteral() + ";"
+}
+
 // ExpressionStatement is an expression
 type ExpressionStatement struct {
 	// Token is the literal token
@@ -177,6 +288,9 @@ func (es *ExpressionStatement) statementNode() {}
 // TokenLiteral returns the literal token.
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Pos }
+
 // String returns this object as a string.
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
@@ -199,6 +313,9 @@ func (il *IntegerLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (il *IntegerLiteral) Pos() token.Position { return il.Token.Pos }
+
 // String returns this object as a string.
 func (il *IntegerLiteral) String() string { return il.Token.Literal }
 
@@ -216,6 +333,9 @@ func (fl *FloatLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Pos }
+
 // String returns this object as a string.
 func (fl *FloatLiteral) String() string { return fl.Token.Literal }
 
@@ -236,6 +356,9 @@ func (pe *PrefixExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+
 // String returns this object as a string.
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
@@ -266,6 +389,9 @@ func (ie *InfixExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (ie *InfixExpression) Pos() token.Position { return ie.Token.Pos }
+
 // String returns this object as a string.
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
@@ -283,6 +409,9 @@ type PostfixExpression struct {
 	Token token.Token
 	// Operator holds the postfix token, e.g. ++
 	Operator string
+
+	// Local is where the variable lives, like Identifier.Local.
+	Local *Local
 }
 
 func (pe *PostfixExpression) expressionNode() {}
@@ -290,6 +419,9 @@ func (pe *PostfixExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (pe *PostfixExpression) TokenLiteral() string { return pe.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (pe *PostfixExpression) Pos() token.Position { return pe.Token.Pos }
+
 // String returns this object as a string.
 func (pe *PostfixExpression) String() string {
 	var out bytes.Buffer
@@ -311,6 +443,9 @@ func (n *NullLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (n *NullLiteral) Pos() token.Position { return n.Token.Pos }
+
 // String returns this object as a string.
 func (n *NullLiteral) String() string { return n.Token.Literal }
 
@@ -328,6 +463,9 @@ func (b *Boolean) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
 // String returns this object as a string.
 func (b *Boolean) String() string { return b.Token.Literal }
 
@@ -346,6 +484,9 @@ func (bs *BlockStatement) statementNode() {}
 // TokenLiteral returns the literal token.
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
 // String returns this object as a string.
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
@@ -378,6 +519,9 @@ func (ie *IfExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
 // String returns this object as a string.
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
@@ -404,11 +548,25 @@ type ForeachStatement struct {
 	// Ident is the variable we'll set with each item, for the blocks' scope
 	Ident string
 
+	// Pattern unpacks each item into several variables. Ident is then
+	// its String(), which isn't a name anything else can have.
+	// This is optional.
+	Pattern *Pattern
+
+	// IndexSlot and IdentSlot are the slots of Index and Ident in the
+	// current frame, set by the evaluator's resolver.
+	IndexSlot int
+	IdentSlot int
+
 	// Value is the thing we'll range over.
 	Value Expression
 
 	// Body is the block we'll execute.
 	Body *BlockStatement
+
+	// Label is used by break and continue to name this loop.
+	// This is optional.
+	Label string
 }
 
 func (fes *ForeachStatement) expressionNode() {}
@@ -416,9 +574,15 @@ func (fes *ForeachStatement) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (fes *ForeachStatement) TokenLiteral() string { return fes.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (fes *ForeachStatement) Pos() token.Position { return fes.Token.Pos }
+
 // String returns this object as a string.
 func (fes *ForeachStatement) String() string {
 	var out bytes.Buffer
+	if fes.Label != "" {
+		out.WriteString(fes.Label + ": ")
+	}
 	out.WriteString("foreach ")
 	out.WriteString(fes.Ident)
 	out.WriteString(" ")
@@ -439,6 +603,10 @@ type ForLoopExpression struct {
 	// Consequence is the set of statements to be executed for the
 	// loop body.
 	Consequence *BlockStatement
+
+	// Label is used by break and continue to name this loop.
+	// This is optional.
+	Label string
 }
 
 func (fle *ForLoopExpression) expressionNode() {}
@@ -446,9 +614,15 @@ func (fle *ForLoopExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (fle *ForLoopExpression) TokenLiteral() string { return fle.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (fle *ForLoopExpression) Pos() token.Position { return fle.Token.Pos }
+
 // String returns this object as a string.
 func (fle *ForLoopExpression) String() string {
 	var out bytes.Buffer
+	if fle.Label != "" {
+		out.WriteString(fle.Label + ": ")
+	}
 	out.WriteString("for (")
 	out.WriteString(fle.Condition.String())
 	out.WriteString(" ) {")
@@ -457,6 +631,58 @@ func (fle *ForLoopExpression) String() string {
 	return out.String()
 }
 
+// TryExpression holds a try/catch/finally expression.
+type TryExpression struct {
+	// Token is the actual token
+	Token token.Token
+
+	// Block is the set of statements which might raise an error.
+	Block *BlockStatement
+
+	// CatchIdent is the name the caught error is bound to within the
+	// catch block (optional).
+	CatchIdent *Identifier
+
+	// Catch is the set of statements executed if the block raises an
+	// error (optional).
+	Catch *BlockStatement
+
+	// Finally is the set of statements which are always executed
+	// afterwards (optional).
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+
+// Pos returns the position of the node in the source.
+func (te *TryExpression) Pos() token.Position { return te.Token.Pos }
+
+// String returns this object as a string.
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try {")
+	out.WriteString(te.Block.String())
+	out.WriteString("}")
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.CatchIdent != nil {
+			out.WriteString(te.CatchIdent.String() + " ")
+		}
+		out.WriteString("{")
+		out.WriteString(te.Catch.String())
+		out.WriteString("}")
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally {")
+		out.WriteString(te.Finally.String())
+		out.WriteString("}")
+	}
+	return out.String()
+}
+
 // ImportExpression represents an `import` expression and holds the name
 // of the module being imported.
 type ImportExpression struct {
@@ -469,6 +695,9 @@ func (ie *ImportExpression) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node
 func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (ie *ImportExpression) Pos() token.Position { return ie.Token.Pos }
+
 // String returns a stringified version of the AST for debugging
 func (ie *ImportExpression) String() string {
 	var out bytes.Buffer
@@ -481,6 +710,53 @@ func (ie *ImportExpression) String() string {
 	return out.String()
 }
 
+// Pattern is the left side of a destructuring binding, which unpacks an
+// array, like [a, b, ...rest], or a hash, like {name, port = 80}.
+type Pattern struct {
+	// Token is the '[' or '{' token
+	Token token.Token
+
+	// Names are the variables to set, with the elements of an array in
+	// order, or the values of a hash with the names as keys.
+	Names []*Identifier
+
+	// Rest is set with an array of the elements of an array after
+	// those in Names. This is optional.
+	Rest *Identifier
+
+	// Defaults holds the values of names which are missing, like those
+	// of a function's parameters.
+	Defaults map[string]Expression
+}
+
+// IsHash reports whether the pattern unpacks a hash.
+func (p *Pattern) IsHash() bool { return p.Token.Type == token.LBRACE }
+
+// TokenLiteral returns the literal token.
+func (p *Pattern) TokenLiteral() string { return p.Token.Literal }
+
+// Pos returns the position of the node in the source.
+func (p *Pattern) Pos() token.Position { return p.Token.Pos }
+
+// String returns this object as a string.
+func (p *Pattern) String() string {
+	parts := make([]string, 0, len(p.Names)+1)
+	for _, name := range p.Names {
+		if def, ok := p.Defaults[name.Value]; ok {
+			parts = append(parts, name.Value+" = "+def.String())
+		} else {
+			parts = append(parts, name.Value)
+		}
+	}
+	if p.Rest != nil {
+		parts = append(parts, "..."+p.Rest.Value)
+	}
+	if p.IsHash() {
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
 // FunctionLiteral holds a function-definition
 type FunctionLiteral struct {
 	// Token is the actual token
@@ -493,11 +769,19 @@ type FunctionLiteral struct {
 	// specified
 	Defaults map[string]Expression
 
+	// Patterns unpacks arguments into several variables, by the name of
+	// their parameter, which is the String() of the pattern.
+	Patterns map[string]*Pattern
+
 	// Body contains the set of statements within the function.
 	Body *BlockStatement
 
 	// DocString
 	DocString *DocStringLiteral
+
+	// Locals names the slots of the function's frame, starting with its
+	// parameters. It's set by the evaluator's resolver.
+	Locals []string
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -505,6 +789,9 @@ func (fl *FunctionLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
 // String returns this object as a string.
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
@@ -533,6 +820,9 @@ func (cal *CurrentArgsLiteral) TokenLiteral() string {
 	return cal.Token.Literal
 }
 
+// Pos returns the position of the node in the source.
+func (cal *CurrentArgsLiteral) Pos() token.Position { return cal.Token.Pos }
+
 // String returns a string representation of the literal
 func (cal *CurrentArgsLiteral) String() string {
 	return "..."
@@ -552,6 +842,9 @@ func (s *SpreadLiteral) expressionNode() {}
 // TokenLiteral returns the spread token
 func (s *SpreadLiteral) TokenLiteral() string { return s.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (s *SpreadLiteral) Pos() token.Position { return s.Token.Pos }
+
 // String returns a string representation of the literal
 func (s *SpreadLiteral) String() string {
 	return "...."
@@ -567,6 +860,11 @@ type CallExpression struct {
 
 	// Arguments are the arguments to be applied
 	Arguments []Expression
+
+	// Tail is set by the evaluator's resolver when the call is the last
+	// thing its function does, so it can be made without growing the
+	// stack.
+	Tail bool
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -574,6 +872,15 @@ func (ce *CallExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+// Pos returns the position of the node in the source, which is where the
+// function being called is, rather than the parenthesis.
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+
 // String returns this object as a string.
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
@@ -602,9 +909,33 @@ func (sl *StringLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (sl *StringLiteral) Pos() token.Position { return sl.Token.Pos }
+
 // String returns this object as a string.
 func (sl *StringLiteral) String() string { return sl.Token.Literal }
 
+// InterpolatedString holds a string with {{ }} interpolations in it
+type InterpolatedString struct {
+	// Token is the token
+	Token token.Token
+
+	// Parts are the text of the string, as StringLiterals, and the
+	// expressions interpolated into it, in order.
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+
+// Pos returns the position of the node in the source.
+func (is *InterpolatedString) Pos() token.Position { return is.Token.Pos }
+
+// String returns this object as a string.
+func (is *InterpolatedString) String() string { return is.Token.Literal }
+
 // DocStringLiteral holds a string
 type DocStringLiteral struct {
 	// Token is the token
@@ -619,6 +950,9 @@ func (sl *DocStringLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (sl *DocStringLiteral) TokenLiteral() string { return sl.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (sl *DocStringLiteral) Pos() token.Position { return sl.Token.Pos }
+
 // String returns this object as a string.
 func (sl *DocStringLiteral) String() string { return sl.Token.Literal }
 
@@ -636,6 +970,9 @@ func (al *ArrayLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
 // String returns this object as a string.
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
@@ -666,6 +1003,9 @@ func (ie *IndexExpression) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (ie *IndexExpression) Pos() token.Position { return ie.Token.Pos }
+
 // String returns this object as a string.
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
@@ -677,6 +1017,53 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// MemberExpression is a dot access, like a.b, which looks up a key of a
+// hash, a method, or a field of a module.
+type MemberExpression struct {
+	// Token is the '.' token
+	Token token.Token
+
+	// Object is the thing the member is looked up on.
+	Object Expression
+
+	// Property is the name of the member.
+	Property *Identifier
+
+	// Name is set by the evaluator's resolver when the whole expression
+	// is the name of a variable with dots in it, like fs.glob or
+	// http.constants, which is looked up instead of Object.
+	Name *Identifier
+}
+
+func (me *MemberExpression) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+
+// Pos returns the position of the node in the source.
+func (me *MemberExpression) Pos() token.Position { return me.Token.Pos }
+
+// String returns this object as a string.
+func (me *MemberExpression) String() string {
+	return "(" + me.Object.String() + "." + me.Property.String() + ")"
+}
+
+// DottedName returns the expression as a name, like "a.b.c", if it's
+// only identifiers and dots, and "" otherwise.
+func (me *MemberExpression) DottedName() string {
+	var left string
+	switch obj := me.Object.(type) {
+	case *Identifier:
+		left = obj.Value
+	case *MemberExpression:
+		left = obj.DottedName()
+	}
+	if left == "" {
+		return ""
+	}
+	return left + "." + me.Property.Value
+}
+
 // HashLiteral holds a hash definition
 type HashLiteral struct {
 	// Token holds the token
@@ -691,6 +1078,9 @@ func (hl *HashLiteral) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
 // String returns this object as a string.
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
@@ -723,6 +1113,9 @@ func (as *AssignStatement) expressionNode() {}
 // TokenLiteral returns the literal token.
 func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
 
+// Pos returns the position of the node in the source.
+func (as *AssignStatement) Pos() token.Position { return as.Token.Pos }
+
 // String returns this object as a string.
 func (as *AssignStatement) String() string {
 	var out bytes.Buffer
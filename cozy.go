@@ -4,87 +4,142 @@
 package main
 
 import (
-	"embed"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/fs"
 	"io/ioutil"
 	"os"
 	"strings"
 
 	"github.com/zacanger/cozy/evaluator"
+	"github.com/zacanger/cozy/interpreter"
 	"github.com/zacanger/cozy/lexer"
 	"github.com/zacanger/cozy/object"
 	"github.com/zacanger/cozy/parser"
 	"github.com/zacanger/cozy/repl"
-	"github.com/zacanger/cozy/utils"
+	"github.com/zacanger/cozy/stdlib"
 )
 
 // COZY_VERSION is replaced by go build in makefile
 var COZY_VERSION = "cozy-version"
 
-//go:embed stdlib
-var stdlibFs embed.FS
-
-// turn the embed fs into a string we can use
-func getStdlibString() string {
-	s := ""
-	fs.WalkDir(
-		stdlibFs,
-		".",
-		func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if strings.HasSuffix(path, ".cz") {
-				c, err := ioutil.ReadFile(path)
-				if err != nil {
-					return err
-				}
-				s += string(c)
-				s += "\n"
-			}
+// Implemention of "version()" function.
+func versionFn(args ...object.Object) object.Object {
+	return &object.String{Value: COZY_VERSION}
+}
 
-			return nil
-		})
+// allowList is a flag like --allow-read=./data,./tmp, which may be given
+// more than once. Without a value it allows everything.
+type allowList struct {
+	set    bool
+	values []string
+}
 
-	return s
+func (a *allowList) String() string {
+	return strings.Join(a.values, ",")
 }
 
-// Implemention of "version()" function.
-func versionFn(args ...object.Object) object.Object {
-	return &object.String{Value: COZY_VERSION}
+func (a *allowList) Set(s string) error {
+	a.set = true
+	if s == "true" {
+		a.values = append(a.values, evaluator.AllowAll)
+		return nil
+	}
+	a.values = append(a.values, strings.Split(s, ",")...)
+	return nil
 }
 
-// Execute the supplied string as a program.
-func Execute(input string) int {
-	env := object.NewEnvironment()
-	l := lexer.New(input)
-	p := parser.New(l)
+// IsBoolFlag lets the flag be given without a value.
+func (a *allowList) IsBoolFlag() bool {
+	return true
+}
 
-	program := p.ParseProgram()
-	if len(p.Errors()) != 0 {
-		parser.PrintParserErrors(parser.ParserErrorsParams{Errors: p.Errors()})
+// Execute the supplied string as a program. The filename is used when
+// reporting errors. Returns the exit code.
+func Execute(input string, filename string, opts interpreter.Options) int {
+	interp, err := interpreter.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading stdlib: %s\n", err)
+		return 1
 	}
 
 	// Register a function called version()
 	// that the script can call.
-	evaluator.RegisterBuiltin("version",
+	interp.RegisterBuiltin("version",
 		func(env *object.Environment, args ...object.Object) object.Object {
 			return versionFn(args...)
 		})
 
-	//  Parse and evaluate our standard-library.
-	initL := lexer.New(getStdlibString())
-	initP := parser.New(initL)
-	initProg := initP.ParseProgram()
-	evaluator.Eval(initProg, env)
-
-	//  Now evaluate the code the user wanted to load.
-	//  Note that here our environment will still contain
-	// the code we just loaded from our data-resource
-	//  (i.e. Our cozy-based standard library.)
-	evaluator.Eval(program, env)
+	_, err = interp.Run(context.Background(), input, filename)
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case interpreter.ParseErrors:
+		parser.PrintParserErrors(parser.ParserErrorsParams{Errors: e})
+		return 1
+	case interpreter.ResolveErrors:
+		for _, err := range e {
+			fmt.Printf("\t%s\n", err.Error())
+		}
+		return 1
+	case *interpreter.ExitError:
+		return e.Code
+	case *interpreter.RuntimeError:
+		// an error which nothing caught
+		fmt.Fprintln(os.Stderr, e.Error())
+		return e.Code()
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+}
+
+// Check the supplied string without running it, printing every syntax
+// error found, or else every mistake like an undefined variable. Returns
+// the exit code.
+func Check(input string, filename string, asJSON bool) int {
+	var errs []error
+	p := parser.New(lexer.NewWithFile(filename, input))
+	p.ParseProgram()
+	for _, e := range p.Errors() {
+		errs = append(errs, e)
+	}
+
+	// Only a program which parses can be resolved, against the
+	// standard library.
+	if len(errs) == 0 {
+		interp, err := interpreter.New(interpreter.Options{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading stdlib: %s\n", err)
+			return 1
+		}
+		if resolveErrs, ok := interp.Check(input, filename).(interpreter.ResolveErrors); ok {
+			for _, e := range resolveErrs {
+				errs = append(errs, e)
+			}
+		}
+	}
+
+	if asJSON {
+		if errs == nil {
+			errs = []error{}
+		}
+		out, err := json.MarshalIndent(errs, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding errors: %s\n", err)
+			return 1
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, e := range errs {
+			fmt.Println(e.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return 1
+	}
 	return 0
 }
 
@@ -96,38 +151,77 @@ func main() {
 	versDesc := "Show our version and exit"
 	vers := flag.Bool("version", false, versDesc)
 	flag.BoolVar(vers, "v", false, versDesc)
+	checkDesc := "Check a file for syntax errors and undefined variables without running it"
+	check := flag.Bool("check", false, checkDesc)
+	jsonDesc := "Print -check results as JSON"
+	asJSON := flag.Bool("json", false, jsonDesc)
+
+	// Sandboxing. Giving any of these denies everything they don't allow.
+	sandbox := flag.Bool("sandbox", false,
+		"Deny access to files, the network, commands, and the environment, except as allowed by the --allow flags")
+	var allowRead, allowWrite, allowNet, allowRun allowList
+	flag.Var(&allowRead, "allow-read", "Allow reading these files and directories (all if empty)")
+	flag.Var(&allowWrite, "allow-write", "Allow changing these files and directories (all if empty)")
+	flag.Var(&allowNet, "allow-net", "Allow using these hosts, like localhost:8080 (all if empty)")
+	flag.Var(&allowRun, "allow-run", "Allow running these commands (all if empty)")
+	allowEnv := flag.Bool("allow-env", false, "Allow using environment variables")
+
+	treeWalker := flag.Bool("tree-walker", false,
+		"Evaluate the syntax tree directly instead of compiling to bytecode")
 
 	// Parse the flags
 	flag.Parse()
 
+	var opts interpreter.Options
+	if *sandbox || *allowEnv || allowRead.set || allowWrite.set ||
+		allowNet.set || allowRun.set {
+		opts.Permissions = &evaluator.Permissions{
+			Read:  allowRead.values,
+			Write: allowWrite.values,
+			Net:   allowNet.values,
+			Run:   allowRun.values,
+			Env:   *allowEnv,
+		}
+	}
+	if *treeWalker {
+		opts.Backend = evaluator.TreeWalker
+	}
+
 	// Showing the version?
 	if *vers {
 		fmt.Printf("cozy %s\n", COZY_VERSION)
-		utils.ExitConditionally(0)
+		os.Exit(0)
 	}
 
 	// Executing code?
 	if *eval != "" {
-		Execute(*eval)
-		utils.ExitConditionally(0)
+		if *check {
+			os.Exit(Check(*eval, "<eval>", *asJSON))
+		}
+		os.Exit(Execute(*eval, "<eval>", opts))
 	}
 
 	// Otherwise we're either reading from STDIN, or the
 	// named file containing source-code.
 	var input []byte
 	var err error
+	filename := flag.Arg(0)
 
 	if len(flag.Args()) > 0 {
-		input, err = ioutil.ReadFile(os.Args[1])
+		input, err = ioutil.ReadFile(filename)
 	} else {
 		fmt.Printf("cozy version %s\n", COZY_VERSION)
 		fmt.Println("Use ctrl+d to quit")
-		repl.Start(os.Stdin, os.Stdout, getStdlibString())
+		os.Exit(repl.Start(os.Stdin, os.Stdout, stdlib.String()))
 	}
 
 	if err != nil {
 		fmt.Printf("Error reading: %s\n", err.Error())
 	}
 
-	Execute(string(input))
+	if *check {
+		os.Exit(Check(string(input), filename, *asJSON))
+	}
+
+	os.Exit(Execute(string(input), filename, opts))
 }
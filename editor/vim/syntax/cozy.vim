@@ -5,7 +5,7 @@ endif
 syn case match
 
 " used in interpolations
-syn cluster     cozyEverything      contains=cozyMutable,cozyLet,cozyDeclaration,cozyStatement,cozyConditional,cozyRepeat,cozyBuiltins,cozyBoolean,cozyString,cozyField,cozySingleDecl,cozyDecimalInt,cozyFloat,cozyOperator,cozyFunction,cozyFunctionCall
+syn cluster     cozyEverything      contains=cozyMutable,cozyLet,cozyDeclaration,cozyStatement,cozyConditional,cozyRepeat,cozyException,cozyBuiltins,cozyBoolean,cozyString,cozyHeredoc,cozyRawString,cozyField,cozySingleDecl,cozyDecimalInt,cozyFloat,cozyOperator,cozyFunction,cozyFunctionCall
 
 syn keyword     cozyImport          import  contained
 syn keyword     cozyMutable         mutable contained
@@ -18,10 +18,12 @@ hi def link     cozyDeclaration     Keyword
 " Keywords within functions
 syn keyword     cozyStatement         return null
 syn keyword     cozyConditional       if else
-syn keyword     cozyRepeat            for foreach in
+syn keyword     cozyRepeat            for foreach in break continue
+syn keyword     cozyException         try catch finally
 hi def link     cozyStatement         Statement
 hi def link     cozyConditional       Conditional
 hi def link     cozyRepeat            Repeat
+hi def link     cozyException         Exception
 
 " Predefined functions and values
 syn keyword     cozyBuiltins
@@ -58,8 +60,12 @@ hi def link     cozyTodo              Todo
 " Strings and their contents
 syn region      cozyString            start=+"+ skip=+\\\\\|\\"+ end=+"+ contains=@Spell
 syn region      cozyDocString         start=+'+ skip=+\\\\\|\\'+ end=+'+ contains=@Spell
+syn region      cozyHeredoc           start=+"""+ skip=+\\\\\|\\"+ end=+"""+ contains=@Spell
+syn region      cozyRawString         start=+`+ end=+`+ contains=@Spell
 hi def link cozyString String
 hi def link cozyDocString String
+hi def link cozyHeredoc String
+hi def link cozyRawString String
 
 " 1. Match a sequence of word characters coming after a '.'
 " 2. Require the following but dont match it: ( \@= see :h E59)
@@ -137,7 +143,7 @@ syn match       cozyFunctionCall      /\w\+\ze(/ contains=cozyBuiltins,cozyDecla
 hi def link     cozyFunctionCall      Type
 
 " Interpolations
-syn region cozyInterp       matchgroup=cozyInterpDelim start="{{" end="}}" contained containedin=cozyString contains=@cozyEverything
+syn region cozyInterp       matchgroup=cozyInterpDelim start="{{" end="}}" contained containedin=cozyString,cozyHeredoc contains=@cozyEverything
 hi def link cozyInterpDelim Delimiter
 
 " Variable Assignments
@@ -0,0 +1,176 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Opcode is a single bytecode instruction. Every operand is two bytes,
+// big-endian.
+type Opcode byte
+
+const (
+	// OpConstant pushes a constant.
+	OpConstant Opcode = iota
+	// OpNull, OpTrue, and OpFalse push the singletons.
+	OpNull
+	OpTrue
+	OpFalse
+	// OpNil pushes nothing at all (a Go nil), which is what an empty
+	// block evaluates to.
+	OpNil
+	// OpPop discards the top of the stack.
+	OpPop
+
+	// OpGetName pushes the value of a global or builtin.
+	OpGetName
+	// OpGetLocal pushes the value in a slot of a frame, given how many
+	// frames out it is, the slot, and the variable's name.
+	OpGetLocal
+	// OpSetLet and OpSetMutable bind the top of the stack to a global,
+	// leaving it there, and OpSetLocal puts it in a slot.
+	OpSetLet
+	OpSetMutable
+	OpSetLocal
+	// OpDestructure unpacks the top of the stack into the variables of
+	// a pattern, leaving it there, given whether they're bound with let.
+	OpDestructure
+	// OpAssign replaces the top of the stack with the result of an
+	// assignment statement, with an operator like "=" or "+=".
+	OpAssign
+	// OpPostfix runs a postfix expression like i++, pushing the old
+	// value of the variable.
+	OpPostfix
+
+	// OpPrefix and OpInfix apply an operator.
+	OpPrefix
+	OpInfix
+	// OpIndex replaces a value and an index with the result of
+	// indexing, including method lookup.
+	OpIndex
+
+	// OpArray and OpHash collect elements, or keys and values, from
+	// the stack.
+	OpArray
+	OpHash
+	// OpInterpolate joins the parts of an interpolated string from
+	// the stack.
+	OpInterpolate
+	// OpClosure pushes a function closing over the current
+	// environment.
+	OpClosure
+	// OpCall calls a function with arguments from the stack, and
+	// OpTailCall returns a call to make once the unit has returned.
+	OpCall
+	OpTailCall
+	// OpEval evaluates a syntax tree node with the tree-walker, for
+	// the things which are rare enough not to need their own opcodes.
+	OpEval
+
+	// OpJump and OpJumpNotTruthy jump to an offset; OpJumpNotTruthy
+	// pops the condition.
+	OpJump
+	OpJumpNotTruthy
+
+	// OpJumpOut breaks out of or continues a loop, going back to the
+	// height of the stack it had before jumping.
+	OpJumpOut
+
+	// OpReturn returns the top of the stack from a return statement.
+	OpReturn
+	// OpComplete returns a break or continue constant, for a loop
+	// outside of the unit.
+	OpComplete
+
+	// OpForeach replaces a value with an iterator over it, if it can
+	// be iterated over. OpIterNext puts the next element, and
+	// its index, in the slots for the loop variables, or jumps once
+	// there are none, and OpForeachEnd replaces the iterator with null.
+	OpForeach
+	OpIterNext
+	OpForeachEnd
+
+	// OpTry runs the blocks of a try expression.
+	OpTry
+)
+
+type opDefinition struct {
+	name     string
+	operands int
+}
+
+var opDefinitions = map[Opcode]opDefinition{
+	OpConstant:      {"OpConstant", 1},
+	OpNull:          {"OpNull", 0},
+	OpTrue:          {"OpTrue", 0},
+	OpFalse:         {"OpFalse", 0},
+	OpNil:           {"OpNil", 0},
+	OpPop:           {"OpPop", 0},
+	OpGetName:       {"OpGetName", 1},
+	OpGetLocal:      {"OpGetLocal", 3},
+	OpSetLet:        {"OpSetLet", 1},
+	OpSetMutable:    {"OpSetMutable", 1},
+	OpSetLocal:      {"OpSetLocal", 3},
+	OpDestructure:   {"OpDestructure", 2},
+	OpAssign:        {"OpAssign", 1},
+	OpPostfix:       {"OpPostfix", 1},
+	OpPrefix:        {"OpPrefix", 1},
+	OpInfix:         {"OpInfix", 1},
+	OpIndex:         {"OpIndex", 0},
+	OpArray:         {"OpArray", 1},
+	OpHash:          {"OpHash", 1},
+	OpInterpolate:   {"OpInterpolate", 1},
+	OpClosure:       {"OpClosure", 1},
+	OpCall:          {"OpCall", 1},
+	OpTailCall:      {"OpTailCall", 1},
+	OpEval:          {"OpEval", 1},
+	OpJump:          {"OpJump", 1},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", 1},
+	OpJumpOut:       {"OpJumpOut", 2},
+	OpReturn:        {"OpReturn", 0},
+	OpComplete:      {"OpComplete", 1},
+	OpForeach:       {"OpForeach", 0},
+	OpIterNext:      {"OpIterNext", 3},
+	OpForeachEnd:    {"OpForeachEnd", 0},
+	OpTry:           {"OpTry", 1},
+}
+
+// noOperand marks an optional operand which isn't there, like the index
+// slot of a foreach without one.
+const noOperand = 0xFFFF
+
+// makeInstruction encodes an instruction.
+func makeInstruction(op Opcode, operands ...int) []byte {
+	ins := make([]byte, 1+2*len(operands))
+	ins[0] = byte(op)
+	for i, o := range operands {
+		binary.BigEndian.PutUint16(ins[1+2*i:], uint16(o))
+	}
+	return ins
+}
+
+// readOperand reads the two-byte operand at the start of ins.
+func readOperand(ins []byte) int {
+	return int(binary.BigEndian.Uint16(ins))
+}
+
+// disassemble returns a readable listing of some instructions.
+func disassemble(code []byte) string {
+	var out bytes.Buffer
+	for i := 0; i < len(code); {
+		def, ok := opDefinitions[Opcode(code[i])]
+		if !ok {
+			fmt.Fprintf(&out, "%04d ERROR: unknown opcode %d\n", i, code[i])
+			i++
+			continue
+		}
+		fmt.Fprintf(&out, "%04d %s", i, def.name)
+		for j := 0; j < def.operands; j++ {
+			fmt.Fprintf(&out, " %d", readOperand(code[i+1+2*j:]))
+		}
+		out.WriteString("\n")
+		i += 1 + 2*def.operands
+	}
+	return out.String()
+}
@@ -0,0 +1,505 @@
+package evaluator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/zacanger/cozy/ast"
+	"github.com/zacanger/cozy/object"
+	"github.com/zacanger/cozy/token"
+)
+
+// unitKind is what a unit of bytecode was compiled from, which decides
+// what a return inside it does.
+type unitKind int
+
+const (
+	programUnit unitKind = iota
+	functionUnit
+	// blockUnit is a block of a try expression; a return or a break
+	// inside one is handed back to the unit running the try.
+	blockUnit
+)
+
+// unit is a compiled program, function body, or block. Its value is
+// whatever is left on top of the stack when the code runs out.
+type unit struct {
+	kind      unitKind
+	code      []byte
+	constants []OBJ
+	names     []string
+	nodes     []ast.Node
+	funcs     []*compiledFunction
+	tries     []*compiledTry
+	positions []position
+	maxStack  int
+}
+
+// position records that the instructions from offset on were compiled
+// from a node at pos, for reporting errors.
+type position struct {
+	offset int
+	pos    token.Position
+}
+
+// posAt returns the position of the node the instruction at an offset
+// was compiled from.
+func (u *unit) posAt(offset int) token.Position {
+	i := sort.Search(len(u.positions), func(i int) bool {
+		return u.positions[i].offset > offset
+	})
+	if i == 0 {
+		return token.Position{}
+	}
+	return u.positions[i-1].pos
+}
+
+// compiledFunction is a function literal along with its compiled body.
+type compiledFunction struct {
+	literal *ast.FunctionLiteral
+	body    *unit
+}
+
+// compiledTry is a try expression, with each of its blocks compiled to
+// a unit of its own.
+type compiledTry struct {
+	block, catch, finally *unit
+	catchIdent            *ast.Identifier
+
+	// loops around the try expression within its unit, innermost
+	// first, which a break or continue from one of the blocks may be
+	// aimed at.
+	loops []*compiledLoop
+}
+
+// findLoop returns the loop a break or continue with this label is aimed
+// at, or nil if it's outside the unit.
+func (t *compiledTry) findLoop(label string) *compiledLoop {
+	for _, l := range t.loops {
+		if label == "" || l.label == label {
+			return l
+		}
+	}
+	return nil
+}
+
+// compiledLoop is where break and continue go for a loop, along with
+// the height of the stack to go back to when they do.
+type compiledLoop struct {
+	label      string
+	brk, cont  int
+	stack      int
+	breakJumps []int
+}
+
+// compiler turns syntax trees into units of bytecode.
+type compiler struct {
+	unit  *unit
+	names map[string]int
+
+	// depth is the height of the stack at the instruction being
+	// emitted.
+	depth int
+
+	// loops within the unit, innermost last.
+	loops []*compiledLoop
+
+	// pos is the position of the node being compiled.
+	pos token.Position
+
+	err error
+}
+
+func newCompiler(kind unitKind) *compiler {
+	return &compiler{
+		unit:  &unit{kind: kind},
+		names: make(map[string]int),
+	}
+}
+
+// compileProgram compiles a program. It only fails if the program is too
+// big for the bytecode, in which case it can still be tree-walked.
+func compileProgram(program *ast.Program) (*unit, error) {
+	c := newCompiler(programUnit)
+	c.statements(program.Statements)
+	return c.finish()
+}
+
+// compileBlock compiles a block to a unit of its own kind.
+func (c *compiler) compileBlock(kind unitKind, block *ast.BlockStatement) *unit {
+	sub := newCompiler(kind)
+	sub.pos = c.pos
+	sub.statements(block.Statements)
+	u, err := sub.finish()
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return u
+}
+
+func (c *compiler) finish() (*unit, error) {
+	if c.err == nil && len(c.unit.code) > noOperand {
+		c.err = fmt.Errorf("too much code to compile (%d bytes)", len(c.unit.code))
+	}
+	return c.unit, c.err
+}
+
+// operand checks that an index or offset fits in an operand.
+func (c *compiler) operand(n int) int {
+	if n >= noOperand && c.err == nil {
+		c.err = fmt.Errorf("too many constants, names, or functions to compile")
+	}
+	return n
+}
+
+// stackEffect is how many values an instruction adds to the stack, or
+// takes off of it if it's negative.
+func stackEffect(op Opcode, operands []int) int {
+	switch op {
+	case OpConstant, OpNull, OpTrue, OpFalse, OpNil, OpGetName,
+		OpGetLocal, OpPostfix, OpClosure, OpEval, OpTry:
+		return 1
+	case OpPop, OpInfix, OpIndex, OpJumpNotTruthy, OpReturn:
+		return -1
+	case OpArray, OpInterpolate:
+		return 1 - operands[0]
+	case OpHash:
+		return 1 - 2*operands[0]
+	case OpCall, OpTailCall:
+		return -operands[0]
+	}
+	return 0
+}
+
+// emit adds an instruction, returning its offset.
+func (c *compiler) emit(op Opcode, operands ...int) int {
+	u := c.unit
+	offset := len(u.code)
+	if n := len(u.positions); n == 0 || u.positions[n-1].pos != c.pos {
+		u.positions = append(u.positions, position{offset, c.pos})
+	}
+	u.code = append(u.code, makeInstruction(op, operands...)...)
+	c.push(stackEffect(op, operands))
+	return offset
+}
+
+// push records values being added to or taken off of the stack.
+func (c *compiler) push(n int) {
+	c.depth += n
+	if c.depth > c.unit.maxStack {
+		c.unit.maxStack = c.depth
+	}
+}
+
+// patch sets an operand of the instruction at an offset.
+func (c *compiler) patch(offset, operand, value int) {
+	binary.BigEndian.PutUint16(c.unit.code[offset+1+2*operand:], uint16(value))
+}
+
+func (c *compiler) constant(obj OBJ) int {
+	c.unit.constants = append(c.unit.constants, obj)
+	return c.operand(len(c.unit.constants) - 1)
+}
+
+func (c *compiler) name(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	c.unit.names = append(c.unit.names, name)
+	i := c.operand(len(c.unit.names) - 1)
+	c.names[name] = i
+	return i
+}
+
+// node adds a node for an instruction which needs it.
+func (c *compiler) node(node ast.Node) int {
+	c.unit.nodes = append(c.unit.nodes, node)
+	return c.operand(len(c.unit.nodes) - 1)
+}
+
+// evalNode hands a node to the tree-walker.
+func (c *compiler) evalNode(node ast.Node) {
+	c.emit(OpEval, c.node(node))
+}
+
+// setVariable binds the top of the stack to a name, which is a let if
+// readonly is set.
+func (c *compiler) setVariable(ident *ast.Identifier, readonly bool) {
+	switch {
+	case ident.Local != nil:
+		c.emit(OpSetLocal, ident.Local.Depth, c.operand(ident.Local.Slot), c.name(ident.Value))
+	case readonly:
+		c.emit(OpSetLet, c.name(ident.Value))
+	default:
+		c.emit(OpSetMutable, c.name(ident.Value))
+	}
+}
+
+// statements compiles a list of statements, leaving the value of the
+// last one, or nothing (a Go nil) if there aren't any.
+func (c *compiler) statements(statements []ast.Statement) {
+	if len(statements) == 0 {
+		c.emit(OpNil)
+		return
+	}
+	for i, s := range statements {
+		if i > 0 {
+			c.emit(OpPop)
+		}
+		c.compile(s)
+	}
+}
+
+// compile compiles a node, which always leaves exactly one value on the
+// stack, like Eval returns one.
+func (c *compiler) compile(node ast.Node) {
+	if node == nil {
+		c.emit(OpNil)
+		return
+	}
+	defer func(pos token.Position) { c.pos = pos }(c.pos)
+	c.pos = node.Pos()
+
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		c.compile(node.Expression)
+	case *ast.IntegerLiteral:
+		c.emit(OpConstant, c.constant(&object.Integer{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(OpConstant, c.constant(&object.Float{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+	case *ast.NullLiteral:
+		c.emit(OpNull)
+	case *ast.StringLiteral:
+		c.emit(OpConstant, c.constant(&object.String{Value: node.Value}))
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			c.compile(part)
+		}
+		c.emit(OpInterpolate, c.operand(len(node.Parts)))
+	case *ast.PrefixExpression:
+		c.compile(node.Right)
+		c.emit(OpPrefix, c.name(node.Operator))
+	case *ast.PostfixExpression:
+		c.emit(OpPostfix, c.node(node))
+	case *ast.InfixExpression:
+		c.compile(node.Left)
+		c.compile(node.Right)
+		c.emit(OpInfix, c.name(node.Operator))
+	case *ast.IndexExpression:
+		c.compile(node.Left)
+		c.compile(node.Index)
+		c.emit(OpIndex)
+	case *ast.MemberExpression:
+		if node.Name != nil {
+			c.compile(node.Name)
+			break
+		}
+		c.compile(node.Object)
+		c.emit(OpConstant, c.constant(&object.String{Value: node.Property.Value}))
+		c.emit(OpIndex)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			c.compile(e)
+		}
+		c.emit(OpArray, c.operand(len(node.Elements)))
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			c.compile(k)
+			c.compile(v)
+		}
+		c.emit(OpHash, c.operand(len(node.Pairs)))
+
+	case *ast.Identifier:
+		if node.Local != nil {
+			c.emit(OpGetLocal, node.Local.Depth, c.operand(node.Local.Slot), c.name(node.Value))
+		} else {
+			c.emit(OpGetName, c.name(node.Value))
+		}
+	case *ast.LetStatement:
+		c.compile(node.Value)
+		if node.Pattern != nil {
+			c.emit(OpDestructure, c.node(node.Pattern), 1)
+		} else {
+			c.setVariable(node.Name, true)
+		}
+	case *ast.MutableStatement:
+		c.compile(node.Value)
+		if node.Pattern != nil {
+			c.emit(OpDestructure, c.node(node.Pattern), 0)
+		} else {
+			c.setVariable(node.Name, false)
+		}
+	case *ast.AssignStatement:
+		c.compile(node.Value)
+		c.emit(OpAssign, c.node(node))
+
+	case *ast.FunctionLiteral:
+		c.unit.funcs = append(c.unit.funcs, &compiledFunction{
+			literal: node,
+			body:    c.compileBlock(functionUnit, node.Body),
+		})
+		c.emit(OpClosure, c.operand(len(c.unit.funcs)-1))
+	case *ast.CallExpression:
+		c.compile(node.Function)
+		for _, a := range node.Arguments {
+			c.compile(a)
+		}
+		op := OpCall
+		if node.Tail {
+			op = OpTailCall
+		}
+		c.emit(op, c.operand(len(node.Arguments)))
+
+	case *ast.IfExpression:
+		c.ifExpression(node)
+	case *ast.ForLoopExpression:
+		c.forLoop(node)
+	case *ast.ForeachStatement:
+		c.foreach(node)
+	case *ast.TryExpression:
+		c.tryExpression(node)
+	case *ast.ReturnStatement:
+		c.compile(node.ReturnValue)
+		c.emit(OpReturn)
+		// Nothing after a return runs, but the statement still has to
+		// account for its value.
+		c.push(1)
+	case *ast.BreakStatement:
+		c.jumpOut(node.Label, true)
+	case *ast.ContinueStatement:
+		c.jumpOut(node.Label, false)
+
+	default:
+		// imports, spreads, and current args (...)
+		c.evalNode(node)
+	}
+}
+
+func (c *compiler) ifExpression(ie *ast.IfExpression) {
+	c.compile(ie.Condition)
+	jumpToElse := c.emit(OpJumpNotTruthy, 0)
+	depth := c.depth
+
+	c.statements(ie.Consequence.Statements)
+	jumpToEnd := c.emit(OpJump, 0)
+
+	c.depth = depth
+	c.patch(jumpToElse, 0, len(c.unit.code))
+	if ie.Alternative != nil {
+		c.statements(ie.Alternative.Statements)
+	} else {
+		c.emit(OpNull)
+	}
+	c.patch(jumpToEnd, 0, len(c.unit.code))
+}
+
+// enterLoop starts a loop whose continue goes to the next instruction.
+func (c *compiler) enterLoop(label string) *compiledLoop {
+	l := &compiledLoop{
+		label: label,
+		cont:  len(c.unit.code),
+		stack: c.depth,
+	}
+	c.loops = append(c.loops, l)
+	return l
+}
+
+// exitLoop ends a loop, so break goes to the next instruction.
+func (c *compiler) exitLoop(l *compiledLoop) {
+	l.brk = len(c.unit.code)
+	for _, offset := range l.breakJumps {
+		c.patch(offset, 1, l.brk)
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+// forLoop compiles a for loop, which is always true, like the
+// tree-walker's.
+func (c *compiler) forLoop(fle *ast.ForLoopExpression) {
+	l := c.enterLoop(fle.Label)
+	c.compile(fle.Condition)
+	exit := c.emit(OpJumpNotTruthy, 0)
+	c.statements(fle.Consequence.Statements)
+	c.emit(OpPop)
+	c.emit(OpJump, l.cont)
+	c.patch(exit, 0, len(c.unit.code))
+	c.exitLoop(l)
+	c.emit(OpTrue)
+}
+
+func (c *compiler) foreach(fle *ast.ForeachStatement) {
+	index := noOperand
+	if fle.Index != "" {
+		index = c.operand(fle.IndexSlot)
+	}
+	ident := c.operand(fle.IdentSlot)
+
+	c.compile(fle.Value)
+	c.emit(OpForeach)
+
+	l := c.enterLoop(fle.Label)
+	next := c.emit(OpIterNext, 0, ident, index)
+	if fle.Pattern != nil {
+		c.emit(OpGetLocal, 0, ident, c.name(fle.Ident))
+		c.emit(OpDestructure, c.node(fle.Pattern), 0)
+		c.emit(OpPop)
+	}
+	c.statements(fle.Body.Statements)
+	c.emit(OpPop)
+	c.emit(OpJump, l.cont)
+	c.patch(next, 0, len(c.unit.code))
+	c.exitLoop(l)
+
+	c.emit(OpForeachEnd)
+}
+
+// jumpOut compiles a break or continue.
+func (c *compiler) jumpOut(label string, isBreak bool) {
+	for i := len(c.loops) - 1; i >= 0; i-- {
+		l := c.loops[i]
+		if label != "" && l.label != label {
+			continue
+		}
+		if isBreak {
+			l.breakJumps = append(l.breakJumps,
+				c.emit(OpJumpOut, l.stack, 0))
+		} else {
+			c.emit(OpJumpOut, l.stack, l.cont)
+		}
+		c.push(1)
+		return
+	}
+
+	// The loop is outside of this unit, so the unit which ran the try
+	// expression it's in has to deal with it.
+	var completion OBJ = &object.Continue{Label: label}
+	if isBreak {
+		completion = &object.Break{Label: label}
+	}
+	c.emit(OpComplete, c.constant(completion))
+	c.push(1)
+}
+
+func (c *compiler) tryExpression(te *ast.TryExpression) {
+	t := &compiledTry{block: c.compileBlock(blockUnit, te.Block)}
+	if te.Catch != nil {
+		t.catch = c.compileBlock(blockUnit, te.Catch)
+	}
+	t.catchIdent = te.CatchIdent
+	if te.Finally != nil {
+		t.finally = c.compileBlock(blockUnit, te.Finally)
+	}
+	for i := len(c.loops) - 1; i >= 0; i-- {
+		t.loops = append(t.loops, c.loops[i])
+	}
+
+	c.unit.tries = append(c.unit.tries, t)
+	c.emit(OpTry, c.operand(len(c.unit.tries)-1))
+}
@@ -0,0 +1,601 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/zacanger/cozy/object"
+)
+
+// Converting between Go values and cozy objects, so Go code can hand
+// values and functions to cozy without writing type switches by hand.
+//
+//   Go                            cozy
+//   bool                          boolean
+//   ints, uints                   integer
+//   floats                        float
+//   string, []byte                string
+//   time.Time                     string (RFC 3339)
+//   slices, arrays                array
+//   maps                          hash
+//   structs                       hash of the exported fields
+//   error                         error
+//   funcs                         builtin
+//   nil                           null
+//
+// Struct fields are named by their `cozy` tag, then their `json` tag, and
+// then the field name; a tag of "-" skips the field.
+
+var (
+	objectType = reflect.TypeOf((*OBJ)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	timeType   = reflect.TypeOf(time.Time{})
+	bytesType  = reflect.TypeOf([]byte(nil))
+)
+
+// ToObject converts a Go value to a cozy object. Objects are returned
+// as they are.
+func ToObject(v interface{}) (OBJ, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (OBJ, error) {
+	return toObjectSeen(v, map[visit]bool{})
+}
+
+// visit is a pointer, map, or slice being converted, to catch values
+// which contain themselves.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func toObjectSeen(v reflect.Value, seen map[visit]bool) (OBJ, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) &&
+			v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(OBJ), nil
+	}
+
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		return &object.String{Value: t.Format(time.RFC3339Nano)}, nil
+	}
+
+	if v.Type().Implements(errorType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) &&
+			v.IsNil() {
+			return NULL, nil
+		}
+		err := v.Interface().(error)
+		return &object.Error{Message: err.Error(), BuiltinCall: true}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			key := visit{v.Pointer(), v.Type()}
+			if seen[key] {
+				return nil, fmt.Errorf("can't convert %s, it contains itself", v.Type())
+			}
+			seen[key] = true
+			defer delete(seen, key)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return nativeBoolToBooleanObject(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows a cozy integer", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return toObjectSeen(v.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if v.Type() == bytesType {
+			return &object.String{Value: string(v.Bytes())}, nil
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]OBJ, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := toObjectSeen(v.Index(i), seen)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %s", i, err)
+			}
+			elements[i] = e
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toObjectSeen(iter.Key(), seen)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			val, err := toObjectSeen(iter.Value(), seen)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", key.Inspect(), err)
+			}
+			nameFunc(iter.Value(), val, key.Inspect())
+			pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: val}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	case reflect.Struct:
+		m := make(StringObjectMap)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			val, err := toObjectSeen(v.Field(i), seen)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", name, err)
+			}
+			nameFunc(v.Field(i), val, name)
+			m[name] = val
+		}
+		return NewHash(m), nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		// It's named after the key or field it's in, if it's in one.
+		return WrapFunc("fn", v.Interface())
+	}
+
+	return nil, fmt.Errorf("can't convert %s to a cozy value", v.Type())
+}
+
+// nameFunc names a builtin which was just made from a Go function after
+// the key or field it's in.
+func nameFunc(v reflect.Value, obj OBJ, name string) {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if b, ok := obj.(*object.Builtin); ok && v.Kind() == reflect.Func {
+		b.Signature.Name = name
+	}
+}
+
+// fieldName returns the name a struct field has in cozy, and whether it
+// should be there at all.
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		// unexported
+		return "", false
+	}
+	for _, key := range []string{"cozy", "json"} {
+		tag := strings.Split(f.Tag.Get(key), ",")[0]
+		if tag == "-" {
+			return "", false
+		}
+		if tag != "" {
+			return tag, true
+		}
+	}
+	return f.Name, true
+}
+
+// FromObject converts a cozy object to a Go value, storing it in the
+// value target points to. Cozy functions converted to Go functions are
+// called in the environment they were defined in; see FromObjectIn.
+func FromObject(obj OBJ, target interface{}) error {
+	return FromObjectIn(nil, obj, target)
+}
+
+// FromObjectIn is like FromObject, but cozy functions converted to Go
+// functions are called from env, so they're subject to the permissions,
+// limits, and cancellation of its runtime.
+func FromObjectIn(env *ENV, obj OBJ, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return errors.New("FromObject needs a non-nil pointer")
+	}
+	v, err := fromObject(env, obj, ptr.Elem().Type())
+	if err != nil {
+		return err
+	}
+	ptr.Elem().Set(v)
+	return nil
+}
+
+// fromObject converts obj to a value of type t. Functions are called
+// from env, if it isn't nil.
+func fromObject(env *ENV, obj OBJ, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+
+	// interface{} gets a plain Go value
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if v := obj.ToInterface(); v != nil {
+			return reflect.ValueOf(v), nil
+		}
+		return reflect.Zero(t), nil
+	}
+
+	// cozy objects are handed over as they are
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	if obj.Type() == object.NULL_OBJ {
+		return reflect.Zero(t), nil
+	}
+
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("can't use %s as %s", obj.Type(), t)
+	}
+
+	if t == timeType {
+		switch o := obj.(type) {
+		case *object.String:
+			tm, err := time.Parse(time.RFC3339Nano, o.Value)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(tm), nil
+		case *object.Integer:
+			// milliseconds, like time.unix
+			return reflect.ValueOf(time.UnixMilli(o.Value)), nil
+		}
+		return mismatch()
+	}
+
+	if e, ok := obj.(*object.Error); ok {
+		if t.Kind() == reflect.Interface && errorType.Implements(t) {
+			return reflect.ValueOf(errors.New(e.Message)), nil
+		}
+		return mismatch()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		v, err := fromObject(env, obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(v)
+		return ptr, nil
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			if reflect.Zero(t).OverflowInt(i.Value) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			return reflect.ValueOf(i.Value).Convert(t), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*object.Integer); ok {
+			if i.Value < 0 {
+				return reflect.Value{}, fmt.Errorf("can't use negative %d as %s", i.Value, t)
+			}
+			if reflect.Zero(t).OverflowUint(uint64(i.Value)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			return reflect.ValueOf(uint64(i.Value)).Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch n := obj.(type) {
+		case *object.Float:
+			f = n.Value
+		case *object.Integer:
+			f = float64(n.Value)
+		default:
+			return mismatch()
+		}
+		if reflect.Zero(t).OverflowFloat(f) {
+			return reflect.Value{}, fmt.Errorf("%g overflows %s", f, t)
+		}
+		return reflect.ValueOf(f).Convert(t), nil
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Slice, reflect.Array:
+		if s, ok := obj.(*object.String); ok && t == bytesType {
+			return reflect.ValueOf([]byte(s.Value)), nil
+		}
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			break
+		}
+		var v reflect.Value
+		if t.Kind() == reflect.Slice {
+			v = reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+		} else {
+			if t.Len() != len(arr.Elements) {
+				return reflect.Value{}, fmt.Errorf(
+					"can't use ARRAY of length %d as %s", len(arr.Elements), t)
+			}
+			v = reflect.New(t).Elem()
+		}
+		for i, e := range arr.Elements {
+			ev, err := fromObject(env, e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("index %d: %s", i, err)
+			}
+			v.Index(i).Set(ev)
+		}
+		return v, nil
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			break
+		}
+		v := reflect.MakeMapWithSize(t, len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			key, err := fromObject(env, pair.Key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			val, err := fromObject(env, pair.Value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf(
+					"key %s: %s", pair.Key.Inspect(), err)
+			}
+			v.SetMapIndex(key, val)
+		}
+		return v, nil
+	case reflect.Struct:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			break
+		}
+		v := reflect.New(t).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			key := &object.String{Value: name}
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				continue
+			}
+			fv, err := fromObject(env, pair.Value, t.Field(i).Type)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("field %s: %s", name, err)
+			}
+			v.Field(i).Set(fv)
+		}
+		return v, nil
+	case reflect.Func:
+		switch obj.(type) {
+		case *object.Function, *object.Builtin:
+			return makeGoFunc(env, obj, t), nil
+		}
+	}
+
+	return mismatch()
+}
+
+// makeGoFunc wraps a cozy function so Go code can call it as t, from
+// env, or if that's nil the environment the function was defined in. If
+// the cozy function raises an error it's returned as the last result
+// when t returns an error, and otherwise the results are left as zero
+// values.
+func makeGoFunc(env *ENV, fn OBJ, t reflect.Type) reflect.Value {
+	if env == nil {
+		env = object.NewEnvironment()
+		if f, ok := fn.(*object.Function); ok {
+			env = f.Env
+		}
+	}
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		setErr := func(err error) []reflect.Value {
+			if n := t.NumOut(); n > 0 && t.Out(n-1) == errorType {
+				out[n-1] = reflect.ValueOf(&err).Elem()
+			}
+			return out
+		}
+
+		args := make([]OBJ, 0, len(in))
+		for i, a := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < a.Len(); j++ {
+					arg, err := toObject(a.Index(j))
+					if err != nil {
+						return setErr(err)
+					}
+					args = append(args, arg)
+				}
+				continue
+			}
+			arg, err := toObject(a)
+			if err != nil {
+				return setErr(err)
+			}
+			args = append(args, arg)
+		}
+
+		res := ApplyFunction(env, fn, args)
+		if isError(res) {
+			return setErr(errors.New(res.(*object.Error).Message))
+		}
+
+		// Anything other than a trailing error gets the result; if
+		// there's more than one they get the elements of an array.
+		var targets []int
+		for i := 0; i < t.NumOut(); i++ {
+			if i == t.NumOut()-1 && t.Out(i) == errorType {
+				break
+			}
+			targets = append(targets, i)
+		}
+		results := []OBJ{res}
+		if len(targets) > 1 {
+			arr, ok := res.(*object.Array)
+			if !ok || len(arr.Elements) != len(targets) {
+				return setErr(fmt.Errorf(
+					"expected %d results, got %s", len(targets), res.Inspect()))
+			}
+			results = arr.Elements
+		}
+		for i, idx := range targets {
+			v, err := fromObject(env, results[i], t.Out(idx))
+			if err != nil {
+				return setErr(err)
+			}
+			out[idx] = v
+		}
+		return out
+	})
+}
+
+// WrapFunc turns any Go function into a cozy builtin with the given name,
+// which is used in its errors. Arguments are converted with FromObject and
+// results with ToObject; its signature is worked out from the function's
+// type, so the arguments are checked before it's called. A non-nil error
+// as the last result is raised in cozy; more than one other result is
+// returned as an array.
+func WrapFunc(name string, fn interface{}) (*object.Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, fmt.Errorf("can't wrap %T, it's not a function", fn)
+	}
+	t := v.Type()
+
+	params := make([]object.Param, t.NumIn())
+	for i := range params {
+		pt := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			params[i] = object.VariadicArg(fmt.Sprintf("arg%d", i+1), goTypes(pt.Elem())...)
+			break
+		}
+		params[i] = object.Arg(fmt.Sprintf("arg%d", i+1), goTypes(pt)...)
+	}
+
+	return newBuiltin(name, func(env *ENV, args ...OBJ) OBJ {
+		want := t.NumIn()
+		in := make([]reflect.Value, len(args))
+		for i, a := range args {
+			var pt reflect.Type
+			if t.IsVariadic() && i >= want-1 {
+				pt = t.In(want - 1).Elem()
+			} else {
+				pt = t.In(i)
+			}
+			av, err := fromObject(env, a, pt)
+			if err != nil {
+				return NewError("%s: argument %d: %s", name, i+1, err)
+			}
+			in[i] = av
+		}
+
+		out := v.Call(in)
+
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err, _ := out[n-1].Interface().(error); err != nil {
+				return NewError("%s", err)
+			}
+			out = out[:n-1]
+		}
+
+		results := make([]OBJ, len(out))
+		for i, o := range out {
+			r, err := toObject(o)
+			if err != nil {
+				return NewError("%s: result %d: %s", name, i+1, err)
+			}
+			results[i] = r
+		}
+
+		switch len(results) {
+		case 0:
+			return NULL
+		case 1:
+			return results[0]
+		default:
+			return &object.Array{Elements: results}
+		}
+	}, params), nil
+}
+
+// goTypes returns the types of cozy objects which can be converted to a
+// Go type, or none if anything can be.
+func goTypes(t reflect.Type) []object.Type {
+	if objectType.AssignableTo(t) || t.Kind() == reflect.Interface && t != errorType {
+		return nil
+	}
+	switch {
+	case t == timeType:
+		return []object.Type{object.STRING_OBJ, object.INTEGER_OBJ}
+	case t == bytesType:
+		return []object.Type{object.STRING_OBJ, object.NULL_OBJ}
+	case t == errorType:
+		return []object.Type{object.ERROR_OBJ, object.NULL_OBJ}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return []object.Type{object.BOOLEAN_OBJ}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return []object.Type{object.INTEGER_OBJ}
+	case reflect.Float32, reflect.Float64:
+		return []object.Type{object.FLOAT_OBJ, object.INTEGER_OBJ}
+	case reflect.String:
+		return []object.Type{object.STRING_OBJ}
+	case reflect.Ptr:
+		if types := goTypes(t.Elem()); types != nil {
+			return append(types, object.NULL_OBJ)
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		return []object.Type{object.ARRAY_OBJ, object.NULL_OBJ}
+	case reflect.Map, reflect.Struct:
+		return []object.Type{object.HASH_OBJ, object.NULL_OBJ}
+	case reflect.Func:
+		return []object.Type{object.FUNCTION_OBJ, object.BUILTIN_OBJ, object.NULL_OBJ}
+	}
+	return nil
+}
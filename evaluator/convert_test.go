@@ -0,0 +1,272 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/object"
+	"github.com/zacanger/cozy/parser"
+)
+
+type testUser struct {
+	Name    string   `json:"name"`
+	Age     int      `cozy:"age"`
+	Tags    []string `json:"tags"`
+	Admin   bool
+	Skipped string `json:"-"`
+	secret  string
+}
+
+func evalWithEnv(input string, env *ENV) OBJ {
+	p := parser.New(lexer.New(input))
+	return Eval(p.ParseProgram(), env)
+}
+
+func TestToObject(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint16(7), "7"},
+		{2.5, "2.5"},
+		{"hi", "hi"},
+		{[]byte("bytes"), "bytes"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{errors.New("oh no"), "ERROR: oh no"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "2020-01-02T03:04:05Z"},
+		{&object.Integer{Value: 4}, "4"},
+		{(*testUser)(nil), "null"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("ToObject(%v) failed: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("ToObject(%v) wrong. expected=%q, got=%q",
+				tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	if _, err := ToObject(make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel")
+	}
+	if _, err := ToObject(uint64(math.MaxUint64)); err == nil {
+		t.Errorf("expected an error converting a uint64 too big for an integer")
+	}
+
+	type node struct {
+		Next *node
+	}
+	loop := &node{}
+	loop.Next = loop
+	if _, err := ToObject(loop); err == nil {
+		t.Errorf("expected an error converting a struct which contains itself")
+	}
+	m := map[string]interface{}{}
+	m["self"] = m
+	if _, err := ToObject(m); err == nil {
+		t.Errorf("expected an error converting a map which contains itself")
+	}
+
+	// the same value twice isn't a cycle
+	shared := &node{}
+	obj, err := ToObject([]*node{shared, shared})
+	if err != nil || obj.Inspect() != "[{Next: null}, {Next: null}]" {
+		t.Errorf("shared values wrong. got=%v (%v)", obj, err)
+	}
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	u := testUser{
+		Name:    "zac",
+		Age:     30,
+		Tags:    []string{"a", "b"},
+		Admin:   true,
+		Skipped: "x",
+		secret:  "y",
+	}
+	obj, err := ToObject(&u)
+	if err != nil {
+		t.Fatalf("ToObject failed: %s", err)
+	}
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		t.Fatalf("expected a hash. got=%T", obj)
+	}
+	if len(hash.Pairs) != 4 {
+		t.Errorf("expected 4 fields. got=%s", hash.Inspect())
+	}
+
+	var back testUser
+	if err := FromObject(obj, &back); err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	u.Skipped = ""
+	u.secret = ""
+	if !reflect.DeepEqual(u, back) {
+		t.Errorf("round trip wrong. expected=%+v, got=%+v", u, back)
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	var i int
+	if err := FromObject(&object.Integer{Value: 5}, &i); err != nil || i != 5 {
+		t.Errorf("int wrong. got=%d (%v)", i, err)
+	}
+
+	var f float32
+	if err := FromObject(&object.Integer{Value: 2}, &f); err != nil || f != 2 {
+		t.Errorf("float wrong. got=%f (%v)", f, err)
+	}
+
+	var m map[string][]int
+	obj := testEval(`{"a": [1, 2], "b": []}`)
+	if err := FromObject(obj, &m); err != nil {
+		t.Fatalf("map failed: %s", err)
+	}
+	if !reflect.DeepEqual(m, map[string][]int{"a": {1, 2}, "b": {}}) {
+		t.Errorf("map wrong. got=%v", m)
+	}
+
+	var val interface{}
+	if err := FromObject(testEval(`[1, "a", null]`), &val); err != nil {
+		t.Fatalf("interface failed: %s", err)
+	}
+	if !reflect.DeepEqual(val, []interface{}{int64(1), "a", nil}) {
+		t.Errorf("interface wrong. got=%#v", val)
+	}
+
+	var tm time.Time
+	err := FromObject(&object.String{Value: "2020-01-02T03:04:05Z"}, &tm)
+	if err != nil || tm.Year() != 2020 {
+		t.Errorf("time wrong. got=%s (%v)", tm, err)
+	}
+
+	var e error
+	if err := FromObject(testEval(`error("bad")`), &e); err != nil ||
+		e == nil || e.Error() != "bad" {
+		t.Errorf("error wrong. got=%v (%v)", e, err)
+	}
+
+	var small int8
+	err = FromObject(&object.Integer{Value: 300}, &small)
+	if err == nil || err.Error() != "300 overflows int8" {
+		t.Errorf("expected an overflow error. got=%d (%v)", small, err)
+	}
+	var u uint
+	err = FromObject(&object.Integer{Value: -1}, &u)
+	if err == nil || err.Error() != "can't use negative -1 as uint" {
+		t.Errorf("expected a negative error. got=%d (%v)", u, err)
+	}
+	var u8 uint8
+	if err := FromObject(&object.Integer{Value: 256}, &u8); err == nil {
+		t.Errorf("expected an overflow error. got=%d", u8)
+	}
+	err = FromObject(&object.Float{Value: 1e300}, &f)
+	if err == nil || err.Error() != "1e+300 overflows float32" {
+		t.Errorf("expected an overflow error. got=%f (%v)", f, err)
+	}
+
+	var s string
+	err = FromObject(&object.Integer{Value: 1}, &s)
+	if err == nil || err.Error() != "can't use INTEGER as string" {
+		t.Errorf("expected a mismatch error. got=%v", err)
+	}
+}
+
+func TestWrapFunc(t *testing.T) {
+	env := object.NewEnvironment()
+	add, _ := WrapFunc("add", func(a, b int) int { return a + b })
+	env.SetLet("add", add)
+	join, _ := WrapFunc("join", func(sep string, xs ...string) string {
+		out := ""
+		for i, x := range xs {
+			if i > 0 {
+				out += sep
+			}
+			out += x
+		}
+		return out
+	})
+	env.SetLet("join", join)
+	div, _ := WrapFunc("div", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	})
+	env.SetLet("div", div)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`add(1, 2)`, "3"},
+		{`join("-", "a", "b", "c")`, "a-b-c"},
+		{`div(1, 2)`, "0.5"},
+		{`try { div(1, 0) } catch e { e.message }`, "division by zero"},
+		{`try { add(1) } catch e { e.message }`,
+			"add: wrong number of arguments. got=1, want=2"},
+		{`try { add(1, "x") } catch e { e.message }`,
+			"add: argument 2 (arg2) must be INTEGER, got STRING"},
+		{`try { join("-", "a", 1) } catch e { e.message }`,
+			"join: argument 3 (arg2) must be STRING, got INTEGER"},
+		{`add.doc()`, "add(arg1: INTEGER, arg2: INTEGER)"},
+	}
+	for _, tt := range tests {
+		res := evalWithEnv(tt.input, env)
+		if res.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q",
+				tt.input, tt.expected, res.Inspect())
+		}
+	}
+}
+
+func TestWrappedCallbacksUseTheCallersRuntime(t *testing.T) {
+	rt := NewRuntime()
+	rt.SetPermissions(&Permissions{})
+	env := rt.NewEnvironment()
+	call, _ := WrapFunc("call", func(get func(string) (string, error)) error {
+		_, err := get("HOME")
+		return err
+	})
+	env.SetLet("call", call)
+
+	res := evalWithEnv(`call(sys.getenv)`, env)
+	if !isError(res) || !strings.Contains(res.(*object.Error).Message, "PermissionError: ") {
+		t.Errorf("the callback should be sandboxed. got=%s", res.Inspect())
+	}
+}
+
+func TestCozyFunctionToGo(t *testing.T) {
+	var double func(int) (int, error)
+	if err := FromObject(testEval(`fn (x) { x * 2 }`), &double); err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	n, err := double(21)
+	if err != nil || n != 42 {
+		t.Errorf("double wrong. got=%d (%v)", n, err)
+	}
+
+	var fail func() error
+	if err := FromObject(testEval(`fn () { panic(error("nope")) }`), &fail); err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	if err := fail(); err == nil || err.Error() != "nope" {
+		t.Errorf("expected an error. got=%v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package evaluator
+
+import (
+	"github.com/zacanger/cozy/ast"
+	"github.com/zacanger/cozy/object"
+)
+
+// bind sets a variable, in the slot the resolver gave it, or as a global.
+func bind(env *ENV, ident *ast.Identifier, val OBJ, readonly bool) {
+	switch {
+	case ident.Local != nil:
+		setLocal(env, ident.Local, ident.Value, val)
+	case readonly:
+		env.SetLet(ident.Value, val)
+	default:
+		env.Set(ident.Value, val)
+	}
+}
+
+// destructure binds the names in a pattern to the parts of a value: the
+// elements of an array in order, or the values of a hash, or a module,
+// with the names as keys. Names which are missing get their defaults,
+// like a function's parameters, or null. It returns an error if the value
+// can't be unpacked, and nil otherwise.
+func destructure(env *ENV, p *ast.Pattern, val OBJ, readonly bool) OBJ {
+	var get func(i int, name string) (OBJ, bool)
+	var rest *object.Array
+	if p.IsHash() {
+		var hash *object.Hash
+		switch v := val.(type) {
+		case *object.Hash:
+			hash = v
+		case *object.Module:
+			hash, _ = v.Attrs.(*object.Hash)
+		}
+		if hash == nil {
+			return patternError(p, "cannot unpack %s as a hash", val.Type())
+		}
+		get = func(_ int, name string) (OBJ, bool) {
+			pair, ok := hash.Pairs[(&object.String{Value: name}).HashKey()]
+			return pair.Value, ok
+		}
+	} else {
+		array, ok := val.(*object.Array)
+		if !ok {
+			return patternError(p, "cannot unpack %s as an array", val.Type())
+		}
+		get = func(i int, _ string) (OBJ, bool) {
+			if i < len(array.Elements) {
+				return array.Elements[i], true
+			}
+			return nil, false
+		}
+		if p.Rest != nil {
+			rest = &object.Array{Elements: []OBJ{}}
+			if len(array.Elements) > len(p.Names) {
+				rest.Elements = append(rest.Elements, array.Elements[len(p.Names):]...)
+			}
+		}
+	}
+
+	for i, name := range p.Names {
+		v, ok := get(i, name.Value)
+		if !ok {
+			v = NULL
+			if def, ok := p.Defaults[name.Value]; ok {
+				if v = Eval(def, env); isError(v) {
+					return v
+				}
+			}
+		}
+		bind(env, name, v, readonly)
+	}
+	if rest != nil {
+		bind(env, p.Rest, rest, readonly)
+	}
+	return nil
+}
+
+// patternError is an error unpacking a value, where its pattern is.
+func patternError(p *ast.Pattern, format string, a ...interface{}) OBJ {
+	err := NewError(format, a...)
+	err.Pos = p.Pos()
+	return err
+}
@@ -0,0 +1,73 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/zacanger/cozy/object"
+)
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; [a, b, rest]", "[1, 2, [3, 4]]"},
+		{"let [a, b = 2, ...rest] = [1]; [a, b, rest]", "[1, 2, []]"},
+		{"let [a, b] = [1]; [a, b]", "[1, null]"},
+		{"let [a, b = a + 1] = [1]; b", "2"},
+		{`let {name, port = 80} = {"name": "x"}; [name, port]`, "[x, 80]"},
+		{`let {port = 80} = {"port": 8080}; port`, "8080"},
+		{`let {missing} = {}; missing`, "null"},
+		{"let f = fn () { mutable [a, b] = [1, 2]; a += b; a }; f()", "3"},
+		{"let f = fn (xs) { let [x, ...more] = xs; more }; f([1, 2, 3])", "[2, 3]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%v", tt.input, tt.expected, evaluated)
+		}
+	}
+}
+
+func TestDestructuringLoopsAndParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn (pairs) { mutable t = 0; foreach [k, v] in pairs { t += k * v }; t }; f([[1, 2], [3, 4]])", 14},
+		{`let f = fn (xs) { mutable t = 0; foreach i, {n = 1} in xs { t += i + n }; t }; f([{"n": 5}, {}])`, 7},
+		{"let f = fn ([a, b], c) { a + b + c }; f([1, 2], 3)", 6},
+		{`let f = fn ({x, y = 10}) { x + y }; f({"x": 1})`, 11},
+		{`let f = fn ({x} = {"x": 4}) { x }; f()`, 4},
+		{"let f = fn ([a, ...rest]) { rest }; let r = f([1, 2, 3]); r[0] + r[1]", 5},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a] = 5", "1:5: cannot unpack INTEGER as an array"},
+		{"let {a} = [1]", "1:5: cannot unpack ARRAY as a hash"},
+		{"let f = fn ([a]) { a }; f({})", "1:13: cannot unpack HASH as an array"},
+		{"let f = fn (xs) { foreach [a] in xs {} }; f([1])", "1:27: cannot unpack INTEGER as an array"},
+		{"let [a] = [1]\na = 2", "2:1: cannot modify 'a'; it was defined with let"},
+		{"let f = fn (a, b = a + true) { b }; f(1)", "1:22: type mismatch: INTEGER + BOOLEAN"},
+		{`let f = fn (a, [b] = a) { b }; f("x")`, "1:16: cannot unpack STRING as an array"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected an error, got=%v", tt.input, evaluated)
+			continue
+		}
+		if got := err.Pos.String() + ": " + err.Message; got != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -1,20 +1,19 @@
-// Package evaluator contains the core of our interpreter, which walks
-// the AST produced by the parser and evaluates the user-submitted program.
+// Package evaluator contains the core of our interpreter, which evaluates
+// the AST produced by the parser, either by compiling it to bytecode for a
+// small stack VM, or by walking it.
 package evaluator
 
 import (
-	"context"
-	"fmt"
 	"io/ioutil"
 	"math"
-	"os"
+	"sort"
 	"strings"
 
 	"github.com/zacanger/cozy/ast"
 	"github.com/zacanger/cozy/lexer"
 	"github.com/zacanger/cozy/object"
 	"github.com/zacanger/cozy/parser"
-	"github.com/zacanger/cozy/utils"
+	"github.com/zacanger/cozy/token"
 )
 
 // pre-defined objects
@@ -22,7 +21,6 @@ var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
-	CTX   = context.Background()
 )
 
 // OBJ is a type alias to save some typing
@@ -34,22 +32,31 @@ type ENV = object.Environment
 // The built-in functions / standard-library methods are stored here.
 var builtins = map[string]*object.Builtin{}
 
-// Eval is our core function for evaluating nodes.
+// Eval is our core function for evaluating nodes. A program is compiled
+// and run on the VM, unless the environment's runtime uses the
+// tree-walker. Evaluation stops with an error when the context of the
+// environment's runtime is cancelled, or the run goes over its limits.
 func Eval(node ast.Node, env *ENV) OBJ {
-	return evalContext(context.Background(), node, env)
-}
+	// We test our context and limits at every node.
+	if err := runtimeOf(env).step(env.Context); err != nil {
+		err.Pos = node.Pos()
+		return err
+	}
 
-// evalContext is our core function for evaluating nodes.
-// The context.Context provided can be used to cancel a running script instance.
-func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
-	// We test our context at every iteration of our main-loop.
-	select {
-	case <-ctx.Done():
-		return &object.Error{Message: ctx.Err().Error()}
-	default:
-		// noop
+	res := evalNode(node, env)
+
+	// Record where the error came from; errors raised deeper in the
+	// tree will already have a position, so only the innermost node
+	// gets to set it.
+	if e, ok := res.(*object.Error); ok && !e.Pos.IsValid() {
+		e.Pos = node.Pos()
 	}
 
+	return res
+}
+
+// evalNode evaluates a single node.
+func evalNode(node ast.Node, env *ENV) OBJ {
 	switch node := node.(type) {
 	//Statements
 	case *ast.Program:
@@ -84,9 +91,8 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 			return right
 		}
 		res := evalInfixExpression(node.Operator, left, right, env)
-		if isError(res) {
-			fmt.Printf("Error: %s\n", res.Inspect())
-			utils.ExitConditionally(1)
+		if e, ok := res.(*object.Error); ok {
+			e.Pos = node.Pos()
 		}
 		return res
 
@@ -100,16 +106,43 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 		return evalForLoopExpression(node, env)
 	case *ast.ForeachStatement:
 		return evalForeachExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
+		if isError(val) {
+			return val
+		}
 		return &object.ReturnValue{Value: val}
+	case *ast.BreakStatement:
+		return &object.Break{Label: node.Label}
+	case *ast.ContinueStatement:
+		return &object.Continue{Label: node.Label}
 	case *ast.MutableStatement:
 		val := Eval(node.Value, env)
-		env.Set(node.Name.Value, val)
+		if isError(val) {
+			return val
+		}
+		if node.Pattern != nil {
+			if err := destructure(env, node.Pattern, val, false); err != nil {
+				return err
+			}
+			return val
+		}
+		bind(env, node.Name, val, false)
 		return val
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		env.SetLet(node.Name.Value, val)
+		if isError(val) {
+			return val
+		}
+		if node.Pattern != nil {
+			if err := destructure(env, node.Pattern, val, true); err != nil {
+				return err
+			}
+			return val
+		}
+		bind(env, node.Name, val, true)
 		return val
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
@@ -123,7 +156,9 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 			Env:        env,
 			Body:       body,
 			Defaults:   defaults,
+			Patterns:   node.Patterns,
 			DocString:  docstring,
+			Locals:     node.Locals,
 		}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
@@ -132,6 +167,9 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 		}
 
 		args := evalExpression(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
 
 		// check for current args (...)
 		if len(args) > 0 {
@@ -142,25 +180,13 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 			}
 		}
 
-		res := ApplyFunction(env, function, args)
-
-		switch t := res.(type) {
-		case *object.Error:
-			c := 1
-			if t.Code != nil {
-				c = int(*t.Code)
-			}
-			if !t.BuiltinCall {
-				fmt.Fprintf(
-					os.Stderr,
-					"Error calling `%s` : %s\n",
-					node.Function,
-					res.Inspect(),
-				)
-				utils.ExitConditionally(c)
-			}
+		// The function calling this one returns first, and then
+		// ApplyFunction makes the call.
+		if fn, ok := function.(*object.Function); ok && node.Tail {
+			return &object.TailCall{Fn: fn, Args: args}
 		}
-
+		res := ApplyFunction(env, function, args)
+		calledFrom(res, node.Pos())
 		return res
 
 	case *ast.ArrayLiteral:
@@ -170,7 +196,17 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 		}
 		return &object.Array{Elements: elements}
 	case *ast.StringLiteral:
-		return &object.String{Value: Interpolate(node.Value, env)}
+		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		var out strings.Builder
+		for _, part := range node.Parts {
+			val := Eval(part, env)
+			if isError(val) {
+				return val
+			}
+			out.WriteString(val.Inspect())
+		}
+		return &object.String{Value: out.String()}
 	case *ast.SpreadLiteral:
 		return evalSpread(node, env)
 	case *ast.CurrentArgsLiteral:
@@ -189,6 +225,15 @@ func evalContext(ctx context.Context, node ast.Node, env *ENV) OBJ {
 			return index
 		}
 		return evalIndexExpression(left, index, env)
+	case *ast.MemberExpression:
+		if node.Name != nil {
+			return evalIdentifier(node.Name, env)
+		}
+		obj := Eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalIndexExpression(obj, &object.String{Value: node.Property.Value}, env)
 	case *ast.AssignStatement:
 		return evalAssignStatement(node, env)
 	case *ast.HashLiteral:
@@ -204,7 +249,10 @@ func evalBlockStatement(block *ast.BlockStatement, env *ENV) OBJ {
 		result = Eval(statement, env)
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ {
+			if rt == object.RETURN_VALUE_OBJ ||
+				rt == object.BREAK_OBJ ||
+				rt == object.CONTINUE_OBJ ||
+				isError(result) {
 				return result
 			}
 		}
@@ -217,41 +265,49 @@ func evalBlockStatement(block *ast.BlockStatement, env *ENV) OBJ {
 // which isn't ideal, but we also do this when working with string
 // interpolation.
 func EvalModule(name string) OBJ {
-	filename := FindModule(name)
+	return defaultRuntime.EvalModule(name)
+}
+
+// EvalModule evaluates the named module within this runtime.
+func (r *Runtime) EvalModule(name string) OBJ {
+	filename := r.findModule(name)
 	if filename == "" {
 		return NewError("ImportError: no module named '%s'", name)
 	}
+	if err := r.checkRead(filename); err != nil {
+		return err
+	}
 
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return NewError("IOError: error reading module '%s': %s", name, err)
 	}
 
-	l := lexer.New(string(b))
+	l := lexer.NewWithFile(filename, string(b))
 	p := parser.New(l)
 
 	module := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		return NewError("ParseError: %s", p.Errors())
+		msgs := make([]string, len(p.Errors()))
+		for i, err := range p.Errors() {
+			msgs[i] = err.Error()
+		}
+		return NewError("ParseError: %s", strings.Join(msgs, "; "))
 	}
 
-	env := object.NewEnvironment()
+	env := r.NewEnvironment()
 	Eval(module, env)
 
 	return env.ExportedHash()
 }
 
-var importCache map[string]OBJ
-
-func init() {
-	importCache = make(map[string]OBJ)
-}
-
 func evalImportExpression(ie *ast.ImportExpression, env *ENV) OBJ {
+	rt := runtimeOf(env)
+
 	// treat modules as singletons;
 	// we don't allow modifying anythig exported by modules, but this
 	// means we can skip re-evaling modules on subsequent imports
-	ev, ok := importCache[ie.Name.String()]
+	ev, ok := rt.cachedModule(ie.Name.String())
 	if ok {
 		return ev
 	}
@@ -262,13 +318,13 @@ func evalImportExpression(ie *ast.ImportExpression, env *ENV) OBJ {
 	}
 
 	if s, ok := name.(*object.String); ok {
-		attrs := EvalModule(s.Value)
+		attrs := rt.EvalModule(s.Value)
 		if isError(attrs) {
 			return attrs
 		}
 
 		m := &object.Module{Name: s.Value, Attrs: attrs}
-		importCache[ie.Name.String()] = m
+		rt.cacheModule(ie.Name.String(), m)
 		return m
 	}
 
@@ -302,39 +358,38 @@ func evalPostfixExpression(
 	operator string,
 	node *ast.PostfixExpression,
 ) OBJ {
+	name := node.Token.Literal
+	var val OBJ
+	if node.Local != nil {
+		val = env.Local(node.Local.Depth, node.Local.Slot)
+	} else {
+		val, _ = env.Get(name)
+	}
+	if val == nil {
+		return NewError("%s is unknown", name)
+	}
+
+	arg, ok := val.(*object.Integer)
+	if !ok {
+		return NewError("%s is not an int", name)
+	}
+
+	var res OBJ
 	switch operator {
 	case "++":
-		val, ok := env.Get(node.Token.Literal)
-		if !ok {
-			return NewError("%s is unknown", node.Token.Literal)
-		}
-
-		switch arg := val.(type) {
-		case *object.Integer:
-			v := arg.Value
-			env.Set(node.Token.Literal, &object.Integer{Value: v + 1})
-			return arg
-		default:
-			return NewError("%s is not an int", node.Token.Literal)
-		}
+		res = &object.Integer{Value: arg.Value + 1}
 	case "--":
-		val, ok := env.Get(node.Token.Literal)
-		if !ok {
-			return NewError("%s is unknown", node.Token.Literal)
-		}
-
-		switch arg := val.(type) {
-		case *object.Integer:
-			v := arg.Value
-			env.Set(node.Token.Literal, &object.Integer{Value: v - 1})
-			return arg
-		default:
-			return NewError("%s is not an int", node.Token.Literal)
-		}
-
+		res = &object.Integer{Value: arg.Value - 1}
 	default:
 		return NewError("unknown operator: %s", operator)
 	}
+
+	if node.Local != nil {
+		env.SetLocal(node.Local.Depth, node.Local.Slot, res)
+	} else {
+		env.Set(name, res)
+	}
+	return arg
 }
 
 func evalBangOperatorExpression(right OBJ) OBJ {
@@ -654,90 +709,53 @@ func evalIfExpression(ie *ast.IfExpression, env *ENV) OBJ {
 	return NULL
 }
 
-func evalAssignStatement(a *ast.AssignStatement, env *ENV) (val OBJ) {
+func evalAssignStatement(a *ast.AssignStatement, env *ENV) OBJ {
 	evaluated := Eval(a.Value, env)
 	if isError(evaluated) {
 		return evaluated
 	}
+	return assign(env, a.Name, a.Operator, evaluated)
+}
+
+// assign sets a variable which already exists, returning the value it was
+// set to.
+func assign(env *ENV, ident *ast.Identifier, operator string, evaluated OBJ) OBJ {
+	name := ident.Value
+	set := func(val OBJ) {
+		if ident.Local != nil {
+			setLocal(env, ident.Local, name, val)
+		} else {
+			env.Set(name, val)
+		}
+	}
 
 	// An assignment is generally:
 	//    variable = value
 	// But we cheat and reuse the implementation for:
 	//    i += 4
 	// In this case we record the "operator" as "+="
-	switch a.Operator {
-	case "+=":
-		// Get the current value
-		current, ok := env.Get(a.Name.String())
-		if !ok {
-			return NewError("%s is unknown", a.Name.String())
-		}
-
-		res := evalInfixExpression("+=", current, evaluated, env)
-		if isError(res) {
-			fmt.Printf("Error handling += %s\n", res.Inspect())
-			return res
-		}
-
-		env.Set(a.Name.String(), res)
-		return res
-
-	case "-=":
-		// Get the current value
-		current, ok := env.Get(a.Name.String())
-		if !ok {
-			return NewError("%s is unknown", a.Name.String())
-		}
-
-		res := evalInfixExpression("-=", current, evaluated, env)
-		if isError(res) {
-			fmt.Printf("Error handling -= %s\n", res.Inspect())
-			return res
-		}
-
-		env.Set(a.Name.String(), res)
-		return res
-
-	case "*=":
-		// Get the current value
-		current, ok := env.Get(a.Name.String())
-		if !ok {
-			return NewError("%s is unknown", a.Name.String())
-		}
-
-		res := evalInfixExpression("*=", current, evaluated, env)
-		if isError(res) {
-			fmt.Printf("Error handling *= %s\n", res.Inspect())
-			return res
-		}
-
-		env.Set(a.Name.String(), res)
-		return res
-
-	case "/=":
+	switch operator {
+	case "+=", "-=", "*=", "/=":
 		// Get the current value
-		current, ok := env.Get(a.Name.String())
-		if !ok {
-			return NewError("%s is unknown", a.Name.String())
+		current := evalIdentifier(ident, env)
+		if isError(current) {
+			return NewError("%s is unknown", name)
 		}
 
-		res := evalInfixExpression("/=", current, evaluated, env)
+		res := evalInfixExpression(operator, current, evaluated, env)
 		if isError(res) {
-			fmt.Printf("Error handling /= %s\n", res.Inspect())
 			return res
 		}
 
-		env.Set(a.Name.String(), res)
+		set(res)
 		return res
 
 	case "=":
-		_, ok := env.Get(a.Name.String())
-		if !ok {
-			fmt.Printf("Setting unknown variable '%s' is an error!\n", a.Name.String())
-			utils.ExitConditionally(1)
+		if isError(evalIdentifier(ident, env)) {
+			return NewError("setting unknown variable '%s' is an error", name)
 		}
 
-		env.Set(a.Name.String(), evaluated)
+		set(evaluated)
 	}
 
 	return evaluated
@@ -751,10 +769,12 @@ func evalForLoopExpression(fle *ast.ForLoopExpression, env *ENV) OBJ {
 			return condition
 		}
 		if isTruthy(condition) {
-			rt := Eval(fle.Consequence, env)
-			if !isError(rt) &&
-				(rt.Type() == object.RETURN_VALUE_OBJ || rt.Type() == object.ERROR_OBJ) {
-				return rt
+			stop, ret := loopControl(Eval(fle.Consequence, env), fle.Label)
+			if ret != nil {
+				return ret
+			}
+			if stop {
+				break
 			}
 		} else {
 			break
@@ -763,63 +783,95 @@ func evalForLoopExpression(fle *ast.ForLoopExpression, env *ENV) OBJ {
 	return rt
 }
 
+// loopControl looks at the result of running a loop body once, and
+// reports whether the loop should stop. If the loop should also return
+// something (a return value, an error, or a break/continue aimed at an
+// outer loop) that's returned too.
+func loopControl(rt OBJ, label string) (bool, OBJ) {
+	switch r := rt.(type) {
+	case *object.Break:
+		if r.Label == "" || r.Label == label {
+			return true, nil
+		}
+		return true, r
+	case *object.Continue:
+		if r.Label == "" || r.Label == label {
+			return false, nil
+		}
+		return true, r
+	case *object.ReturnValue:
+		return true, rt
+	}
+	if isError(rt) {
+		return true, rt
+	}
+	return false, nil
+}
+
 // handle "foreach x [,y] in .."
 func evalForeachExpression(fle *ast.ForeachStatement, env *ENV) OBJ {
 	// expression
 	val := Eval(fle.Value, env)
-
-	helper, ok := val.(object.Iterable)
-	if !ok {
-		return NewError(
-			"%s object doesn't implement the Iterable interface",
-			val.Type(),
-		)
+	if isError(val) {
+		return val
 	}
 
-	// The one/two values we're going to permit
-	var permit []string
-	permit = append(permit, fle.Ident)
-	if fle.Index != "" {
-		permit = append(permit, fle.Index)
+	iter, err := iterate(val, env)
+	if err != nil {
+		return err
 	}
 
-	// Create a new environment for the block
-	// This will allow writing EVERYTHING to the parent scope,
-	// except the two variables named in the permit-array
-	child := object.NewTemporaryScope(env, permit)
-
-	// Reset the state of any previous iteration.
-	helper.Reset()
-
 	// Get the initial values.
-	ret, idx, ok := helper.Next()
+	ret, idx, ok := iter.Next()
 
 	for ok {
-		// Set the index + name
-		child.Set(fle.Ident, ret)
-
-		idxName := fle.Index
-		if idxName != "" {
-			child.Set(fle.Index, idx)
+		// Set the index + name, in the slots the resolver gave them
+		env.SetLocal(0, fle.IdentSlot, ret)
+		if fle.Index != "" {
+			env.SetLocal(0, fle.IndexSlot, idx)
+		}
+		if fle.Pattern != nil {
+			if err := destructure(env, fle.Pattern, ret, false); err != nil {
+				return err
+			}
 		}
 
-		// Eval the block
-		rt := Eval(fle.Body, child)
-
-		// If we got an error/return then we handle it.
-		if !isError(rt) &&
-			(rt.Type() == object.RETURN_VALUE_OBJ ||
-				rt.Type() == object.ERROR_OBJ) {
+		// Eval the block, and handle any error, return, break, or
+		// continue.
+		stop, rt := loopControl(Eval(fle.Body, env), fle.Label)
+		if rt != nil {
 			return rt
 		}
+		if stop {
+			break
+		}
 
 		// Loop again
-		ret, idx, ok = helper.Next()
+		ret, idx, ok = iter.Next()
+	}
+	if iter.Err != nil {
+		return iter.Err
 	}
 
 	return NULL
 }
 
+// iterate returns an iterator over a value for a foreach loop. Channels
+// are received from until they're closed.
+func iterate(val OBJ, env *ENV) (*object.Iterator, *object.Error) {
+	if ch, ok := val.(*object.Channel); ok {
+		return channelIterator(env, ch), nil
+	}
+	helper, ok := val.(object.Iterable)
+	if !ok {
+		return nil, NewError(
+			"%s object doesn't implement the Iterable interface",
+			val.Type(),
+		)
+	}
+	return helper.Iter(), nil
+}
+
 func isTruthy(obj OBJ) bool {
 	switch obj {
 	case TRUE:
@@ -834,35 +886,119 @@ func isTruthy(obj OBJ) bool {
 }
 
 func evalProgram(program *ast.Program, env *ENV) OBJ {
+	// Mistakes like undefined variables are found before anything
+	// runs, unless the program was resolved when it was parsed.
+	if !program.Resolved {
+		if errs := Resolve(program, env); len(errs) > 0 {
+			return &object.Error{Message: errs[0].Message, Pos: errs[0].Pos}
+		}
+	}
+	env.AddSlots(program.Locals)
+
+	// Programs are compiled for the VM, unless they're too big for
+	// the bytecode.
+	if runtimeOf(env).backend == VM {
+		if u, err := compileProgram(program); err == nil {
+			return runUnit(u, env)
+		}
+	}
+
 	var result OBJ
 	for _, statement := range program.Statements {
 		result = Eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
+		case *object.Error:
+			if !result.BuiltinCall {
+				return result
+			}
 		}
 	}
 
 	return result
 }
 
+// isError reports whether obj is a raised error, which stops evaluation
+// until something catches it. Errors made with the error() builtin are
+// plain values until they're passed to panic.
 func isError(obj OBJ) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+	if e, ok := obj.(*object.Error); ok {
+		return !e.BuiltinCall
 	}
 	return false
 }
 
+// evalTryExpression runs the try block, handing any error it raises to
+// the catch block, and then always runs the finally block.
+func evalTryExpression(te *ast.TryExpression, env *ENV) OBJ {
+	res := Eval(te.Block, env)
+
+	if isError(res) && te.Catch != nil {
+		// The caught error is a value again, so it can be inspected,
+		// passed around, or raised again with panic.
+		caught := *res.(*object.Error)
+		caught.BuiltinCall = true
+		caught.CaughtIn = env.Function
+
+		if te.CatchIdent != nil {
+			setLocal(env, te.CatchIdent.Local, te.CatchIdent.Value, &caught)
+		}
+		res = Eval(te.Catch, env)
+	}
+
+	if te.Finally != nil {
+		// Anything which leaves the finally block early wins over
+		// the result of the try or catch blocks.
+		fin := Eval(te.Finally, env)
+		if fin != nil {
+			switch fin.Type() {
+			case object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return fin
+			}
+			if isError(fin) {
+				return fin
+			}
+		}
+	}
+
+	if res == nil {
+		return NULL
+	}
+	return res
+}
+
 func evalIdentifier(node *ast.Identifier, env *ENV) OBJ {
-	if val, ok := env.Get(node.Value); ok {
+	if node.Local != nil {
+		return getLocal(env, node.Local, node.Value)
+	}
+	return evalName(node.Value, env)
+}
+
+// getLocal returns the value of a local variable. Its slot is empty until
+// it's set, like when a closure runs before the let it uses.
+func getLocal(env *ENV, loc *ast.Local, name string) OBJ {
+	if val := env.Local(loc.Depth, loc.Slot); val != nil {
+		return val
+	}
+	return NewError("identifier not found: " + name)
+}
+
+// setLocal sets a local variable.
+func setLocal(env *ENV, loc *ast.Local, name string, val OBJ) {
+	object.NameFunction(name, val)
+	env.SetLocal(loc.Depth, loc.Slot, val)
+}
+
+// evalName looks up a variable, or failing that a builtin.
+func evalName(name string, env *ENV) OBJ {
+	if val, ok := env.Get(name); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := runtimeOf(env).builtin(name); ok {
 		return builtin
 	}
-	fmt.Println("identifier not found: " + node.Value)
-	utils.ExitConditionally(1)
-	return NewError("identifier not found: " + node.Value)
+	return NewError("identifier not found: " + name)
 }
 
 func evalExpression(exps []ast.Expression, env *ENV) []OBJ {
@@ -898,6 +1034,8 @@ func evalIndexExpression(left, index OBJ, env *ENV) OBJ {
 		return evalStringIndexExpression(left, index, env)
 	case left.Type() == object.MODULE_OBJ:
 		return evalModuleIndexExpression(left, index, env)
+	case left.Type() == object.ERROR_OBJ:
+		return evalErrorIndexExpression(left, index, env)
 	default:
 		if fn, ok := objectGetMethod(left, index, env); ok {
 			return fn
@@ -912,6 +1050,51 @@ func evalModuleIndexExpression(module, index OBJ, env *ENV) OBJ {
 	return evalHashIndexExpression(moduleObject.Attrs, index, env)
 }
 
+// evalErrorIndexExpression gives access to the fields of an error, so
+// caught errors can be looked at with e.message, e.code, and e.data.
+func evalErrorIndexExpression(obj, index OBJ, env *ENV) OBJ {
+	e := obj.(*object.Error)
+	key, ok := index.(*object.String)
+	if ok {
+		switch key.Value {
+		case "message":
+			return &object.String{Value: e.Message}
+		case "code":
+			if e.Code == nil {
+				return NULL
+			}
+			return &object.Integer{Value: int64(*e.Code)}
+		case "data":
+			if e.Data == nil {
+				return NULL
+			}
+			return e.Data
+		case "stack":
+			return stackArray(e)
+		}
+	}
+	if fn, ok := objectGetMethod(obj, index, env); ok {
+		return fn
+	}
+	return NewError("error has no field %s", index.Inspect())
+}
+
+// stackArray returns the stack trace of an error, most recent call last,
+// with where it was in each function.
+func stackArray(e *object.Error) *object.Array {
+	lines := e.TraceLines()
+	elements := make([]OBJ, len(lines))
+	for i, l := range lines {
+		elements[i] = NewHash(StringObjectMap{
+			"function": &object.String{Value: l.Function},
+			"file":     &object.String{Value: l.Pos.File},
+			"line":     &object.Integer{Value: int64(l.Pos.Line)},
+			"column":   &object.Integer{Value: int64(l.Pos.Column)},
+		})
+	}
+	return &object.Array{Elements: elements}
+}
+
 func evalArrayIndexExpression(array, index OBJ, env *ENV) OBJ {
 	arrayObject := array.(*object.Array)
 	switch t := index.(type) {
@@ -1001,29 +1184,103 @@ func evalHashLiteral(node *ast.HashLiteral, env *ENV) OBJ {
 func ApplyFunction(env *ENV, fn OBJ, args []OBJ) OBJ {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendEnv)
-		return upwrapReturnValue(evaluated)
+		depth := env.CallDepth + 1
+		if max := runtimeOf(env).maxDepth(); depth > max {
+			return NewError("maximum call depth of %d exceeded", max)
+		}
+		// Calls in tail position are made here, one after another, so
+		// they don't add to the depth.
+		for {
+			res := callFunction(env, fn, args, depth)
+			tc, ok := res.(*object.TailCall)
+			if !ok {
+				if e, ok := res.(*object.Error); ok && !e.BuiltinCall {
+					e.Stack = append(e.Stack, object.StackFrame{Function: fn.Name()})
+				}
+				return res
+			}
+			fn, args = tc.Fn, tc.Args
+		}
 	case *object.Builtin:
+		if fn.Signature != nil {
+			if err := fn.Signature.Check(args); err != nil {
+				return err
+			}
+		}
 		return fn.Fn(env, args...)
 	default:
 		return NewError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []OBJ) *ENV {
-	env := object.NewEnclosedEnvironment(fn.Env, args)
+// calledFrom records where the calls an error was raised in were made
+// from, once it's back at the call expression they started with. That
+// includes the calls made by builtins along the way.
+func calledFrom(res OBJ, pos token.Position) {
+	e, ok := res.(*object.Error)
+	if !ok || e.BuiltinCall {
+		return
+	}
+	for i := len(e.Stack) - 1; i >= 0 && !e.Stack[i].Pos.IsValid(); i-- {
+		e.Stack[i].Pos = pos
+	}
+}
 
-	// Set the defaults
-	for key, val := range fn.Defaults {
-		env.Set(key, Eval(val, env))
+// callFunction runs the body of a function, called from the caller's
+// environment, which may end with a tail call to make.
+func callFunction(caller *ENV, fn *object.Function, args []OBJ, depth int) OBJ {
+	extendEnv, err := extendFunctionEnv(caller, fn, args, depth)
+	if err != nil {
+		return err
 	}
+	if fn.Self != nil {
+		extendEnv.SetLet("self", fn.Self)
+	}
+	if u, ok := fn.Compiled.(*unit); ok {
+		return runUnit(u, extendEnv)
+	}
+	evaluated := Eval(fn.Body, extendEnv)
+	return upwrapReturnValue(evaluated)
+}
+
+// extendFunctionEnv makes the frame of a call, with the arguments in
+// their parameters' slots, returning an error if any can't be unpacked.
+func extendFunctionEnv(caller *ENV, fn *object.Function, args []OBJ, depth int) (*ENV, OBJ) {
+	env := object.NewFrame(fn.Env, args, fn.Locals)
+	env.CallDepth = depth
+	env.Function = object.StackFrame{Function: fn.Name()}.FunctionName()
+	// Whatever cancels the caller cancels the functions it calls.
+	env.Context = caller.Context
+
+	// The parameters are the first slots; any which weren't passed get
+	// their defaults.
 	for paramIdx, param := range fn.Parameters {
 		if paramIdx < len(args) {
-			env.Set(param.Value, args[paramIdx])
+			env.SetLocal(0, paramIdx, args[paramIdx])
+		} else if def, ok := fn.Defaults[param.Value]; ok {
+			val := Eval(def, env)
+			if isError(val) {
+				return nil, val
+			}
+			env.SetLocal(0, paramIdx, val)
 		}
 	}
-	return env
+
+	// Then any which are patterns are unpacked.
+	for paramIdx, param := range fn.Parameters {
+		p, ok := fn.Patterns[param.Value]
+		if !ok {
+			continue
+		}
+		arg := env.Local(0, paramIdx)
+		if arg == nil {
+			arg = NULL
+		}
+		if err := destructure(env, p, arg, false); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
 }
 
 func upwrapReturnValue(obj OBJ) OBJ {
@@ -1034,14 +1291,92 @@ func upwrapReturnValue(obj OBJ) OBJ {
 }
 
 // RegisterBuiltin registers a built-in function. This is used to register
-// our "standard library" functions.
-func RegisterBuiltin(name string, fn object.BuiltinFunction) {
-	builtins[name] = &object.Builtin{Fn: fn}
+// our "standard library" functions. The params declare the arguments the
+// function takes, which are checked before it's called, so it can safely
+// index and type-assert them. A function with no params takes no
+// arguments; one which takes any can declare a VariadicArg.
+func RegisterBuiltin(
+	name string,
+	fn object.BuiltinFunction,
+	params ...object.Param,
+) {
+	builtins[name] = newBuiltin(name, fn, params)
+}
+
+// methods are the built-in methods of object types which need the
+// evaluator, because they block, so they have to be cancellable, or call
+// functions. They're found before the methods of the objects themselves.
+var methods = map[object.Type]map[string]*method{}
+
+// method is a built-in method, called with the object it was found on.
+type method struct {
+	name   string
+	fn     func(env *ENV, self OBJ, args ...OBJ) OBJ
+	params []object.Param
+}
+
+// registerMethod registers a built-in method of a type. The params are
+// checked like those of a builtin, in errors like "channel.send: ...".
+func registerMethod(
+	t object.Type,
+	name string,
+	fn func(env *ENV, self OBJ, args ...OBJ) OBJ,
+	params ...object.Param,
+) {
+	if methods[t] == nil {
+		methods[t] = map[string]*method{}
+	}
+	methods[t][name] = &method{
+		name:   strings.ToLower(string(t)) + "." + name,
+		fn:     fn,
+		params: params,
+	}
+}
+
+// bind returns the method as a builtin which calls it on self.
+func (m *method) bind(self OBJ) *object.Builtin {
+	return newBuiltin(m.name, func(env *ENV, args ...OBJ) OBJ {
+		return m.fn(env, self, args...)
+	}, m.params)
+}
+
+func newBuiltin(
+	name string,
+	fn object.BuiltinFunction,
+	params []object.Param,
+) *object.Builtin {
+	return &object.Builtin{
+		Fn:        fn,
+		Signature: &object.Signature{Name: name, Params: params},
+	}
+}
+
+// BuiltinSignatures returns the signatures of every registered builtin,
+// sorted by name, for help and docs.
+func BuiltinSignatures() []*object.Signature {
+	sigs := make([]*object.Signature, 0, len(builtins))
+	for _, b := range builtins {
+		sigs = append(sigs, b.Signature)
+	}
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].Name < sigs[j].Name
+	})
+	return sigs
+}
+
+// noEnv adapts a builtin which doesn't need the environment.
+func noEnv(fn func(args ...OBJ) OBJ) object.BuiltinFunction {
+	return func(env *ENV, args ...OBJ) OBJ {
+		return fn(args...)
+	}
 }
 
 func objectGetMethod(o, key OBJ, env *ENV) (ret OBJ, ok bool) {
 	switch k := key.(type) {
 	case *object.String:
+		if m, ok := methods[o.Type()][k.Value]; ok {
+			return m.bind(o), true
+		}
 		var fn object.BuiltinFunction
 		if fn = o.GetMethod(k.Value); fn != nil {
 			return &object.Builtin{Fn: fn}, true
@@ -1083,11 +1418,7 @@ func objectGetMethod(o, key OBJ, env *ENV) (ret OBJ, ok bool) {
 			// Try to find that function in our environment.
 			if val, ok := env.Get(name); ok {
 				if fn, ok := val.(*object.Function); ok {
-					copyFn := *fn
-					emptyArgs := make([]OBJ, 0)
-					copyFn.Env = object.NewEnclosedEnvironment(fn.Env, emptyArgs)
-					copyFn.Env.Set("self", o)
-					return &copyFn, true
+					return fn.WithSelf(o), true
 				}
 				return val, true
 			}
@@ -1135,10 +1466,9 @@ func objectToNativeBoolean(o OBJ) bool {
 func evalSpread(node ast.Node, env *ENV) OBJ {
 	switch n := node.(type) {
 	case *ast.SpreadLiteral:
-		a := n.Right.TokenLiteral()
-		val, ok := env.Get(a)
-		if !ok {
-			return NewError("%s is unknown", a)
+		val := Eval(n.Right, env)
+		if isError(val) {
+			return val
 		}
 
 		switch ao := val.(type) {
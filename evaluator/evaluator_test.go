@@ -7,7 +7,6 @@ import (
 	"github.com/zacanger/cozy/lexer"
 	"github.com/zacanger/cozy/object"
 	"github.com/zacanger/cozy/parser"
-	"github.com/zacanger/cozy/utils"
 )
 
 func testEval(input string) OBJ {
@@ -129,6 +128,10 @@ func TestEvalArithmeticExpression(t *testing.T) {
 		{"3 & 6", 2},
 		{"1 << 2", 4},
 		{"4 >> 2", 1},
+		{"0xff & 0b1010", 10},
+		{"0o755 >> 6", 7},
+		{"1_000 * .5", 500},
+		{"2.5e3 - 1e-1", 2499.9},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -256,8 +259,6 @@ func TestErrorHandling(t *testing.T) {
 		{"foobar", "identifier not found: foobar"},
 		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
 	}
-	// set this so we don't os.Exit
-	utils.SetReplOrRun(true)
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
 		errObj, ok := evaluated.(*object.Error)
@@ -361,8 +362,16 @@ func TestBuiltinFunction(t *testing.T) {
 		{`util.len("four")`, 4},
 		{`util.len("天研")`, 2},
 		{`util.len("hello world")`, 11},
-		{`util.len(1)`, "argument to `len` not supported, got=INTEGER"},
-		{`util.len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`util.len(1)`, "util.len: argument 1 (value) must be STRING or DOCSTRING or ARRAY or NULL or HASH, got INTEGER"},
+		{`util.len("one", "two")`, "util.len: wrong number of arguments. got=2, want=1"},
+		{`json.deserialize()`, "json.deserialize: wrong number of arguments. got=0, want=1"},
+		{`time.unix(1)`, "time.unix: wrong number of arguments. got=1, want=0"},
+		{`sys.args("x")`, "sys.args: wrong number of arguments. got=1, want=0"},
+		{`sys.flag(1)`, "sys.flag: argument 1 (name) must be STRING, got INTEGER"},
+		{`net.listen(1)`, "net.listen: wrong number of arguments. got=1, want=2"},
+		{`net.read(1, 2, 3)`, "net.read: wrong number of arguments. got=3, want=1 to 2"},
+		{`fs.mv("a", 1)`, "fs.mv: argument 2 (dest) must be STRING, got INTEGER"},
+		{`core.await()`, "core.await: wrong number of arguments. got=0, want=1 to 2"},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -387,6 +396,27 @@ func TestBuiltinFunction(t *testing.T) {
 	}
 }
 
+func TestBuiltinMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`print.name()`, "print"},
+		{`let o = fs.open; o.doc()`, "fs.open(path: STRING, [mode: STRING])"},
+		{`print.doc()`, "print(values: ANY...)"},
+		{`try { time.sleep("1") } catch e { e.message }`,
+			"time.sleep: argument 1 (ms) must be INTEGER, got STRING"},
+		{`try { time.interval(0, fn () {}) } catch e { e.message }`,
+			"time.interval: ms must be positive"},
+		{`try { sys.exec("  ") } catch e { e.message }`, "sys.exec: the command is empty"},
+		{`try { sys.exec("definitely_not_a_cmd") } catch e { e.message }`,
+			`sys.exec: exec: "definitely_not_a_cmd": executable file not found in $PATH`},
+	}
+	for _, tt := range tests {
+		testStringObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := `[1, 2*2, 3+3]`
 	evaluated := testEval(input)
@@ -590,6 +620,25 @@ func TestHashIndexExpression(t *testing.T) {
 	}
 }
 
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let req = {"headers": {"host": 5}}; req.headers.host`, 5},
+		{`let f = fn () { {"x": {"y": 5}} }; f().x.y`, 5},
+		{`let obj = {"method": fn () { {"other": fn () { 5 }} }}; obj.method().other()`, 5},
+		{`let http.constants = {"status": {"ok": 200}}; http.constants.status.ok`, 200},
+		{`let sys.out = {"write": fn (s) { s }}; sys.out.write(5)`, 5},
+		{`let f = fn (fs) { fs.glob }; f({"glob": 5})`, 5},
+		{`let f = fn () { let a.b = {"c": 5}; a.b.c }; f()`, 5},
+		{`let a = {"b": {"c": 5}}; let f = fn () { a.b.c }; f()`, 5},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestForLoopExpression(t *testing.T) {
 	input := `
 fn () {
@@ -651,7 +700,17 @@ func TestStringInterpolation(t *testing.T) {
 		expected string
 	}{
 		{`let a = "123"; "abc{{a}}"`, "abc123"},
-		{`let a = "123"; "abc\\{{a}}"`, "abc{{a}}"},
+		{`let a = "123"; "abc\{{a}}"`, "abc{{a}}"},
+		{`let a = "123"; "abc\\{{a}}"`, "abc\\123"},
+		{`let a = [1, 2]; "{{a[0] + a[1]}} {{a}} {{a[1] * 10}}"`, "3 [1, 2] 20"},
+		{`let h = {"k": "v"}; "{{h[\"k\"]}}{{ {\"a\": 1}[\"a\"] }}"`, "v1"},
+		{`let a = "x"; "<{{ \"[{{a}}{{ \\\"{{a}}\\\" }}]\" }}>"`, "<[xx]>"},
+		{`let f = fn (n) { "n={{n}}" }; f(1) + f(2)`, "n=1n=2"},
+		// the strings in an interpolation needn't be escaped
+		{`let h = {"k": "v"}; "{{h["k"]}}{{ {"a": 1}["a"] }}"`, "v1"},
+		{`let a = "x"; "<{{ "[{{a}}{{ "{{a}}" + "}" }}]" }}>"`, "<[xx}]>"},
+		{`"{{ "a\"b" }}"`, `a"b`},
+		{`let f = fn () { mutable s = ""; foreach i in [1, 2, 3] { s += "{{i}}," }; s }; f()`, "1,2,3,"},
 	}
 
 	for _, tt := range tests {
@@ -659,3 +718,228 @@ func TestStringInterpolation(t *testing.T) {
 		testStringObject(t, evaluated, tt.expected)
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	input := "let f = fn(x) {\n  x + true\n}\nf(1)"
+	l := lexer.NewWithFile("main.cz", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	evaluated := Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Pos.String() != "main.cz:2:5" {
+		t.Errorf("wrong error position. got=%q", errObj.Pos.String())
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`fn () {
+	mutable i = 0
+	for (true) {
+		i++
+		if (i == 5) { break }
+	}
+	i
+}()`, 5},
+		{`fn () {
+	mutable sum = 0
+	foreach x in 1..10 {
+		if (x % 2 == 0) { continue }
+		sum += x
+	}
+	sum
+}()`, 25},
+		{`fn () {
+	mutable count = 0
+	outer: foreach x in 1..5 {
+		foreach y in 1..5 {
+			if (y == 3) { continue outer }
+			if (x == 4) { break outer }
+			count++
+		}
+	}
+	count
+}()`, 6},
+		{`fn () {
+	foreach x in 1..5 {
+		for (true) {
+			return x * 10
+		}
+	}
+}()`, 10},
+		// each loop has its own place in what it's iterating over
+		{`fn () {
+	let xs = [1, 2, 3]
+	mutable count = 0
+	foreach x in xs { foreach y in xs { count++ } }
+	count
+}()`, 9},
+	}
+	for _, tt := range tests {
+		testDecimalObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`try { 1 } catch e { 2 }`, 1},
+		{`try { 1 + "a" } catch e { 2 }`, 2},
+		{`try { panic(error({"message": "x", "code": 7})) } catch e { e.code }`, 7},
+		{`try { panic(error({"message": "x", "data": 3})) } catch (e) { e.data }`, 3},
+		{`fn () {
+	mutable n = 0
+	try { nope } catch { n = 1 } finally { n += 10 }
+	n
+}()`, 11},
+		{`fn () {
+	try { return 1 } finally { return 2 }
+}()`, 2},
+		{`fn () {
+	mutable n = 0
+	foreach x in 1..3 {
+		try { break } finally { n++ }
+	}
+	n
+}()`, 1},
+		{`let f = fn () { panic(error("inner")) }
+try { f(); 1 } catch e { 5 }`, 5},
+		{`let deep = fn (n) { 1 + deep(n + 1) }
+try { deep(0) } catch e { 6 }`, 6},
+		{`try {
+	try { panic(error("inner")) } catch e { panic(e) }
+} catch e { 9 }`, 9},
+	}
+	for _, tt := range tests {
+		testDecimalObject(t, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval(`try { panic(error("oh no")) } catch e { e.message }`)
+	testStringObject(t, evaluated, "oh no")
+
+	// without a catch block the error carries on after finally
+	evaluated = testEval(`try { panic(error("oh no")) } finally { 1 }`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.BuiltinCall {
+		t.Fatalf("expected a raised error. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "oh no" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	// errors made with error() are plain values, and don't stop anything
+	testDecimalObject(t, testEval(`let e = error("x"); 3`), int64(3))
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"sys.exit()", 0},
+		{"sys.exit(3); 1", 3},
+		// nothing catches it, and it stops finally blocks too
+		{"let f = fn () { try { sys.exit(2) } catch e { 1 } finally { return 9 } }; f(); 1", 2},
+		{"let g = fn () { sys.exit(4); 1 }; let f = fn () { g() + 1 }; f()", 4},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, NewRuntime().NewEnvironment())
+		e, ok := evaluated.(*object.Error)
+		if !ok || !e.Exit {
+			t.Errorf("%q: expected an exit. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if *e.Code != tt.expected {
+			t.Errorf("%q: wrong code. expected=%d, got=%d", tt.input, tt.expected, *e.Code)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Each of these recurses far deeper than the default depth limit.
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let count = fn (n, acc) { if n == 0 { return acc }; count(n - 1, acc + 1) }
+count(100000, 0)`, 100000},
+		{`let count = fn (n, acc) { if n == 0 { acc } else { return count(n - 1, acc + 1) } }
+count(100000, 0)`, 100000},
+		{`let even? = fn (n) { if n == 0 { true } else { odd?(n - 1) } }
+let odd? = fn (n) { if n == 0 { false } else { even?(n - 1) } }
+if even?(100001) { 1 } else { 2 }`, 2},
+		{`let find = fn (n) { foreach x in [1] { if n == 0 { return x } }; return find(n - 1) }
+find(100000)`, 1},
+		{`let sum = fn (xs, i = 0, acc = 0) { if i == util.len(xs) { return acc }; sum(xs, i + 1, acc + xs[i]) }
+sum(1..50000)`, 1250025000},
+		// a call inside try isn't a tail call, so the error is caught
+		{`let f = fn (n) { if n == 0 { panic(error("done")) }; f(n - 1) }
+let g = fn () { try { f(10) } catch e { 7 } }
+g()`, 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let f = fn (x) {
+    let y = x + true
+    y
+}
+let g = fn (x) {
+    let y = f(x)
+    y
+}
+let trace = fn () {
+    try { g(1) } catch e {
+        mutable s = ""
+        foreach l in e.stack { s += l["function"] + ":" + util.string(l["line"]) + "," }
+        s
+    }
+}
+trace()`
+	l := lexer.NewWithFile("main.cz", input)
+	program := parser.New(l).ParseProgram()
+	evaluated := Eval(program, object.NewEnvironment())
+	testStringObject(t, evaluated, "trace:10,g:6,f:2,")
+
+	// an error caught in the function which raised it
+	input = `let f = fn () {
+    try { panic(error("no")) } catch e {
+        let l = e.stack[0]
+        l["function"] + ":" + util.string(l["line"]) + ":" + util.string(l["column"]) + "," + util.string(util.len(e.stack))
+    }
+}
+f()`
+	testStringObject(t, testEval(input), "f:2:11,1")
+	testStringObject(t, testEval(`try { panic(error("no")) } catch e { e.stack[0]["function"] }`), "<main>")
+
+	// functions which end with a tail call have already returned
+	input = `let f = fn () { panic(error("no")) }
+let g = fn () { f() }
+g()`
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+	expected := "Traceback (most recent call last):\n" +
+		"  3:1, in <main>\n" +
+		"  1:17, in f\n" +
+		"ERROR: 1:17: no"
+	if errObj.Trace() != expected {
+		t.Errorf("wrong trace. expected=%q, got=%q", expected, errObj.Trace())
+	}
+}
@@ -0,0 +1,179 @@
+package evaluator
+
+import (
+	"net"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zacanger/cozy/object"
+)
+
+// AllowAll can be used as the only entry of a Permissions list to allow
+// everything of that kind.
+const AllowAll = "*"
+
+// Permissions sandboxes a runtime, limiting what its builtins may do to
+// the machine. Anything not listed is denied with a PermissionError.
+type Permissions struct {
+	// Read and Write are the files and directories which may be read
+	// or changed; a directory includes everything under it.
+	Read  []string
+	Write []string
+
+	// Net is the hosts which may be connected to, bound, or served on,
+	// as "host", "host:port", or ":port" for any host.
+	Net []string
+
+	// Run is the commands sys.exec may run, by name or path.
+	Run []string
+
+	// Env allows reading and setting environment variables.
+	Env bool
+}
+
+// SetPermissions sandboxes the runtime. With nil, the default, everything
+// is allowed.
+func (r *Runtime) SetPermissions(p *Permissions) {
+	r.permissions = p
+}
+
+func permissionError(format string, a ...interface{}) *object.Error {
+	return NewError("PermissionError: "+format, a...)
+}
+
+// resolvePath makes a path absolute and follows any symlinks, so that
+// neither ".." nor a link can be used to get out of an allowed directory.
+// Paths which don't exist yet are resolved through their parent.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	dir, file := filepath.Split(abs)
+	if dir == abs || file == "" {
+		return abs
+	}
+	return filepath.Join(resolvePath(dir), file)
+}
+
+func pathAllowed(allowed []string, path string) bool {
+	path = resolvePath(path)
+	for _, a := range allowed {
+		if a == AllowAll {
+			return true
+		}
+		a = resolvePath(a)
+		if path == a || strings.HasPrefix(path, a+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRead returns an error unless the path may be read.
+func (r *Runtime) checkRead(path string) *object.Error {
+	if r.permissions == nil || pathAllowed(r.permissions.Read, path) {
+		return nil
+	}
+	return permissionError("read access to '%s' denied (--allow-read)", path)
+}
+
+// checkWrite returns an error unless the path may be changed.
+func (r *Runtime) checkWrite(path string) *object.Error {
+	if r.permissions == nil || pathAllowed(r.permissions.Write, path) {
+		return nil
+	}
+	return permissionError("write access to '%s' denied (--allow-write)", path)
+}
+
+// checkNet returns an error unless the address, "host:port" or "host", may
+// be used.
+func (r *Runtime) checkNet(address string) *object.Error {
+	if r.permissions == nil {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	for _, a := range r.permissions.Net {
+		if a == AllowAll {
+			return nil
+		}
+		aHost, aPort, err := net.SplitHostPort(a)
+		if err != nil {
+			aHost, aPort = a, ""
+		}
+		if (aHost == "" || aHost == host) && (aPort == "" || aPort == port) {
+			return nil
+		}
+	}
+	return permissionError("net access to '%s' denied (--allow-net)", address)
+}
+
+// checkAnyNet returns an error unless some network access is allowed.
+func (r *Runtime) checkAnyNet() *object.Error {
+	if r.permissions == nil || len(r.permissions.Net) > 0 {
+		return nil
+	}
+	return permissionError("net access denied (--allow-net)")
+}
+
+// checkRun returns an error unless the command may be run.
+func (r *Runtime) checkRun(command string) *object.Error {
+	if r.permissions == nil {
+		return nil
+	}
+
+	path, _ := exec.LookPath(command)
+	for _, a := range r.permissions.Run {
+		if a == AllowAll || a == command {
+			return nil
+		}
+		if p, err := exec.LookPath(a); err == nil && p == path {
+			return nil
+		}
+	}
+	return permissionError("running '%s' denied (--allow-run)", command)
+}
+
+// checkEnv returns an error unless environment variables may be used.
+func (r *Runtime) checkEnv() *object.Error {
+	if r.permissions == nil || r.permissions.Env {
+		return nil
+	}
+	return permissionError("environment access denied (--allow-env)")
+}
+
+// urlAddress returns the "host:port" a url connects to.
+func urlAddress(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// serverAddress is what's checked when the http server listens on a port.
+// It listens on every interface, but is allowed by an entry for
+// localhost.
+func serverAddress(port int64) string {
+	return net.JoinHostPort("localhost", strconv.FormatInt(port, 10))
+}
+
+// isStdio reports whether a filename is one of the special names for
+// stdin, stdout, and stderr, which are always allowed.
+func isStdio(filename string) bool {
+	return filename == "!STDIN!" || filename == "!STDOUT!" ||
+		filename == "!STDERR!"
+}
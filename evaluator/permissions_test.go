@@ -0,0 +1,149 @@
+package evaluator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zacanger/cozy/object"
+)
+
+func sandboxedEnv(p *Permissions) *ENV {
+	rt := NewRuntime()
+	rt.SetPermissions(p)
+	return rt.NewEnvironment()
+}
+
+func TestPermissions(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	os.Mkdir(data, 0755)
+	os.WriteFile(filepath.Join(data, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("s"), 0644)
+	os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(data, "link.txt"))
+
+	env := sandboxedEnv(&Permissions{
+		Read:  []string{data},
+		Write: []string{filepath.Join(data, "out")},
+		Net:   []string{"localhost:8080", ":9000"},
+		Run:   []string{"echo"},
+	})
+
+	tests := []struct {
+		input   string
+		allowed bool
+	}{
+		{`fs.stat("` + data + `/a.txt")`, true},
+		{`fs.stat("` + data + `")`, true},
+		{`fs.stat("` + dir + `/secret.txt")`, false},
+		{`fs.stat("` + data + `/../secret.txt")`, false},
+		{`fs.stat("` + data + `/link.txt")`, false},
+		{`fs.mkdir("` + data + `/out/nested")`, true},
+		{`fs.mkdir("` + data + `/other")`, false},
+		{`fs.cp("` + data + `/a.txt", "` + data + `/out/b.txt")`, true},
+		{`fs.cp("` + data + `/a.txt", "` + data + `/b.txt")`, false},
+		{`fs.rm("` + data + `/a.txt")`, false},
+		{`fs.open("` + data + `/a.txt")`, true},
+		{`fs.open("` + data + `/a.txt", "w")`, false},
+		{`fs.open("` + data + `/new.txt")`, false},
+		{`sys.exec("echo hi")`, true},
+		{`sys.exec("ls")`, false},
+		{`sys.getenv("HOME")`, false},
+		{`sys.setenv("COZY_TEST", "1")`, false},
+		{`net.connect(0, "example.com:80")`, false},
+		{`http.create_client("GET", "http://example.com")`, false},
+		{`http.create_client("GET", "http://localhost:8081")`, false},
+	}
+
+	for _, tt := range tests {
+		res := evalWithEnv(tt.input, env)
+		denied := isError(res) &&
+			strings.HasPrefix(res.(*object.Error).Message, "PermissionError: ")
+		if denied == tt.allowed {
+			t.Errorf("%s: expected allowed=%t. got=%s",
+				tt.input, tt.allowed, res.Inspect())
+		}
+	}
+
+	glob := evalWithEnv(`fs.glob("`+dir+`/*/*")`, env).Inspect()
+	if !strings.Contains(glob, "a.txt") || strings.Contains(glob, "link.txt") {
+		t.Errorf("fs.glob should only list readable files. got=%s", glob)
+	}
+}
+
+func TestImportPermissions(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	os.Mkdir(lib, 0755)
+	os.WriteFile(filepath.Join(lib, "allowed.cz"), []byte("let x = 1"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret.cz"), []byte("let x = 2"), 0644)
+
+	rt := NewRuntime()
+	rt.SetPermissions(&Permissions{Read: []string{lib}})
+	rt.AddSearchPath(lib)
+	rt.AddSearchPath(dir)
+	env := rt.NewEnvironment()
+
+	if res := evalWithEnv(`import("allowed")`, env); isError(res) {
+		t.Errorf("import of a readable module failed: %s", res.Inspect())
+	}
+	res := evalWithEnv(`import("secret")`, env)
+	if !isError(res) ||
+		!strings.HasPrefix(res.(*object.Error).Message, "PermissionError: ") {
+		t.Errorf("import outside of --allow-read should be denied. got=%s", res.Inspect())
+	}
+}
+
+func TestStaticPermissions(t *testing.T) {
+	dir := t.TempDir()
+	public := filepath.Join(dir, "public")
+	os.Mkdir(public, 0755)
+	os.WriteFile(filepath.Join(public, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("s"), 0644)
+	os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(public, "link.txt"))
+
+	env := sandboxedEnv(&Permissions{Read: []string{public}})
+	if res := staticHandler(env, &object.String{Value: public}); isError(res) {
+		t.Fatalf("http.static failed: %s", res.Inspect())
+	}
+
+	// a symlink out of the directory is checked when it's served
+	for path, code := range map[string]int{"/a.txt": http.StatusOK, "/link.txt": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		runtimeOf(env).httpApp().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected status %d, got=%d", path, code, w.Code)
+		}
+	}
+}
+
+func TestCheckNet(t *testing.T) {
+	rt := NewRuntime()
+	rt.SetPermissions(&Permissions{Net: []string{"localhost:8080", ":9000", "example.com"}})
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"localhost:8080", true},
+		{"localhost:8081", false},
+		{"127.0.0.1:8080", false},
+		{"anything:9000", true},
+		{"example.com:443", true},
+		{"example.org:443", false},
+	}
+
+	for _, tt := range tests {
+		if allowed := rt.checkNet(tt.address) == nil; allowed != tt.allowed {
+			t.Errorf("%s: expected allowed=%t", tt.address, tt.allowed)
+		}
+	}
+
+	rt.SetPermissions(nil)
+	if rt.checkNet("example.org:443") != nil {
+		t.Errorf("everything should be allowed without permissions")
+	}
+}
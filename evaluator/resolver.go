@@ -0,0 +1,506 @@
+package evaluator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zacanger/cozy/ast"
+	"github.com/zacanger/cozy/token"
+)
+
+// ResolveError is a mistake found in a program before it runs, like using
+// a variable which was never defined, or assigning to one defined with
+// let.
+type ResolveError struct {
+	// Pos is where the error was found.
+	Pos token.Position `json:"pos"`
+
+	// Message describes the error.
+	Message string `json:"message"`
+}
+
+// Error returns the error in file:line:column: message form.
+func (e *ResolveError) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
+// binding is a variable the resolver knows about. Globals have no slot.
+type binding struct {
+	slot     int
+	readonly bool
+}
+
+// scope is a block which keeps its variables to itself: a function body,
+// a foreach loop, or a catch block with a name for the error. The
+// outermost scope of a program is the global one.
+type scope struct {
+	outer  *scope
+	vars   map[string]*binding
+	global bool
+}
+
+// frame is a function, or a program, whose local variables share one
+// environment at runtime.
+type frame struct {
+	outer *frame
+	// outerScope is the scope of the outer frame the function was
+	// defined in.
+	outerScope *scope
+	scope      *scope
+	names      []string
+	base       int
+
+	// functions defined in the frame, which are resolved once it's
+	// done, so they can see everything defined after them.
+	pending []pendingFunction
+}
+
+type pendingFunction struct {
+	literal *ast.FunctionLiteral
+	scope   *scope
+}
+
+type resolver struct {
+	env    *ENV
+	frame  *frame
+	errors []*ResolveError
+
+	// repl is whether the program is run in the REPL.
+	repl bool
+}
+
+// Resolve finds the variable each identifier in a program refers to,
+// before it's run in env, giving local variables slots in their
+// function's frame. Globals, which are what's defined at the top level,
+// are still looked up by name. It returns every mistake it finds.
+func Resolve(program *ast.Program, env *ENV) []*ResolveError {
+	r := &resolver{env: env, repl: runtimeOf(env).repl}
+	r.frame = &frame{
+		scope: &scope{vars: map[string]*binding{}, global: true},
+		base:  env.SlotCount(),
+	}
+	r.statements(program.Statements)
+	r.finishFrame()
+	program.Locals = r.frame.names
+	program.Resolved = len(r.errors) == 0
+
+	// Functions are resolved after everything around them, so the
+	// errors are put back in order.
+	sort.SliceStable(r.errors, func(i, j int) bool {
+		a, b := r.errors[i].Pos, r.errors[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return r.errors
+}
+
+func (r *resolver) errorf(pos token.Position, format string, a ...interface{}) {
+	r.errors = append(r.errors, &ResolveError{
+		Pos:     pos,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// lookup finds a variable by name, returning its binding and how many
+// frames out it is. Globals which were defined before the program, and
+// builtins, are found in the environment.
+func (r *resolver) lookup(name string) (*binding, int) {
+	depth := 0
+	s := r.frame.scope
+	for f := r.frame; f != nil; f = f.outer {
+		for ; s != nil; s = s.outer {
+			if b, ok := s.vars[name]; ok {
+				return b, depth
+			}
+		}
+		s = f.outerScope
+		depth++
+	}
+
+	if _, ok := r.env.Get(name); ok {
+		return &binding{slot: -1, readonly: r.env.Readonly(name)}, 0
+	}
+	if _, ok := runtimeOf(r.env).builtin(name); ok {
+		return &binding{slot: -1, readonly: true}, 0
+	}
+	return nil, 0
+}
+
+// local returns where a binding lives, or nil for a global.
+func local(b *binding, depth int) *ast.Local {
+	if b.slot < 0 {
+		return nil
+	}
+	return &ast.Local{Depth: depth, Slot: b.slot}
+}
+
+// lenient reports whether an unknown name is allowed, which it is in
+// functions in the REPL, since they may be defined before what they use.
+func (r *resolver) lenient() bool {
+	return r.repl && r.frame.outer != nil
+}
+
+// declare binds a name in a scope of the current frame.
+func (r *resolver) declare(s *scope, ident *ast.Identifier, readonly bool) {
+	b, ok := s.vars[ident.Value]
+	if !ok {
+		b = &binding{slot: -1}
+		if !s.global {
+			b.slot = r.frame.base + len(r.frame.names)
+			r.frame.names = append(r.frame.names, ident.Value)
+		}
+		s.vars[ident.Value] = b
+	}
+	b.readonly = readonly
+	ident.Local = local(b, 0)
+}
+
+// pushScope starts a new block scope in the current frame.
+func (r *resolver) pushScope() {
+	r.frame.scope = &scope{outer: r.frame.scope, vars: map[string]*binding{}}
+}
+
+func (r *resolver) popScope() {
+	r.frame.scope = r.frame.scope.outer
+}
+
+// functionScope returns the outermost scope of the current frame.
+func (r *resolver) functionScope() *scope {
+	s := r.frame.scope
+	for s.outer != nil {
+		s = s.outer
+	}
+	return s
+}
+
+// finishFrame resolves the functions defined in the current frame.
+func (r *resolver) finishFrame() {
+	for len(r.frame.pending) > 0 {
+		p := r.frame.pending[0]
+		r.frame.pending = r.frame.pending[1:]
+		r.function(p.literal, p.scope)
+	}
+}
+
+// function resolves a function literal in a frame of its own.
+func (r *resolver) function(fl *ast.FunctionLiteral, outerScope *scope) {
+	f := &frame{
+		outer:      r.frame,
+		outerScope: outerScope,
+		scope:      &scope{vars: map[string]*binding{}},
+	}
+	r.frame = f
+
+	// The parameters come first, so arguments go in the first slots.
+	for _, param := range fl.Parameters {
+		r.declare(f.scope, param, false)
+	}
+	for _, param := range fl.Parameters {
+		if p, ok := fl.Patterns[param.Value]; ok {
+			r.pattern(p, func(ident *ast.Identifier) {
+				r.declare(f.scope, ident, false)
+			})
+		}
+	}
+	for _, def := range fl.Defaults {
+		r.expression(def)
+	}
+	r.statements(fl.Body.Statements)
+	markTailCalls(fl.Body, true)
+	r.finishFrame()
+	fl.Locals = f.names
+
+	r.frame = f.outer
+}
+
+func (r *resolver) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		r.expression(s)
+	}
+}
+
+func (r *resolver) block(b *ast.BlockStatement) {
+	if b != nil {
+		r.statements(b.Statements)
+	}
+}
+
+// assignable finds a variable which is about to be modified, reporting an
+// error if it can't be.
+func (r *resolver) assignable(
+	pos token.Position,
+	name string,
+	unknown string,
+) (*ast.Local, bool) {
+	// Like reading it, setting self is left until runtime.
+	if name == "self" {
+		return nil, true
+	}
+	b, depth := r.lookup(name)
+	switch {
+	case b == nil:
+		if !r.lenient() {
+			r.errorf(pos, unknown, name)
+		}
+		return nil, false
+	case b.readonly:
+		r.errorf(pos, "cannot modify '%s'; it was defined with let", name)
+		return nil, false
+	}
+	return local(b, depth), true
+}
+
+// mutable binds a name set by a mutable statement.
+func (r *resolver) mutable(pos token.Position, ident *ast.Identifier) {
+	name := ident.Value
+	b, depth := r.lookup(name)
+	if b != nil && (b.slot >= 0 || !b.readonly) {
+		// It's setting a mutable variable which already exists.
+		if b.readonly {
+			r.errorf(pos, "cannot modify '%s'; it was defined with let", name)
+			return
+		}
+		ident.Local = local(b, depth)
+		return
+	}
+	// Otherwise it's a new variable for the whole function, even if it's
+	// defined inside a loop, which may shadow a global.
+	s := r.functionScope()
+	switch {
+	case s.global && !r.repl:
+		r.errorf(pos,
+			"no mutable variables at the top level; '%s' must be bound with let", name)
+		return
+	case s.global && b != nil:
+		r.errorf(pos, "cannot modify '%s'; it was defined with let", name)
+		return
+	}
+	r.declare(s, ident, false)
+}
+
+// pattern binds the names in a destructuring pattern in order, resolving
+// the default of each before it's bound, so defaults can use the names
+// before them.
+func (r *resolver) pattern(p *ast.Pattern, bind func(*ast.Identifier)) {
+	for _, name := range p.Names {
+		r.expression(p.Defaults[name.Value])
+		bind(name)
+	}
+	if p.Rest != nil {
+		bind(p.Rest)
+	}
+}
+
+// expression resolves a node, and everything in it.
+func (r *resolver) expression(node ast.Node) {
+	switch node := node.(type) {
+	case nil:
+		return
+	case *ast.ExpressionStatement:
+		r.expression(node.Expression)
+	case *ast.LetStatement:
+		r.expression(node.Value)
+		if node.Pattern != nil {
+			r.pattern(node.Pattern, func(ident *ast.Identifier) {
+				r.declare(r.frame.scope, ident, true)
+			})
+			return
+		}
+		r.declare(r.frame.scope, node.Name, true)
+	case *ast.MutableStatement:
+		r.expression(node.Value)
+		if node.Pattern != nil {
+			r.pattern(node.Pattern, func(ident *ast.Identifier) {
+				r.mutable(node.Pos(), ident)
+			})
+			return
+		}
+		r.mutable(node.Pos(), node.Name)
+	case *ast.AssignStatement:
+		r.expression(node.Value)
+		if node.Name == nil {
+			return
+		}
+		unknown := "setting unknown variable '%s' is an error"
+		if node.Operator != "=" {
+			unknown = "%s is unknown"
+		}
+		node.Name.Local, _ = r.assignable(node.Name.Pos(), node.Name.Value, unknown)
+	case *ast.PostfixExpression:
+		node.Local, _ = r.assignable(node.Pos(), node.Token.Literal, "%s is unknown")
+	case *ast.Identifier:
+		// self is whatever a method was looked up on, so it's only
+		// known at runtime.
+		if node.Value == "self" {
+			return
+		}
+		b, depth := r.lookup(node.Value)
+		if b == nil {
+			if !r.lenient() {
+				r.errorf(node.Pos(), "identifier not found: %s", node.Value)
+			}
+			return
+		}
+		node.Local = local(b, depth)
+
+	case *ast.ReturnStatement:
+		r.expression(node.ReturnValue)
+	case *ast.PrefixExpression:
+		r.expression(node.Right)
+	case *ast.InfixExpression:
+		r.expression(node.Left)
+		r.expression(node.Right)
+	case *ast.BlockStatement:
+		r.block(node)
+	case *ast.IfExpression:
+		r.expression(node.Condition)
+		r.block(node.Consequence)
+		r.block(node.Alternative)
+	case *ast.ForLoopExpression:
+		r.expression(node.Condition)
+		r.block(node.Consequence)
+	case *ast.ForeachStatement:
+		r.expression(node.Value)
+		r.pushScope()
+		ident := &ast.Identifier{Token: node.Token, Value: node.Ident}
+		r.declare(r.frame.scope, ident, false)
+		node.IdentSlot = ident.Local.Slot
+		if node.Index != "" {
+			index := &ast.Identifier{Token: node.Token, Value: node.Index}
+			r.declare(r.frame.scope, index, false)
+			node.IndexSlot = index.Local.Slot
+		}
+		if node.Pattern != nil {
+			r.pattern(node.Pattern, func(ident *ast.Identifier) {
+				r.declare(r.frame.scope, ident, false)
+			})
+		}
+		r.block(node.Body)
+		r.popScope()
+	case *ast.TryExpression:
+		r.block(node.Block)
+		if node.Catch != nil {
+			if node.CatchIdent != nil {
+				r.pushScope()
+				r.declare(r.frame.scope, node.CatchIdent, true)
+				r.block(node.Catch)
+				r.popScope()
+			} else {
+				r.block(node.Catch)
+			}
+		}
+		r.block(node.Finally)
+	case *ast.FunctionLiteral:
+		r.frame.pending = append(r.frame.pending, pendingFunction{
+			literal: node,
+			scope:   r.frame.scope,
+		})
+	case *ast.CallExpression:
+		r.expression(node.Function)
+		for _, arg := range node.Arguments {
+			r.expression(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.expression(el)
+		}
+	case *ast.HashLiteral:
+		for key, value := range node.Pairs {
+			r.expression(key)
+			r.expression(value)
+		}
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			r.expression(part)
+		}
+	case *ast.IndexExpression:
+		r.expression(node.Left)
+		r.expression(node.Index)
+	case *ast.MemberExpression:
+		r.member(node)
+	case *ast.SpreadLiteral:
+		r.expression(node.Right)
+	case *ast.ImportExpression:
+		r.expression(node.Name)
+	}
+}
+
+// member resolves a dot access. If it's only identifiers, like
+// http.constants.status, and the first one isn't a local variable, the
+// longest part of it which is the name of a variable is looked up as one,
+// since that's how the standard library is defined.
+func (r *resolver) member(node *ast.MemberExpression) {
+	root := node.Object
+	for m, ok := root.(*ast.MemberExpression); ok; m, ok = root.(*ast.MemberExpression) {
+		root = m.Object
+	}
+	ident, ok := root.(*ast.Identifier)
+	if !ok || ident.Value == "self" {
+		r.expression(node.Object)
+		return
+	}
+	if b, _ := r.lookup(ident.Value); b != nil && b.slot >= 0 {
+		r.expression(node.Object)
+		return
+	}
+
+	m := node
+	for {
+		name := m.DottedName()
+		if b, depth := r.lookup(name); b != nil {
+			m.Name = &ast.Identifier{Token: ident.Token, Value: name, Local: local(b, depth)}
+			return
+		}
+		inner, ok := m.Object.(*ast.MemberExpression)
+		if !ok {
+			break
+		}
+		m = inner
+	}
+	if b, depth := r.lookup(ident.Value); b != nil {
+		ident.Local = local(b, depth)
+		return
+	}
+
+	// Nothing's defined yet, which is only allowed in functions in the
+	// REPL; those names usually have one dot.
+	m.Name = &ast.Identifier{Token: ident.Token, Value: m.DottedName()}
+	if !r.lenient() {
+		r.errorf(ident.Pos(), "identifier not found: %s", m.Name.Value)
+	}
+}
+
+// markTailCalls marks the calls in a block of a function which are the
+// last thing it does: those which are returned, or are the value of the
+// block if it's in tail position itself.
+func markTailCalls(b *ast.BlockStatement, tail bool) {
+	if b == nil {
+		return
+	}
+	for i, s := range b.Statements {
+		switch s := s.(type) {
+		case *ast.ReturnStatement:
+			markTailCall(s.ReturnValue, true)
+		case *ast.ExpressionStatement:
+			markTailCall(s.Expression, tail && i == len(b.Statements)-1)
+		}
+	}
+}
+
+func markTailCall(e ast.Expression, tail bool) {
+	switch e := e.(type) {
+	case *ast.CallExpression:
+		e.Tail = tail
+	case *ast.IfExpression:
+		markTailCalls(e.Consequence, tail)
+		markTailCalls(e.Alternative, tail)
+	case *ast.ForLoopExpression:
+		markTailCalls(e.Consequence, false)
+	case *ast.ForeachStatement:
+		markTailCalls(e.Body, false)
+	}
+	// Nothing in a try expression is a tail call, since the catch and
+	// finally blocks still have to run after it.
+}
@@ -0,0 +1,152 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/zacanger/cozy/ast"
+	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/object"
+	"github.com/zacanger/cozy/parser"
+)
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"print(nope)", "1:7: identifier not found: nope"},
+		{"let x = 1\nx = 2", "2:1: cannot modify 'x'; it was defined with let"},
+		{"let x = 1\nx += 2", "2:1: cannot modify 'x'; it was defined with let"},
+		{"mutable x = 1", "1:1: no mutable variables at the top level; 'x' must be bound with let"},
+		{"let f = fn () { let y = 1; y++ }", "1:28: cannot modify 'y'; it was defined with let"},
+		{"let f = fn () { let y = 1; mutable y = 2 }", "1:28: cannot modify 'y'; it was defined with let"},
+		{"let f = fn () { z = 3 }", "1:17: setting unknown variable 'z' is an error"},
+		{"let f = fn () { foreach x in [1] {}; x }", "1:38: identifier not found: x"},
+		{"let f = fn () { try {} catch e {}; e }", "1:36: identifier not found: e"},
+		{"let f = fn () { g() }", "1:17: identifier not found: g"},
+		{`print("{{nope}}")`, "1:10: identifier not found: nope"},
+		{"print(nope.x.y)", "1:7: identifier not found: nope.x"},
+		{"let f = fn () { fs.nope.x }", "1:17: identifier not found: fs.nope"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		errs := Resolve(program, NewRuntime().NewEnvironment())
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, got=%v", tt.input, errs)
+			continue
+		}
+		if errs[0].Error() != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q",
+				tt.input, tt.expected, errs[0].Error())
+		}
+	}
+}
+
+func TestResolveValid(t *testing.T) {
+	tests := []string{
+		// functions can use what's defined after them
+		"let f = fn () { g() }; let g = fn () { 1 }",
+		"let f = fn () { let h = fn () { n }; let n = 1; h() }",
+		// mutable is for the whole function, even inside a loop
+		"let f = fn () { foreach x in [1] { mutable n = x }; n }",
+		"let f = fn () { mutable n = 0; foreach x in [1] { n += x; mutable n = 2 } }",
+		// a local may shadow a global, and a parameter a builtin
+		"let n = 1; let f = fn () { mutable n = 2 }; let g = fn (print) { print }",
+		"let f = fn () { self = 1 }",
+	}
+
+	for _, input := range tests {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		if errs := Resolve(program, NewRuntime().NewEnvironment()); len(errs) != 0 {
+			t.Errorf("%q: unexpected errors: %v", input, errs)
+		}
+	}
+}
+
+func TestResolveRepl(t *testing.T) {
+	input := "mutable n = 1; let f = fn () { g() }"
+	repl := NewRuntime()
+	repl.SetRepl(true)
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if errs := Resolve(program, repl.NewEnvironment()); len(errs) != 0 {
+		t.Errorf("unexpected errors in the REPL: %v", errs)
+	}
+	// another runtime isn't affected by the REPL
+	program = parser.New(lexer.New(input)).ParseProgram()
+	if errs := Resolve(program, NewRuntime().NewEnvironment()); len(errs) != 2 {
+		t.Errorf("expected 2 errors, got=%v", errs)
+	}
+}
+
+func TestResolveOnce(t *testing.T) {
+	program := parser.New(lexer.New("let n = 1; n")).ParseProgram()
+	if errs := Resolve(program, NewRuntime().NewEnvironment()); len(errs) != 0 || !program.Resolved {
+		t.Fatalf("expected the program to be resolved. errors=%v", errs)
+	}
+	// a program with mistakes is resolved again when it's run
+	program = parser.New(lexer.New("nope")).ParseProgram()
+	Resolve(program, NewRuntime().NewEnvironment())
+	if program.Resolved {
+		t.Errorf("a program with errors was marked resolved")
+	}
+}
+
+func TestResolveSlots(t *testing.T) {
+	input := `let f = fn (a, b) {
+		let c = a
+		foreach i, x in b { let d = fn () { x + c } }
+	}`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if errs := Resolve(program, object.NewEnvironment()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	f := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	expected := []string{"a", "b", "c", "x", "i", "d"}
+	if len(f.Locals) != len(expected) {
+		t.Fatalf("wrong locals. expected=%v, got=%v", expected, f.Locals)
+	}
+	for i, name := range expected {
+		if f.Locals[i] != name {
+			t.Errorf("wrong local %d. expected=%q, got=%q", i, name, f.Locals[i])
+		}
+	}
+
+	foreach := f.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.ForeachStatement)
+	d := foreach.Body.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	sum := d.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	for _, tt := range []struct {
+		ident    ast.Expression
+		expected ast.Local
+	}{{sum.Left, ast.Local{Depth: 1, Slot: 3}}, {sum.Right, ast.Local{Depth: 1, Slot: 2}}} {
+		loc := tt.ident.(*ast.Identifier).Local
+		if loc == nil || *loc != tt.expected {
+			t.Errorf("wrong local for %s. expected=%+v, got=%+v", tt.ident, tt.expected, loc)
+		}
+	}
+}
+
+func TestLocals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn () { g() + 1 }; let g = fn () { 41 }; f()", 42},
+		{`let counter = fn () {
+			mutable n = 0
+			fn () { n++; n }
+		}
+		let c = counter()
+		c(); c(); c()`, 3},
+		{"let f = fn (a, b = a * 2) { a + b }; f(1) + f(1, 1)", 5},
+		{"let f = fn () { mutable n = 0; foreach x in [1, 2] { mutable n = n + x }; n }; f()", 3},
+		{"let n = 10; let f = fn () { mutable n = 1; n }; f() + n", 11},
+		{"let f = fn (xs) { mutable t = 0; foreach x in xs { t += x }; t }; f([1, 2, 3])", 6},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
@@ -0,0 +1,485 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zacanger/cozy/object"
+)
+
+// Runtime holds the state of a single interpreter instance: Go functions
+// registered on it, its module cache, async functions, timers, http
+// server, and sandbox. Every environment made from a runtime's root environment carries
+// the runtime with it, so separate instances never see each other's state.
+// A runtime is safe to use from several goroutines, as async functions,
+// timers, and http handlers do.
+type Runtime struct {
+	// ctx can be used to cancel whatever the runtime is evaluating. It
+	// holds a runContext, since every goroutine reads it at each step.
+	ctx atomic.Value
+
+	// mu guards the maps below, and the server.
+	mu sync.Mutex
+
+	// backend evaluates programs.
+	backend Backend
+
+	// limits on the current run, from its Limits, and the number of
+	// nodes it has evaluated so far. They're read at every step, so
+	// they're atomic rather than guarded by mu.
+	stepLimit  int64
+	depthLimit int64
+	steps      int64
+
+	// set by sys.exit, which ends the run with exitCode, and closes
+	// exitCh to wake up anything waiting.
+	exiting  int32
+	exitCode int64
+	exitCh   chan struct{}
+
+	// builtins registered on this instance only; these are looked up
+	// before the package-level builtins. They're registered before
+	// anything runs, so they aren't guarded by mu.
+	builtins map[string]*object.Builtin
+
+	// modules already imported, by name.
+	importCache map[string]OBJ
+
+	// extra directories to look for modules in, before the defaults.
+	searchPaths []string
+
+	// timers from time.interval and time.timeout.
+	intervalIDs map[int64]chan bool
+	timeouts    map[int64]*time.Timer
+
+	// the app served by http.server().listen, and the servers listening.
+	server  *app
+	closers []io.Closer
+
+	// the event loop: how much work is pending, which keeps it running,
+	// and the first error a callback raised which nothing caught, which
+	// ends it. changed is closed, and replaced, whenever either changes.
+	pending int
+	failure *object.Error
+	changed chan struct{}
+
+	// what builtins may do to the machine; nil allows everything.
+	permissions *Permissions
+
+	// repl is set for a runtime running the REPL, which allows mutable
+	// variables at the top level, and functions which use names defined
+	// after them. Like builtins, it's set before anything runs.
+	repl bool
+}
+
+// Limits bounds the work a single run may do, for running code which
+// can't be trusted. Exceeding a limit raises an error. Zero values mean no
+// limit, except for MaxDepth, which falls back to DefaultMaxDepth so that
+// runaway recursion is reported rather than overflowing the Go stack.
+type Limits struct {
+	// Timeout is the wall-clock time a run may take.
+	Timeout time.Duration
+
+	// MaxSteps is the number of steps a run may take: bytecode
+	// instructions, or nodes for the tree-walker.
+	MaxSteps int64
+
+	// MaxDepth is how deeply function calls may nest.
+	MaxDepth int
+}
+
+// DefaultMaxDepth is the call depth allowed when Limits.MaxDepth is unset.
+const DefaultMaxDepth = 10000
+
+// NewRuntime creates a new, empty runtime.
+func NewRuntime() *Runtime {
+	r := &Runtime{
+		backend:     defaultBackend,
+		builtins:    make(map[string]*object.Builtin),
+		importCache: make(map[string]OBJ),
+		intervalIDs: make(map[int64]chan bool),
+		timeouts:    make(map[int64]*time.Timer),
+		changed:     make(chan struct{}),
+		exitCh:      make(chan struct{}),
+	}
+	r.SetContext(context.Background())
+	return r
+}
+
+// runContext wraps a context, since an atomic.Value always has to hold
+// the same type.
+type runContext struct {
+	context.Context
+}
+
+// defaultRuntime is used by environments which weren't made by a runtime,
+// for example in the REPL and in tests.
+var defaultRuntime = NewRuntime()
+
+// runtimeOf returns the runtime an environment belongs to.
+func runtimeOf(env *ENV) *Runtime {
+	if env != nil {
+		if r, ok := env.Runtime.(*Runtime); ok {
+			return r
+		}
+	}
+	return defaultRuntime
+}
+
+// NewEnvironment creates a new top-level environment belonging to this
+// runtime.
+func (r *Runtime) NewEnvironment() *ENV {
+	env := object.NewEnvironment()
+	env.Runtime = r
+	return env
+}
+
+// RegisterBuiltin registers a built-in function on this runtime only. See
+// the package-level RegisterBuiltin for the params.
+func (r *Runtime) RegisterBuiltin(
+	name string,
+	fn object.BuiltinFunction,
+	params ...object.Param,
+) {
+	r.builtins[name] = newBuiltin(name, fn, params)
+}
+
+// AddSearchPath adds a directory to look for imported modules in. It's
+// searched before the defaults (the working directory, or $COZY_PATH).
+func (r *Runtime) AddSearchPath(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	r.searchPaths = append(r.searchPaths, absPath)
+	return nil
+}
+
+// SetRepl sets whether the runtime is running the REPL, which allows
+// mutable variables at the top level, and functions which use names
+// which haven't been defined yet.
+func (r *Runtime) SetRepl(repl bool) {
+	r.repl = repl
+}
+
+// SetContext sets the context used to cancel evaluation.
+func (r *Runtime) SetContext(ctx context.Context) {
+	r.ctx.Store(runContext{ctx})
+}
+
+// context returns the context used to cancel evaluation.
+func (r *Runtime) context() context.Context {
+	return r.ctx.Load().(runContext).Context
+}
+
+// SetLimits sets the limits for the next run, and resets its step count,
+// and any exit or failed callback from the last run.
+// The Timeout isn't enforced here; the caller should put it on the context
+// passed to SetContext.
+func (r *Runtime) SetLimits(limits Limits) {
+	atomic.StoreInt64(&r.stepLimit, limits.MaxSteps)
+	atomic.StoreInt64(&r.depthLimit, int64(limits.MaxDepth))
+	atomic.StoreInt64(&r.steps, 0)
+	atomic.StoreInt32(&r.exiting, 0)
+	r.mu.Lock()
+	r.failure = nil
+	select {
+	case <-r.exitCh:
+		r.exitCh = make(chan struct{})
+	default:
+	}
+	r.mu.Unlock()
+}
+
+// exit ends the run with a code. It returns the error which unwinds the
+// program, which every step after this one also returns, so that nothing
+// can catch it, and so that the run ends even if it was called from
+// another goroutine.
+func (r *Runtime) exit(code int) *object.Error {
+	atomic.StoreInt64(&r.exitCode, int64(code))
+	atomic.StoreInt32(&r.exiting, 1)
+	r.mu.Lock()
+	r.notify()
+	select {
+	case <-r.exitCh:
+	default:
+		close(r.exitCh)
+	}
+	r.mu.Unlock()
+	return exitError(code)
+}
+
+// exited returns a channel which is closed when the run exits.
+func (r *Runtime) exited() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.exitCh
+}
+
+func exitError(code int) *object.Error {
+	return &object.Error{
+		Message: fmt.Sprintf("exited with code %d", code),
+		Code:    &code,
+		Exit:    true,
+	}
+}
+
+// step counts one evaluated node or instruction, returning an error once the run has
+// used up its steps, or has been cancelled or exited. The code may also
+// have a context of its own, like an async function, which cancels it.
+func (r *Runtime) step(ctx context.Context) *object.Error {
+	if atomic.LoadInt32(&r.exiting) != 0 {
+		return exitError(int(atomic.LoadInt64(&r.exitCode)))
+	}
+	if err := cancelled(r.context()); err != nil {
+		return err
+	}
+	if ctx != nil {
+		if err := cancelled(ctx); err != nil {
+			return err
+		}
+	}
+
+	max := atomic.LoadInt64(&r.stepLimit)
+	if max > 0 && atomic.AddInt64(&r.steps, 1) > max {
+		return &object.Error{Message: fmt.Sprintf(
+			"execution exceeded the limit of %d steps", max,
+		)}
+	}
+	return nil
+}
+
+// cancelled returns an error if a context is done.
+func cancelled(ctx context.Context) *object.Error {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return &object.Error{Message: "execution timed out"}
+		}
+		return &object.Error{Message: "execution was cancelled"}
+	default:
+		return nil
+	}
+}
+
+// maxDepth returns how deeply function calls may nest.
+func (r *Runtime) maxDepth() int {
+	if max := atomic.LoadInt64(&r.depthLimit); max > 0 {
+		return int(max)
+	}
+	return DefaultMaxDepth
+}
+
+// builtin looks up a built-in function, preferring the ones registered
+// on this runtime.
+func (r *Runtime) builtin(name string) (*object.Builtin, bool) {
+	if b, ok := r.builtins[name]; ok {
+		return b, true
+	}
+	b, ok := builtins[name]
+	return b, ok
+}
+
+// findModule finds a module based on name.
+func (r *Runtime) findModule(name string) string {
+	if filename := findModuleIn(r.searchPaths, name); filename != "" {
+		return filename
+	}
+	return FindModule(name)
+}
+
+// httpApp returns this runtime's http server, making it if needed.
+func (r *Runtime) httpApp() *app {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.server == nil {
+		r.server = &app{}
+	}
+	return r.server
+}
+
+// cachedModule returns a module which was already imported.
+func (r *Runtime) cachedModule(name string) (OBJ, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.importCache[name]
+	return m, ok
+}
+
+// cacheModule keeps an imported module for the next import of it.
+func (r *Runtime) cacheModule(name string, m OBJ) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.importCache[name] = m
+}
+
+// addInterval keeps the channel which stops an interval, returning its
+// id. The interval keeps the event loop running until it's cancelled.
+func (r *Runtime) addInterval(clear chan bool) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := rand.Int63()
+	r.intervalIDs[id] = clear
+	r.pending++
+	return id
+}
+
+// addTimeout calls fn after d, unless it's cancelled first, returning the
+// id of the timeout. It keeps the event loop running until fn returns.
+func (r *Runtime) addTimeout(d time.Duration, fn func()) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := rand.Int63()
+	r.pending++
+	r.timeouts[id] = time.AfterFunc(d, func() {
+		r.mu.Lock()
+		_, ok := r.timeouts[id]
+		delete(r.timeouts, id)
+		r.mu.Unlock()
+
+		// It was cancelled as it fired.
+		if !ok {
+			return
+		}
+		defer r.release()
+		fn()
+	})
+	return id
+}
+
+// cancelTimer stops an interval, or cancels a timeout, by id.
+func (r *Runtime) cancelTimer(id int64) {
+	r.mu.Lock()
+	clear := r.intervalIDs[id]
+	timer := r.timeouts[id]
+	delete(r.intervalIDs, id)
+	delete(r.timeouts, id)
+	r.mu.Unlock()
+
+	switch {
+	case clear != nil:
+		clear <- true
+	case timer != nil:
+		timer.Stop()
+	default:
+		return
+	}
+	r.release()
+}
+
+// addCloser keeps a server to close if the event loop is stopped.
+func (r *Runtime) addCloser(c io.Closer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closers = append(r.closers, c)
+}
+
+// hold keeps the event loop running until release is called.
+func (r *Runtime) hold() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending++
+}
+
+// release lets the event loop finish, once nothing else is pending.
+func (r *Runtime) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending--
+	r.notify()
+}
+
+// notify wakes the event loop. r.mu must be held.
+func (r *Runtime) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// callback calls a function which was scheduled to run later, like a
+// timer. An error it raises which nothing catches ends the event loop, as
+// it would have ended the program.
+func (r *Runtime) callback(env *ENV, fn OBJ, args ...OBJ) {
+	res := ApplyFunction(env, fn, append(make([]OBJ, 0, len(args)), args...))
+	if e, ok := res.(*object.Error); ok && !e.BuiltinCall && !e.Exit {
+		r.fail(e)
+	}
+}
+
+// fail ends the event loop with an error, unless it's already failed.
+func (r *Runtime) fail(err *object.Error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failure == nil {
+		r.failure = err
+		r.notify()
+	}
+}
+
+// Wait runs the event loop once a program has been evaluated, returning
+// when nothing is pending: no timers, futures, background functions, or
+// http servers. It returns an error instead if the program exits, or is
+// cancelled, or a callback raises an error which nothing catches.
+func (r *Runtime) Wait() *object.Error {
+	for {
+		if atomic.LoadInt32(&r.exiting) != 0 {
+			return exitError(int(atomic.LoadInt64(&r.exitCode)))
+		}
+
+		r.mu.Lock()
+		pending, failure, changed := r.pending, r.failure, r.changed
+		r.mu.Unlock()
+		switch {
+		case failure != nil:
+			return failure
+		case pending <= 0:
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-r.context().Done():
+			return cancelled(r.context())
+		}
+	}
+}
+
+// Stop ends whatever a run left pending: it cancels every timer, closes
+// every server, and waits for the functions still running to return.
+// The context must be cancelled first, so they return at their next
+// step.
+func (r *Runtime) Stop() {
+	r.mu.Lock()
+	ids := make([]int64, 0, len(r.intervalIDs)+len(r.timeouts))
+	for id := range r.intervalIDs {
+		ids = append(ids, id)
+	}
+	for id := range r.timeouts {
+		ids = append(ids, id)
+	}
+	closers := r.closers
+	r.closers = nil
+	r.mu.Unlock()
+
+	for _, id := range ids {
+		r.cancelTimer(id)
+	}
+	for _, c := range closers {
+		c.Close()
+	}
+
+	for {
+		r.mu.Lock()
+		pending, changed := r.pending, r.changed
+		r.mu.Unlock()
+		if pending <= 0 {
+			return
+		}
+		<-changed
+	}
+}
@@ -0,0 +1,214 @@
+package evaluator
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/zacanger/cozy/object"
+)
+
+// channelFn makes a channel, which buffers as many values as it's given,
+// or none.
+func channelFn(env *ENV, args ...OBJ) OBJ {
+	size := int64(0)
+	if len(args) > 0 {
+		size = args[0].(*object.Integer).Value
+	}
+	if size < 0 {
+		return NewError("core.channel: size must not be negative, got %d", size)
+	}
+	return object.NewChannel(int(size))
+}
+
+// channelSend sends a value, waiting for room in the buffer, or for a
+// receiver if there's no buffer.
+func channelSend(env *ENV, ch *object.Channel, val OBJ) *object.Error {
+	if ch.IsClosed() {
+		return NewError("channel.send: send on a closed channel")
+	}
+	cases := []reflect.SelectCase{sendCase(ch, val), recvCase(ch.Closed())}
+	i, _, _, err := selectCases(env, cases, time.Time{})
+	switch {
+	case err != nil:
+		return err
+	case i == 1:
+		return NewError("channel.send: send on a closed channel")
+	}
+	return nil
+}
+
+// channelRecv waits for a value, reporting false once the channel is
+// closed and there are no values left in it.
+func channelRecv(env *ENV, ch *object.Channel) (OBJ, bool, *object.Error) {
+	cases := []reflect.SelectCase{recvCase(ch.C), recvCase(ch.Closed())}
+	i, val, _, err := selectCases(env, cases, time.Time{})
+	switch {
+	case err != nil:
+		return nil, false, err
+	case i == 1:
+		return drain(ch)
+	}
+	return val.Interface().(OBJ), true, nil
+}
+
+// drain takes a value left in a closed channel, if there is one.
+func drain(ch *object.Channel) (OBJ, bool, *object.Error) {
+	select {
+	case val := <-ch.C:
+		return val, true, nil
+	default:
+		return NULL, false, nil
+	}
+}
+
+func sendCase(ch *object.Channel, val OBJ) reflect.SelectCase {
+	return reflect.SelectCase{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(ch.C),
+		Send: reflect.ValueOf(&val).Elem(),
+	}
+}
+
+// channelIterator receives from a channel for a foreach loop, until it's
+// closed and empty. The index counts the values received.
+func channelIterator(env *ENV, ch *object.Channel) *object.Iterator {
+	var it *object.Iterator
+	var n int64
+	it = object.NewIterator(func() (OBJ, OBJ, bool) {
+		val, ok, err := channelRecv(env, ch)
+		if err != nil {
+			it.Err = err
+			return nil, nil, false
+		}
+		if !ok {
+			return nil, nil, false
+		}
+		n++
+		return val, &object.Integer{Value: n - 1}, true
+	})
+	return it
+}
+
+func channelSendMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	if err := channelSend(env, self.(*object.Channel), args[0]); err != nil {
+		return err
+	}
+	return NULL
+}
+
+// channelRecvMethod returns the next value, or null once the channel is
+// closed and empty.
+func channelRecvMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	val, _, err := channelRecv(env, self.(*object.Channel))
+	if err != nil {
+		return err
+	}
+	return val
+}
+
+func channelCloseMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return nativeBoolToBooleanObject(self.(*object.Channel).Close())
+}
+
+func channelLenMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return &object.Integer{Value: int64(len(self.(*object.Channel).C))}
+}
+
+func channelCapMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return &object.Integer{Value: int64(cap(self.(*object.Channel).C))}
+}
+
+func channelClosedMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return nativeBoolToBooleanObject(self.(*object.Channel).IsClosed())
+}
+
+// selectFn waits for the first of several channel operations which can
+// proceed: a channel to receive from, or a [channel, value] pair to send
+// on. It returns a hash of the index of the operation, the value sent or
+// received, and whether it was, which it isn't if the channel was closed.
+// With a timeout in milliseconds it returns null if nothing is ready in
+// time; a timeout of 0 only takes what's ready now.
+func selectFn(env *ENV, args ...OBJ) OBJ {
+	ops := args[0].(*object.Array).Elements
+	if len(ops) == 0 {
+		return NewError("core.select: no cases to wait for")
+	}
+
+	// Each operation has two cases: the send or receive, and the
+	// channel closing.
+	cases := make([]reflect.SelectCase, 0, 2*len(ops))
+	for _, op := range ops {
+		switch op := op.(type) {
+		case *object.Channel:
+			cases = append(cases, recvCase(op.C), recvCase(op.Closed()))
+			continue
+		case *object.Array:
+			if ch, ok := sendOp(op); ok {
+				if ch.IsClosed() {
+					return NewError("core.select: send on a closed channel")
+				}
+				cases = append(cases,
+					sendCase(ch, op.Elements[1]), recvCase(ch.Closed()))
+				continue
+			}
+		}
+		return NewError(
+			"core.select: expected a channel, or a [channel, value] pair, got %s",
+			op.Inspect())
+	}
+
+	i, val, recvOK, err := selectCases(env, cases, deadlineOf(args, 1))
+	switch {
+	case err != nil:
+		return err
+	case i < 0:
+		return NULL
+	}
+
+	op := i / 2
+	var result OBJ
+	ok := true
+	switch ch := ops[op].(type) {
+	case *object.Channel:
+		if i%2 == 0 && recvOK {
+			result = val.Interface().(OBJ)
+		} else {
+			result, ok, _ = drain(ch)
+		}
+	case *object.Array:
+		if i%2 == 1 {
+			return NewError("core.select: send on a closed channel")
+		}
+		result = ch.Elements[1]
+	}
+	return NewHash(StringObjectMap{
+		"index": &object.Integer{Value: int64(op)},
+		"value": result,
+		"ok":    nativeBoolToBooleanObject(ok),
+	})
+}
+
+// sendOp returns the channel of a [channel, value] pair.
+func sendOp(op *object.Array) (*object.Channel, bool) {
+	if len(op.Elements) != 2 {
+		return nil, false
+	}
+	ch, ok := op.Elements[0].(*object.Channel)
+	return ch, ok
+}
+
+func init() {
+	RegisterBuiltin("core.channel", channelFn,
+		object.OptionalArg("size", object.INTEGER_OBJ))
+	RegisterBuiltin("core.select", selectFn,
+		object.Arg("ops", object.ARRAY_OBJ),
+		object.OptionalArg("ms", object.INTEGER_OBJ))
+
+	registerMethod(object.CHANNEL_OBJ, "send", channelSendMethod,
+		object.Arg("value"))
+	registerMethod(object.CHANNEL_OBJ, "recv", channelRecvMethod)
+	registerMethod(object.CHANNEL_OBJ, "close", channelCloseMethod)
+	registerMethod(object.CHANNEL_OBJ, "len", channelLenMethod)
+	registerMethod(object.CHANNEL_OBJ, "cap", channelCapMethod)
+	registerMethod(object.CHANNEL_OBJ, "closed?", channelClosedMethod)
+}
@@ -2,116 +2,331 @@ package evaluator
 
 import (
 	"context"
-	"math/rand"
+	"reflect"
 	"regexp"
+	"time"
 
 	"github.com/zacanger/cozy/object"
 )
 
-// Async and await are on code from github.com/Gurpartap/async, Apache 2.0
-// licensed.
+// asyncFn runs a function on its own goroutine, returning a future for
+// its result. Cancelling the future cancels the function, and anything it
+// calls.
+func asyncFn(env *ENV, args ...OBJ) OBJ {
+	return async(env, args[0], make([]OBJ, 0), nil)
+}
+
+// async calls a function with args on its own goroutine, returning a
+// future for its result. With a pool, it waits for one of the pool's
+// workers to be free first.
+func async(env *ENV, fn OBJ, args []OBJ, pool *object.Pool) *object.Future {
+	callEnv, cancel := cancellable(env)
+	f := object.NewFuture(cancel)
 
-type ValueFuture interface {
-	Await() interface{}
+	rt := runtimeOf(env)
+	rt.hold()
+	go func() {
+		defer rt.release()
+		defer cancel()
+		if pool != nil {
+			if err := acquire(callEnv, pool.Acquire()); err != nil {
+				f.Settle(err)
+				return
+			}
+			defer pool.Release()
+		}
+		res := ApplyFunction(callEnv, fn, args)
+		if res == nil {
+			res = NULL
+		}
+		f.Settle(res)
+	}()
+	return f
 }
 
-type valueFuture struct {
-	await func(ctx context.Context) interface{}
+// cancellable returns an environment to call functions in which can be
+// cancelled on its own, along with anything they call.
+func cancellable(env *ENV) (*ENV, context.CancelFunc) {
+	parent := env.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	callEnv := object.NewEnclosedEnvironment(env, nil)
+	callEnv.Context = ctx
+	return callEnv, cancel
 }
 
-func (f valueFuture) Await() interface{} {
-	return f.await(context.Background())
+// acquire waits to send to a channel used as a lock, or as a limit on how
+// many functions run at once.
+func acquire(env *ENV, ch chan<- struct{}) *object.Error {
+	cases := []reflect.SelectCase{{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(ch),
+		Send: reflect.ValueOf(struct{}{}),
+	}}
+	_, _, _, err := selectCases(env, cases, time.Time{})
+	return err
 }
 
-func Async(f func() interface{}) ValueFuture {
-	var result interface{}
-	c := make(chan struct{}, 1)
-	go func() {
-		defer close(c)
-		result = f()
-	}()
-	return valueFuture{
-		await: func(ctx context.Context) interface{} {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-c:
-				return result
-			}
-		},
+// waitFor waits for one of the channels to be closed, returning its index,
+// or -1 if the deadline passes first. A zero deadline never passes. It
+// returns an error if the code waiting is cancelled.
+func waitFor(env *ENV, chans []<-chan struct{}, deadline time.Time) (int, *object.Error) {
+	cases := make([]reflect.SelectCase, len(chans))
+	for i, ch := range chans {
+		cases[i] = recvCase(ch)
 	}
+	i, _, _, err := selectCases(env, cases, deadline)
+	return i, err
 }
 
-var asyncFunctions map[int64]ValueFuture
+// selectCases waits for one of the cases to proceed, returning its index,
+// and what was received, if anything. It returns -1 if the deadline passes
+// first, and an error if the code waiting is cancelled or exits.
+func selectCases(
+	env *ENV,
+	cases []reflect.SelectCase,
+	deadline time.Time,
+) (int, reflect.Value, bool, *object.Error) {
+	rt := runtimeOf(env)
+	n := len(cases)
 
-func init() {
-	asyncFunctions = make(map[int64]ValueFuture)
+	// What's ready now is taken first, so it wins over a deadline which
+	// has passed.
+	cases = cases[:n:n]
+	ready := append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	if i, val, ok := reflect.Select(ready); i < n {
+		return i, val, ok, nil
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return -1, reflect.Value{}, false, nil
+	}
+
+	done := []<-chan struct{}{rt.context().Done(), rt.exited()}
+	if env.Context != nil {
+		done = append(done, env.Context.Done())
+	}
+	for _, ch := range done {
+		cases = append(cases, recvCase(ch))
+	}
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		cases = append(cases, recvCase(timer.C))
+	}
+
+	i, val, ok := reflect.Select(cases)
+	switch {
+	case i < n:
+		return i, val, ok, nil
+	case i < n+len(done):
+		return 0, val, false, rt.step(env.Context)
+	}
+	return -1, val, false, nil
 }
 
-func awaitFn(env *ENV, args ...OBJ) OBJ {
-	var res interface{}
-	var err error
-	switch t := args[0].(type) {
-	case *object.Integer:
-		f := asyncFunctions[t.Value]
-		res = f.Await()
-	default:
-		return NewError("Expected async function id, got %s", args[0].Type())
+// recvCase is a case which receives from a channel.
+func recvCase(ch interface{}) reflect.SelectCase {
+	return reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+}
+
+// deadlineOf returns when a wait given an optional timeout in
+// milliseconds, in args[i], should give up.
+func deadlineOf(args []OBJ, i int) time.Time {
+	if len(args) <= i {
+		return time.Time{}
 	}
+	ms := args[i].(*object.Integer).Value
+	return time.Now().Add(time.Duration(ms) * time.Millisecond)
+}
 
-	if err != nil {
-		return &object.Error{Message: err.Error()}
+func timedOut(name string, args []OBJ, i int) OBJ {
+	return NewError("%s: timed out after %dms", name, args[i].(*object.Integer).Value)
+}
+
+// futuresOf returns the futures in an array.
+func futuresOf(name string, arr OBJ) ([]*object.Future, OBJ) {
+	elements := arr.(*object.Array).Elements
+	futures := make([]*object.Future, len(elements))
+	for i, e := range elements {
+		f, ok := e.(*object.Future)
+		if !ok {
+			return nil, NewError("%s: expected an array of futures, got %s", name, e.Type())
+		}
+		futures[i] = f
 	}
+	return futures, nil
+}
 
-	switch x := res.(type) {
-	case OBJ:
-		return x
-	default:
-		return NewError("Something went wrong in await!")
+// futureResult returns the result of a settled future. If it's an error,
+// each await raises its own copy of it.
+func futureResult(f *object.Future) OBJ {
+	return reraise(f.Result())
+}
 
+// reraise returns a result which is kept to be returned again. If it's an
+// error, it's copied, so each time it's raised has its own stack.
+func reraise(res OBJ) OBJ {
+	if e, ok := res.(*object.Error); ok && !e.BuiltinCall {
+		raised := *e
+		raised.Stack = append([]object.StackFrame(nil), e.Stack...)
+		return &raised
 	}
+	return res
 }
 
-func asyncFn(env *ENV, args ...OBJ) OBJ {
-	x := Async(func() interface{} {
-		return ApplyFunction(env, args[0], make([]OBJ, 0))
-	})
+// settled waits for the first of the futures to be settled, returning its
+// index, or -1 if the deadline passes first.
+func settled(env *ENV, futures []*object.Future, deadline time.Time) (int, *object.Error) {
+	chans := make([]<-chan struct{}, len(futures))
+	for i, f := range futures {
+		chans[i] = f.Done()
+	}
+	return waitFor(env, chans, deadline)
+}
 
-	fnID := rand.Int63()
-	asyncFunctions[fnID] = x
-	return &object.Integer{Value: fnID}
+// awaitFn waits for a future, returning its result, or raising the error
+// the async function raised.
+func awaitFn(env *ENV, args ...OBJ) OBJ {
+	f := args[0].(*object.Future)
+	i, err := settled(env, []*object.Future{f}, deadlineOf(args, 1))
+	switch {
+	case err != nil:
+		return err
+	case i < 0:
+		return timedOut("core.await", args, 1)
+	}
+	return futureResult(f)
 }
 
-func backgroundFn(env *ENV, args ...OBJ) OBJ {
-	switch a := args[0].(type) {
-	case *object.Function:
-		go func() {
-			ApplyFunction(env, a, make([]OBJ, 0))
-		}()
-		return NULL
-	default:
-		return NewError("background expected function arg!")
+// awaitAllFn waits for every future, returning their results in order, or
+// raising the first error any of them raises.
+func awaitAllFn(env *ENV, args ...OBJ) OBJ {
+	futures, errObj := futuresOf("core.await_all", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	deadline := deadlineOf(args, 1)
+
+	results := make([]OBJ, len(futures))
+	pending := make([]int, len(futures))
+	for i := range pending {
+		pending[i] = i
+	}
+	for len(pending) > 0 {
+		waiting := make([]*object.Future, len(pending))
+		for i, idx := range pending {
+			waiting[i] = futures[idx]
+		}
+		i, err := settled(env, waiting, deadline)
+		switch {
+		case err != nil:
+			return err
+		case i < 0:
+			return timedOut("core.await_all", args, 1)
+		}
+
+		idx := pending[i]
+		res := futureResult(futures[idx])
+		if isError(res) {
+			return res
+		}
+		results[idx] = res
+		pending = append(pending[:i], pending[i+1:]...)
 	}
+	return &object.Array{Elements: results}
 }
 
-// regular expression match
-func matchFn(args ...OBJ) OBJ {
-	if len(args) != 2 {
-		return NewError("wrong number of arguments. got=%d, want=2",
-			len(args))
+// awaitAnyFn returns the result of the first future which doesn't raise
+// an error. If they all do, it raises an error with theirs as its data.
+func awaitAnyFn(env *ENV, args ...OBJ) OBJ {
+	futures, errObj := futuresOf("core.await_any", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if len(futures) == 0 {
+		return NewError("core.await_any: no futures to wait for")
 	}
+	deadline := deadlineOf(args, 1)
+
+	var errs []OBJ
+	for len(futures) > 0 {
+		i, err := settled(env, futures, deadline)
+		switch {
+		case err != nil:
+			return err
+		case i < 0:
+			return timedOut("core.await_any", args, 1)
+		}
 
-	if args[0].Type() != object.STRING_OBJ {
-		return NewError("argument to `match` must be STRING, got %s",
-			args[0].Type())
+		res := futureResult(futures[i])
+		if !isError(res) {
+			return res
+		}
+		// The errors are values in the data, rather than raised.
+		res.(*object.Error).BuiltinCall = true
+		errs = append(errs, res)
+		futures = append(futures[:i], futures[i+1:]...)
 	}
-	if args[1].Type() != object.STRING_OBJ {
-		return NewError("argument to `match` must be STRING, got %s",
-			args[1].Type())
+	return &object.Error{
+		Message: "core.await_any: every future raised an error",
+		Data:    &object.Array{Elements: errs},
 	}
+}
 
+// raceFn returns the result of the first future to be settled, or raises
+// its error.
+func raceFn(env *ENV, args ...OBJ) OBJ {
+	futures, errObj := futuresOf("core.race", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if len(futures) == 0 {
+		return NewError("core.race: no futures to wait for")
+	}
+
+	i, err := settled(env, futures, deadlineOf(args, 1))
+	switch {
+	case err != nil:
+		return err
+	case i < 0:
+		return timedOut("core.race", args, 1)
+	}
+	return futureResult(futures[i])
+}
+
+// backgroundFn runs a function on its own goroutine, ignoring what it
+// returns.
+func backgroundFn(env *ENV, args ...OBJ) OBJ {
+	rt := runtimeOf(env)
+	rt.hold()
+	go func() {
+		defer rt.release()
+		rt.callback(env, args[0])
+	}()
+	return NULL
+}
+
+// spawnFn runs a function with the rest of the arguments on its own
+// goroutine, like Go's go statement.
+func spawnFn(env *ENV, args ...OBJ) OBJ {
+	rt := runtimeOf(env)
+	rt.hold()
+	go func() {
+		defer rt.release()
+		rt.callback(env, args[0], args[1:]...)
+	}()
+	return NULL
+}
+
+// regular expression match
+func matchFn(args ...OBJ) OBJ {
 	// Compile and match
-	reg := regexp.MustCompile(args[0].(*object.String).Value)
+	reg, err := regexp.Compile(args[0].(*object.String).Value)
+	if err != nil {
+		return NewError("core.match: %s", err)
+	}
 	res := reg.FindStringSubmatch(args[1].(*object.String).Value)
 
 	if len(res) > 0 {
@@ -134,20 +349,26 @@ func matchFn(args ...OBJ) OBJ {
 }
 
 func init() {
-	RegisterBuiltin("core.match",
-		func(env *ENV, args ...OBJ) OBJ {
-			return matchFn(args...)
-		})
-	RegisterBuiltin("core.async",
-		func(env *ENV, args ...OBJ) OBJ {
-			return asyncFn(env, args...)
-		})
-	RegisterBuiltin("core.await",
-		func(env *ENV, args ...OBJ) OBJ {
-			return awaitFn(env, args...)
-		})
-	RegisterBuiltin("core.background",
-		func(env *ENV, args ...OBJ) OBJ {
-			return backgroundFn(env, args...)
-		})
+	RegisterBuiltin("core.match", noEnv(matchFn),
+		object.Arg("pattern", object.STRING_OBJ),
+		object.Arg("str", object.STRING_OBJ))
+	RegisterBuiltin("core.async", asyncFn,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ))
+	RegisterBuiltin("core.await", awaitFn,
+		object.Arg("future", object.FUTURE_OBJ),
+		object.OptionalArg("ms", object.INTEGER_OBJ))
+	RegisterBuiltin("core.await_all", awaitAllFn,
+		object.Arg("futures", object.ARRAY_OBJ),
+		object.OptionalArg("ms", object.INTEGER_OBJ))
+	RegisterBuiltin("core.await_any", awaitAnyFn,
+		object.Arg("futures", object.ARRAY_OBJ),
+		object.OptionalArg("ms", object.INTEGER_OBJ))
+	RegisterBuiltin("core.race", raceFn,
+		object.Arg("futures", object.ARRAY_OBJ),
+		object.OptionalArg("ms", object.INTEGER_OBJ))
+	RegisterBuiltin("core.background", backgroundFn,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ))
+	RegisterBuiltin("core.spawn", spawnFn,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ),
+		object.VariadicArg("args"))
 }
@@ -1,21 +1,176 @@
 package evaluator
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/object"
+	"github.com/zacanger/cozy/parser"
 )
 
-func TestAsyncAwat(t *testing.T) {
-	v := Async(func() interface{} {
-		return func() string {
-			time.Sleep(1 * time.Second)
-			return "foo"
-		}()
-	})
+func TestFutures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = core.async(fn () { time.sleep(10); "a" }); core.await(f) + core.await(f)`, "aa"},
+		{`let f = core.async(fn () { 1 }); core.await(f); f.state()`, "done"},
+		{`let f = core.async(fn () { panic(error("bad")) })
+		try { core.await(f) } catch e { e.message + f.state() }`, "badfailed"},
+		{`let f = core.async(fn () { time.sleep(1000) })
+		let m = try { core.await(f, 10) } catch e { e.message }
+		f.cancel()
+		m`, "core.await: timed out after 10ms"},
+		{`let f = core.async(fn () { for true { 1 } }); f.cancel()
+		try { core.await(f) } catch e { e.message + " " + f.state() }`, "future was cancelled cancelled"},
+		{`util.string(core.await_all([core.async(fn () { time.sleep(20); 1 }), core.async(fn () { 2 })]))`, "[1, 2]"},
+		{`try { core.await_all([core.async(fn () { time.sleep(1000); 1 }), core.async(fn () { panic(error("no")) })]) } catch e { e.message }`, "no"},
+		{`util.string(core.await_any([core.async(fn () { panic(error("no")) }), core.async(fn () { time.sleep(20); 2 })]))`, "2"},
+		{`try { core.await_any([core.async(fn () { panic(error("no")) })]) } catch e {
+			e.message + ": " + e.data[0].message
+		}`, "core.await_any: every future raised an error: no"},
+		{`util.string(core.race([core.async(fn () { time.sleep(1000); 1 }), core.async(fn () { 2 })]))`, "2"},
+		{`try { core.race([1]) } catch e { e.message }`,
+			"core.race: expected an array of futures, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, NewRuntime().NewEnvironment())
+		testStringObject(t, evaluated, tt.expected)
+	}
+}
 
-	x := v.Await()
-	expected := "foo"
-	if x != expected {
-		t.Errorf("async test failed! got %q, wanted %q", x, expected)
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let c = core.channel(2); c.send("a"); c.send("b"); c.recv() + c.recv()`, "ab"},
+		{`let c = core.channel(); core.spawn(fn (x) { c.send(x) }, "hi"); c.recv()`, "hi"},
+		{`let c = core.channel(3)
+		core.spawn(fn () { foreach x in ["a", "b", "c"] { c.send(x) }; c.close() })
+		let f = fn () { mutable s = ""; foreach i, x in c { s += util.string(i) + x }; s }
+		f()`, "0a1b2c"},
+		{`let c = core.channel(1); c.send(1); c.close(); util.string([c.recv(), c.recv(), c.closed?()])`, "[1, null, true]"},
+		{`let c = core.channel(); c.close(); try { c.send(1) } catch e { e.message }`,
+			"channel.send: send on a closed channel"},
+		{`let a = core.channel(); let b = core.channel(1); b.send("x")
+		let r = core.select([a, b]); util.string([r.index, r.value, r.ok])`, "[1, x, true]"},
+		{`let a = core.channel(1); let r = core.select([[a, "y"]]); util.string([r.index, a.recv()])`, "[0, y]"},
+		{`let a = core.channel(); a.close(); core.select([a]).ok`, "false"},
+		{`let a = core.channel(); util.string([core.select([a], 10), core.select([a], 0)])`, "[null, null]"},
+		{`let a = core.channel(); core.spawn(fn () { time.sleep(10); a.send(1) }); core.select([a], 1000).value`, "1"},
+		{`try { core.select([1]) } catch e { e.message }`,
+			"core.select: expected a channel, or a [channel, value] pair, got 1"},
+		{`try { core.select([]) } catch e { e.message }`, "core.select: no cases to wait for"},
+		{`try { core.channel(-1) } catch e { e.message }`,
+			"core.channel: size must not be negative, got -1"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, NewRuntime().NewEnvironment())
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[1, 2, 3, 4, 5].pmap(fn (x) { time.sleep(10 - x); x * 2 }, {"workers": 3})`, "[2, 4, 6, 8, 10]"},
+		{`[5, 6, 7].pmap(fn (x, i) { i })`, "[0, 1, 2]"},
+		{`[1, 2, 3, 4].pfilter(fn (x) { x > 2 }, {"workers": 2})`, "[3, 4]"},
+		{`[].pmap(fn (x) { x })`, "[]"},
+		{`try { [1, 2, 3].pmap(fn (x) { if x == 2 { panic(error("two")) }; x }) } catch e { e.message }`, "two"},
+		// the error for the lowest index wins, whichever is raised first
+		{`try { [1, 2, 3, 4].pmap(fn (x) { if x > 1 { time.sleep(10 * (5 - x)); panic(error(util.string(x))) }; x }, {"workers": 4}) } catch e { e.message }`, "2"},
+		{`try { [1, 2, 3].pfilter(fn (x) { time.sleep(10 * (3 - x)); panic(error(util.string(x))) }, {"workers": 3}) } catch e { e.message }`, "1"},
+		{`try { [1].pmap(fn (x) { x }, {"workers": "a"}) } catch e { e.message }`,
+			"array.pmap: workers must be a positive integer, got a"},
+		// only as many functions as the pool has workers run at once
+		{`let pool = core.pool(2)
+		let running = sync.atomic()
+		let most = sync.atomic()
+		let f = fn () {
+			let n = running.add()
+			if n > most.get() { most.set(n) }
+			time.sleep(10)
+			running.add(-1)
+		}
+		core.await_all([pool.submit(f), pool.submit(f), pool.submit(f), pool.submit(f)])
+		most.get()`, "2"},
+		{`core.await(core.pool(1).submit(fn (a, b) { a + b }, 1, 2))`, "3"},
+		{`try { core.pool(0) } catch e { e.message }`, "core.pool: size must be positive, got 0"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, NewRuntime().NewEnvironment())
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// A receive which never gets a value stops when the run is cancelled.
+func TestChannelCancel(t *testing.T) {
+	rt := NewRuntime()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rt.SetContext(ctx)
+
+	for _, input := range []string{
+		"core.channel().recv()",
+		"foreach x in core.channel() {}",
+		"core.select([core.channel()])",
+	} {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		res := Eval(program, rt.NewEnvironment())
+		if e, ok := res.(*object.Error); !ok || e.Message != "execution timed out" {
+			t.Errorf("%q: expected a timeout. got=%v", input, res)
+		}
+	}
+}
+
+// Run with -race to check that functions can be called from several
+// goroutines at once, like http handlers are.
+func TestConcurrentCalls(t *testing.T) {
+	env := NewRuntime().NewEnvironment()
+	program := parser.New(lexer.New(`
+let xs = [1, 2, 3]
+let counter = fn () {
+    mutable n = 0
+    fn () { foreach x in xs { n += x }; n }
+}
+let inc = counter()
+`)).ParseProgram()
+	Eval(program, env)
+	inc, _ := env.Get("inc")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				res := ApplyFunction(env, inc, nil)
+				if _, ok := res.(*object.Integer); !ok {
+					t.Errorf("expected an integer. got=%T (%+v)", res, res)
+					return
+				}
+			}
+		}()
+		Eval(parser.New(lexer.New("let ys = xs")).ParseProgram(), env)
 	}
+	wg.Wait()
 }
@@ -7,16 +7,13 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/zacanger/cozy/object"
 )
 
 // array = fs.glob("/etc/*.conf")
-func fsGlob(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
+func fsGlob(env *ENV, args ...OBJ) OBJ {
 	pattern := args[0].(*object.String).Value
 
 	entries, err := filepath.Glob(pattern)
@@ -24,41 +21,41 @@ func fsGlob(args ...OBJ) OBJ {
 		return &object.Error{Message: err.Error()}
 	}
 
-	// Create an array to hold the results and populate it
-	l := len(entries)
-	result := make([]OBJ, l)
-	for i, txt := range entries {
-		result[i] = &object.String{Value: txt}
+	// Create an array to hold the results and populate it, leaving
+	// out anything the sandbox won't let us see
+	rt := runtimeOf(env)
+	result := make([]OBJ, 0, len(entries))
+	for _, txt := range entries {
+		if rt.checkRead(txt) == nil {
+			result = append(result, &object.String{Value: txt})
+		}
 	}
 	return &object.Array{Elements: result}
 }
 
 // Change a mode of a file - note the second argument is a string
 // to emphasise octal.
-func chmodFn(args ...OBJ) OBJ {
-	if len(args) != 2 {
-		return NewError("wrong number of arguments. got=%d, want=2",
-			len(args))
+func chmodFn(env *ENV, args ...OBJ) OBJ {
+	path := args[0].(*object.String).Value
+	if err := runtimeOf(env).checkWrite(path); err != nil {
+		return err
 	}
 
-	path := args[0].Inspect()
-	mode := ""
-
-	switch args[1].(type) {
+	// The mode is an integer, like 0o755, or a string of octal digits.
+	var result int64
+	switch mode := args[1].(type) {
+	case *object.Integer:
+		result = mode.Value
 	case *object.String:
-		mode = args[1].(*object.String).Value
-	default:
-		return NewError("Second argument must be string, got %v", args[1])
-	}
-
-	// convert from octal -> decimal
-	result, err := strconv.ParseInt(mode, 8, 64)
-	if err != nil {
-		return FALSE
+		var err error
+		result, err = strconv.ParseInt(mode.Value, 8, 64)
+		if err != nil {
+			return FALSE
+		}
 	}
 
 	// Change the mode.
-	err = os.Chmod(path, os.FileMode(result))
+	err := os.Chmod(path, os.FileMode(result))
 	if err != nil {
 		return FALSE
 	}
@@ -66,18 +63,11 @@ func chmodFn(args ...OBJ) OBJ {
 }
 
 // mkdir
-func mkdirFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-
-	if args[0].Type() != object.STRING_OBJ {
-		return NewError("argument to `mkdir` must be STRING, got %s",
-			args[0].Type())
-	}
-
+func mkdirFn(env *ENV, args ...OBJ) OBJ {
 	path := args[0].(*object.String).Value
+	if err := runtimeOf(env).checkWrite(path); err != nil {
+		return err
+	}
 
 	// Can't fail?
 	mode, err := strconv.ParseInt("755", 8, 64)
@@ -93,35 +83,27 @@ func mkdirFn(args ...OBJ) OBJ {
 }
 
 // Open a file
-func openFn(args ...OBJ) OBJ {
-	path := ""
+func openFn(env *ENV, args ...OBJ) OBJ {
+	path := args[0].(*object.String).Value
 	mode := "r"
 
-	// We need at least one arg
-	if len(args) < 1 {
-		return NewError("wrong number of arguments. got=%d, want=1+",
-			len(args))
-	}
-
-	// Get the filename
-	switch args[0].(type) {
-	case *object.String:
-		path = args[0].(*object.String).Value
-	default:
-		return NewError("argument to `file` not supported, got=%s",
-			args[0].Type())
-
-	}
-
-	// Get the mode (optiona)
+	// Get the mode (optional)
 	if len(args) > 1 {
-		switch args[1].(type) {
-		case *object.String:
-			mode = args[1].(*object.String).Value
-		default:
-			return NewError("argument to `file` not supported, got=%s",
-				args[0].Type())
+		mode = args[1].(*object.String).Value
+	}
 
+	// Files are created if they don't exist, even for reading
+	if !isStdio(path) {
+		rt := runtimeOf(env)
+		if !strings.Contains(mode, "w") {
+			if err := rt.checkRead(path); err != nil {
+				return err
+			}
+		}
+		if strings.Contains(mode, "w") || !exists(path) {
+			if err := rt.checkWrite(path); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -132,12 +114,11 @@ func openFn(args ...OBJ) OBJ {
 }
 
 // Get file info.
-func statFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
+func statFn(env *ENV, args ...OBJ) OBJ {
+	path := args[0].(*object.String).Value
+	if err := runtimeOf(env).checkRead(path); err != nil {
+		return err
 	}
-	path := args[0].Inspect()
 	info, err := os.Stat(path)
 
 	if err != nil {
@@ -167,14 +148,12 @@ func statFn(args ...OBJ) OBJ {
 }
 
 // Remove a file/directory.
-func rmFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
+func rmFn(env *ENV, args ...OBJ) OBJ {
+	path := args[0].(*object.String).Value
+	if err := runtimeOf(env).checkWrite(path); err != nil {
+		return err
 	}
 
-	path := args[0].Inspect()
-
 	err := os.Remove(path)
 	if err != nil {
 		return FALSE
@@ -182,20 +161,15 @@ func rmFn(args ...OBJ) OBJ {
 	return TRUE
 }
 
-func mvFn(args ...OBJ) OBJ {
-	var from string
-	var to string
-	switch a := args[0].(type) {
-	case *object.String:
-		from = a.Value
-	default:
-		return NewError("mv expected string arg!")
+func mvFn(env *ENV, args ...OBJ) OBJ {
+	from := args[0].(*object.String).Value
+	to := args[1].(*object.String).Value
+	rt := runtimeOf(env)
+	if err := rt.checkWrite(from); err != nil {
+		return err
 	}
-	switch a := args[1].(type) {
-	case *object.String:
-		to = a.Value
-	default:
-		return NewError("mv expected string arg!")
+	if err := rt.checkWrite(to); err != nil {
+		return err
 	}
 
 	e := os.Rename(from, to)
@@ -206,20 +180,15 @@ func mvFn(args ...OBJ) OBJ {
 	return NULL
 }
 
-func cpFn(args ...OBJ) OBJ {
-	var src string
-	var dst string
-	switch a := args[0].(type) {
-	case *object.String:
-		src = a.Value
-	default:
-		return NewError("mv expected string arg!")
+func cpFn(env *ENV, args ...OBJ) OBJ {
+	src := args[0].(*object.String).Value
+	dst := args[1].(*object.String).Value
+	rt := runtimeOf(env)
+	if err := rt.checkRead(src); err != nil {
+		return err
 	}
-	switch a := args[1].(type) {
-	case *object.String:
-		dst = a.Value
-	default:
-		return NewError("mv expected string arg!")
+	if err := rt.checkWrite(dst); err != nil {
+		return err
 	}
 
 	sfi, err := os.Stat(src)
@@ -277,56 +246,38 @@ func cpFn(args ...OBJ) OBJ {
 }
 
 func templateFn(env *ENV, args ...OBJ) OBJ {
-	switch a := args[0].(type) {
-	case *object.String:
-		b, err := ioutil.ReadFile(a.Value)
-		if err != nil {
-			return NewError("Error reading template file: %s", err)
-		}
-		s := string(b)
-		res := Interpolate(s, env)
-		return &object.String{Value: res}
-	default:
-		return NewError("fs.tmpl expected string arg!")
+	path := args[0].(*object.String).Value
+	if err := runtimeOf(env).checkRead(path); err != nil {
+		return err
 	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NewError("Error reading template file: %s", err)
+	}
+	return Interpolate(path, string(b), env)
 }
 
 func init() {
-	RegisterBuiltin("fs.glob",
-		func(env *ENV, args ...OBJ) OBJ {
-			return fsGlob(args...)
-		})
-	RegisterBuiltin("fs.chmod",
-		func(env *ENV, args ...OBJ) OBJ {
-			return chmodFn(args...)
-		})
-	RegisterBuiltin("fs.mkdir",
-		func(env *ENV, args ...OBJ) OBJ {
-			return mkdirFn(args...)
-		})
-	RegisterBuiltin("fs.open",
-		func(env *ENV, args ...OBJ) OBJ {
-			return openFn(args...)
-		})
-	RegisterBuiltin("fs.stat",
-		func(env *ENV, args ...OBJ) OBJ {
-			return statFn(args...)
-		})
-	RegisterBuiltin("fs.rm",
-		func(env *ENV, args ...OBJ) OBJ {
-			return rmFn(args...)
-		})
-	RegisterBuiltin("fs.mv",
-		func(env *ENV, args ...OBJ) OBJ {
-			return mvFn(args...)
-		})
-	RegisterBuiltin("fs.cp",
-		func(env *ENV, args ...OBJ) OBJ {
-			return cpFn(args...)
-		})
-	RegisterBuiltin("fs.tmpl",
-		func(env *ENV, args ...OBJ) OBJ {
-			return templateFn(env, args...)
-		})
-
+	RegisterBuiltin("fs.glob", fsGlob,
+		object.Arg("pattern", object.STRING_OBJ))
+	RegisterBuiltin("fs.chmod", chmodFn,
+		object.Arg("path", object.STRING_OBJ),
+		object.Arg("mode", object.STRING_OBJ, object.INTEGER_OBJ))
+	RegisterBuiltin("fs.mkdir", mkdirFn,
+		object.Arg("path", object.STRING_OBJ))
+	RegisterBuiltin("fs.open", openFn,
+		object.Arg("path", object.STRING_OBJ),
+		object.OptionalArg("mode", object.STRING_OBJ))
+	RegisterBuiltin("fs.stat", statFn,
+		object.Arg("path", object.STRING_OBJ))
+	RegisterBuiltin("fs.rm", rmFn,
+		object.Arg("path", object.STRING_OBJ))
+	RegisterBuiltin("fs.mv", mvFn,
+		object.Arg("source", object.STRING_OBJ),
+		object.Arg("dest", object.STRING_OBJ))
+	RegisterBuiltin("fs.cp", cpFn,
+		object.Arg("source", object.STRING_OBJ),
+		object.Arg("dest", object.STRING_OBJ))
+	RegisterBuiltin("fs.tmpl", templateFn,
+		object.Arg("path", object.STRING_OBJ))
 }
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 	"time"
@@ -26,14 +27,18 @@ type Request struct {
 	timeout time.Duration
 	headers map[string]string
 	data    interface{}
+
+	// checkRedirect decides whether redirects are followed.
+	checkRedirect func(req *http.Request, via []*http.Request) error
 }
 
 // Build client
 func (r *Request) buildClient() *http.Client {
 	if r.cli == nil {
 		r.cli = &http.Client{
-			Transport: http.DefaultTransport,
-			Timeout:   time.Second * r.timeout,
+			Transport:     http.DefaultTransport,
+			Timeout:       time.Second * r.timeout,
+			CheckRedirect: r.checkRedirect,
 		}
 	}
 	return r.cli
@@ -324,23 +329,18 @@ func newRequest() *Request {
 	return r
 }
 
-func httpClient(args ...OBJ) OBJ {
-	var uri string
-	var method string
+func httpClient(env *ENV, args ...OBJ) OBJ {
 	var headers map[string]string
 	var body string
 
-	switch a := args[0].(type) {
-	case *object.String:
-		method = a.Value
-	default:
-		return NewError("http client expected method as first arg!")
-	}
-	switch a := args[1].(type) {
-	case *object.String:
-		uri = a.Value
-	default:
-		return NewError("http client expected uri as second arg!")
+	method := args[0].(*object.String).Value
+	uri := args[1].(*object.String).Value
+
+	rt := runtimeOf(env)
+	if u, err := url.Parse(uri); err == nil {
+		if err := rt.checkNet(urlAddress(u)); err != nil {
+			return err
+		}
 	}
 
 	if len(args) > 2 {
@@ -352,25 +352,25 @@ func httpClient(args ...OBJ) OBJ {
 			}
 		case *object.String:
 			body = a.Value
-		case *object.Null:
-			break
-		default:
-			return NewError("http client expected headers or body as third arg!")
 		}
 	}
 
 	if len(args) > 3 {
-		switch a := args[3].(type) {
-		case *object.String:
+		if a, ok := args[3].(*object.String); ok {
 			body = a.Value
-		case *object.Null:
-			break
-		default:
-			return NewError("http client expected body as fourth arg!")
 		}
 	}
 
 	req := newRequest()
+	req.checkRedirect = func(r *http.Request, via []*http.Request) error {
+		if err := rt.checkNet(urlAddress(r.URL)); err != nil {
+			return errors.New(err.Message)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
 
 	if headers != nil {
 		req.setHeaders(headers)
@@ -405,8 +405,10 @@ func httpClient(args ...OBJ) OBJ {
 }
 
 func init() {
-	RegisterBuiltin("http.create_client",
-		func(env *ENV, args ...OBJ) OBJ {
-			return httpClient(args...)
-		})
+	RegisterBuiltin("http.create_client", httpClient,
+		object.Arg("method", object.STRING_OBJ),
+		object.Arg("uri", object.STRING_OBJ),
+		object.OptionalArg("headers_or_body",
+			object.HASH_OBJ, object.STRING_OBJ, object.NULL_OBJ),
+		object.OptionalArg("body", object.STRING_OBJ, object.NULL_OBJ))
 }
@@ -3,30 +3,36 @@ package evaluator
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zacanger/cozy/object"
 )
 
-var httpServerEnv *ENV
-
 type httpRoute struct {
 	Pattern *regexp.Regexp
 	Handler *object.Function
 	Methods []string
 }
 
-var routes []httpRoute
-var appInstance *app
-
+// app is the http server for a single runtime. Requests are handled on
+// their own goroutines, so routes can be added while it's serving.
 type app struct {
+	mu sync.RWMutex
+
 	Routes []httpRoute
+	Static []staticHandlerMount
+
+	// Env is where route handlers are called from.
+	Env *ENV
 }
 
 func sendWrapper(
@@ -50,43 +56,34 @@ func methodNotAllowed(ctx *httpContext) {
 	sendWrapper(ctx, http.StatusMethodNotAllowed, "Not allowed", "text/plain")
 }
 
+func internalServerError(ctx *httpContext) {
+	sendWrapper(ctx, http.StatusInternalServerError, "Internal server error", "text/plain")
+}
+
 func registerRoute(env *ENV, args ...OBJ) OBJ {
-	var pattern string
 	var methods []string
-	var handler *object.Function
-
-	switch a := args[0].(type) {
-	case *object.String:
-		pattern = a.Value
-	default:
-		return NewError("route expected pattern string!")
-	}
+	pattern := args[0].(*object.String).Value
+	handler := args[2].(*object.Function)
 
-	switch a := args[1].(type) {
-	case *object.Array:
-		for _, e := range a.Elements {
-			switch x := e.(type) {
-			case *object.String:
-				methods = append(methods, x.Value)
-			default:
-				return NewError("route expected methods string array!")
-			}
+	for _, e := range args[1].(*object.Array).Elements {
+		switch x := e.(type) {
+		case *object.String:
+			methods = append(methods, x.Value)
+		default:
+			return NewError("route expected methods string array!")
 		}
-	default:
-		return NewError("route expected methods string array!")
 	}
 
-	switch f := args[2].(type) {
-	case *object.Function:
-		handler = f
-	default:
-		return NewError("route expected callback function!")
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return NewError("route: %s", err)
 	}
-
-	re := regexp.MustCompile(pattern)
 	route := httpRoute{Pattern: re, Handler: handler, Methods: methods}
 
-	routes = append(routes, route)
+	a := runtimeOf(env).httpApp()
+	a.mu.Lock()
+	a.Routes = append(a.Routes, route)
+	a.mu.Unlock()
 	return NULL
 }
 
@@ -215,6 +212,10 @@ func httpContextToCozyReq(c *httpContext) OBJ {
 func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := &httpContext{Request: r, ResponseWriter: w}
 
+	a.mu.RLock()
+	routes, static, env := a.Routes, a.Static, a.Env
+	a.mu.RUnlock()
+
 	for _, rt := range routes {
 		if matches := rt.Pattern.FindStringSubmatch(ctx.URL.Path); len(matches) > 0 {
 			if len(matches) > 1 {
@@ -225,7 +226,7 @@ func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				if m == r.Method {
 					applyArgs := make([]OBJ, 0)
 					applyArgs = append(applyArgs, httpContextToCozyReq(ctx))
-					res := ApplyFunction(httpServerEnv, rt.Handler, applyArgs)
+					res := ApplyFunction(env, rt.Handler, applyArgs)
 					switch a := res.(type) {
 					case *object.Hash:
 						bodyStr := &object.String{Value: "body"}
@@ -250,6 +251,11 @@ func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 							headers = NewHash(StringObjectMap{})
 						}
 						ctx.send(statusCode, body, contentType, headers)
+					case *object.Error:
+						// an uncaught error only fails this request,
+						// not the whole server
+						fmt.Fprintf(os.Stderr, "%s %s: %s\n", r.Method, r.URL.Path, a.Inspect())
+						internalServerError(ctx)
 					default:
 						fmt.Println(res.Type(), "\n\noh no", res)
 						return
@@ -262,9 +268,10 @@ func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	for _, h := range staticHandlers {
+	for _, h := range static {
 		if strings.HasPrefix(ctx.URL.Path, h.Mount) {
-			http.FileServer(neuteredFileSystem{http.Dir(h.Path)}).ServeHTTP(w, r)
+			fs := neuteredFileSystem{fs: http.Dir(h.Path), root: h.Path, rt: h.rt}
+			http.FileServer(fs).ServeHTTP(w, r)
 			return
 		}
 	}
@@ -278,19 +285,28 @@ type httpContext struct {
 	Params []string
 }
 
+// neuteredFileSystem serves the files in a directory, but doesn't list
+// them, and checks that each file may be read, since a symlink in the
+// directory can point anywhere.
 type neuteredFileSystem struct {
-	fs http.FileSystem
+	fs   http.FileSystem
+	root string
+	rt   *Runtime
 }
 
-func (nfs neuteredFileSystem) Open(path string) (http.File, error) {
-	f, err := nfs.fs.Open(path)
+func (nfs neuteredFileSystem) Open(name string) (http.File, error) {
+	full := filepath.Join(nfs.root, filepath.FromSlash(path.Clean("/"+name)))
+	if err := nfs.rt.checkRead(full); err != nil {
+		return nil, os.ErrPermission
+	}
+	f, err := nfs.fs.Open(name)
 	if err != nil {
 		return nil, err
 	}
 
 	s, _ := f.Stat()
 	if s.IsDir() {
-		index := filepath.Join(path, "index.html")
+		index := filepath.Join(name, "index.html")
 		if _, err := nfs.fs.Open(index); err != nil {
 			closeErr := f.Close()
 			if closeErr != nil {
@@ -307,38 +323,30 @@ func (nfs neuteredFileSystem) Open(path string) (http.File, error) {
 type staticHandlerMount struct {
 	Mount string
 	Path  string
+	rt    *Runtime
 }
 
-var staticHandlers []staticHandlerMount
-
 // static("./public")
 // static("./public", "/some-mount-point")
 func staticHandler(env *ENV, args ...OBJ) OBJ {
-	dir := ""
-	mount := "/"
-
-	switch a := args[0].(type) {
-	case *object.String:
-		dir = a.Value
-	default:
-		return NewError("http static expected a string!")
+	dir := args[0].(*object.String).Value
+	rt := runtimeOf(env)
+	if err := rt.checkRead(dir); err != nil {
+		return err
 	}
-
-	if len(args) > 1 {
-		switch a := args[1].(type) {
-		case *object.String:
-			mount = a.Value
-		}
-
-		if mount == "" {
-			mount = "/"
-		}
+	mount := "/"
+	if len(args) > 1 && args[1].(*object.String).Value != "" {
+		mount = args[1].(*object.String).Value
 	}
 
-	staticHandlers = append(staticHandlers, staticHandlerMount{
+	a := rt.httpApp()
+	a.mu.Lock()
+	a.Static = append(a.Static, staticHandlerMount{
 		Mount: mount,
 		Path:  dir,
+		rt:    rt,
 	})
+	a.mu.Unlock()
 
 	return NULL
 }
@@ -389,36 +397,53 @@ func (c *httpContext) send(args ...OBJ) OBJ {
 	return NULL
 }
 
+// listen starts serving the app on a port, in the background. The server
+// keeps the event loop running.
 func listen(env *ENV, args ...OBJ) OBJ {
-	switch a := args[0].(type) {
-	case *object.Integer:
-		err := http.ListenAndServe(":"+fmt.Sprint(a.Value), appInstance)
-		if err != nil {
-			return NewError("Could not start server: %s\n", err.Error())
-		}
-		return NULL
-	default:
-		return NewError("http.server.listen expected int port!")
+	port := args[0].(*object.Integer).Value
+	rt := runtimeOf(env)
+	if err := rt.checkNet(serverAddress(port)); err != nil {
+		return err
 	}
+	ln, err := net.Listen("tcp", ":"+fmt.Sprint(port))
+	if err != nil {
+		return NewError("Could not start server: %s\n", err.Error())
+	}
+
+	srv := &http.Server{Handler: rt.httpApp()}
+	rt.addCloser(srv)
+	rt.hold()
+	go func() {
+		defer rt.release()
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			rt.fail(NewError("http server stopped: %s", err.Error()))
+		}
+	}()
+	return NULL
 }
 
 func httpServer(env *ENV, args ...OBJ) OBJ {
-	httpServerEnv = env
+	a := runtimeOf(env).httpApp()
+	a.mu.Lock()
+	a.Env = env
+	a.mu.Unlock()
 
 	return NewHash(StringObjectMap{
-		"listen": &object.Builtin{Fn: listen},
-		"route":  &object.Builtin{Fn: registerRoute},
-		"static": &object.Builtin{Fn: staticHandler},
+		"listen": newBuiltin("listen", listen, []object.Param{
+			object.Arg("port", object.INTEGER_OBJ),
+		}),
+		"route": newBuiltin("route", registerRoute, []object.Param{
+			object.Arg("pattern", object.STRING_OBJ),
+			object.Arg("methods", object.ARRAY_OBJ),
+			object.Arg("handler", object.FUNCTION_OBJ),
+		}),
+		"static": newBuiltin("static", staticHandler, []object.Param{
+			object.Arg("dir", object.STRING_OBJ),
+			object.OptionalArg("mount", object.STRING_OBJ),
+		}),
 	})
 }
 
 func init() {
-	routes = make([]httpRoute, 0)
-	appInstance = &app{}
-	staticHandlers = make([]staticHandlerMount, 0)
-
-	RegisterBuiltin("http.create_server",
-		func(env *ENV, args ...OBJ) OBJ {
-			return httpServer(env, args...)
-		})
+	RegisterBuiltin("http.create_server", httpServer)
 }
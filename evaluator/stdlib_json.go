@@ -35,7 +35,7 @@ func jsonDeserialize(args ...OBJ) OBJ {
 	}
 
 	if IsNumber(str) {
-		if strings.Contains(str, ".") {
+		if strings.ContainsAny(str, ".eE") {
 			node, ok = p.ParseFloatLiteral().(*ast.FloatLiteral)
 		} else {
 			node, ok = p.ParseIntegerLiteral().(*ast.IntegerLiteral)
@@ -74,12 +74,9 @@ func jsonSerialize(args ...OBJ) OBJ {
 }
 
 func init() {
-	RegisterBuiltin("json.deserialize",
-		func(env *ENV, args ...OBJ) OBJ {
-			return jsonDeserialize(args...)
-		})
-	RegisterBuiltin("json.serialize",
-		func(env *ENV, args ...OBJ) OBJ {
-			return jsonSerialize(args...)
-		})
+	RegisterBuiltin("json.deserialize", noEnv(jsonDeserialize),
+		object.Arg("json", object.STRING_OBJ))
+	RegisterBuiltin("json.serialize", noEnv(jsonSerialize),
+		object.Arg("value"),
+		object.OptionalArg("indent"))
 }
@@ -9,11 +9,6 @@ import (
 )
 
 func mathAbs(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		v := arg.Value
@@ -27,10 +22,8 @@ func mathAbs(args ...OBJ) OBJ {
 			v = v * -1
 		}
 		return &object.Float{Value: v}
-	default:
-		return NewError("argument to `math.abs` not supported, got=%s",
-			args[0].Type())
 	}
+	return NULL
 }
 
 // val = math.rand()
@@ -40,11 +33,6 @@ func mathRandom(args ...OBJ) OBJ {
 
 // val = math.sqrt(int);
 func mathSqrt(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		v := arg.Value
@@ -52,25 +40,16 @@ func mathSqrt(args ...OBJ) OBJ {
 	case *object.Float:
 		v := arg.Value
 		return &object.Float{Value: math.Sqrt(v)}
-	default:
-		return NewError("argument to `math.sqrt` not supported, got=%s",
-			args[0].Type())
 	}
+	return NULL
 }
 
 func init() {
 	// Setup our random seed.
 	rand.Seed(time.Now().UnixNano())
-	RegisterBuiltin("math.abs",
-		func(env *ENV, args ...OBJ) OBJ {
-			return mathAbs(args...)
-		})
-	RegisterBuiltin("math.rand",
-		func(env *ENV, args ...OBJ) OBJ {
-			return mathRandom(args...)
-		})
-	RegisterBuiltin("math.sqrt",
-		func(env *ENV, args ...OBJ) OBJ {
-			return mathSqrt(args...)
-		})
+	RegisterBuiltin("math.abs", noEnv(mathAbs),
+		object.Arg("n", object.INTEGER_OBJ, object.FLOAT_OBJ))
+	RegisterBuiltin("math.rand", noEnv(mathRandom))
+	RegisterBuiltin("math.sqrt", noEnv(mathSqrt),
+		object.Arg("n", object.INTEGER_OBJ, object.FLOAT_OBJ))
 }
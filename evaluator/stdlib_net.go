@@ -297,37 +297,52 @@ func Read(args ...OBJ) OBJ {
 	return &object.String{Value: string(buf[:n])}
 }
 
+// The sandboxed versions of the builtins which make sockets or give them
+// addresses; everything else works on sockets these made.
+
+func netSocket(env *ENV, args ...OBJ) OBJ {
+	if err := runtimeOf(env).checkAnyNet(); err != nil {
+		return err
+	}
+	return Socket(args...)
+}
+
+func netConnect(env *ENV, args ...OBJ) OBJ {
+	address := args[1].(*object.String).Value
+	if err := runtimeOf(env).checkNet(address); err != nil {
+		return err
+	}
+	return Connect(args...)
+}
+
+func netBind(env *ENV, args ...OBJ) OBJ {
+	address := args[1].(*object.String).Value
+	if err := runtimeOf(env).checkNet(address); err != nil {
+		return err
+	}
+	return Bind(args...)
+}
+
 func init() {
-	RegisterBuiltin("net.socket",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Socket(args...)
-		})
-	RegisterBuiltin("net.listen",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Listen(args...)
-		})
-	RegisterBuiltin("net.connect",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Connect(args...)
-		})
-	RegisterBuiltin("net.close",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Close(args...)
-		})
-	RegisterBuiltin("net.bind",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Bind(args...)
-		})
-	RegisterBuiltin("net.accept",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Accept(args...)
-		})
-	RegisterBuiltin("net.write",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Write(args...)
-		})
-	RegisterBuiltin("net.read",
-		func(env *ENV, args ...OBJ) OBJ {
-			return Read(args...)
-		})
+	RegisterBuiltin("net.socket", netSocket,
+		object.Arg("type", object.STRING_OBJ))
+	RegisterBuiltin("net.listen", noEnv(Listen),
+		object.Arg("fd", object.INTEGER_OBJ),
+		object.Arg("backlog", object.INTEGER_OBJ))
+	RegisterBuiltin("net.connect", netConnect,
+		object.Arg("fd", object.INTEGER_OBJ),
+		object.Arg("address", object.STRING_OBJ))
+	RegisterBuiltin("net.close", noEnv(Close),
+		object.Arg("fd", object.INTEGER_OBJ))
+	RegisterBuiltin("net.bind", netBind,
+		object.Arg("fd", object.INTEGER_OBJ),
+		object.Arg("address", object.STRING_OBJ))
+	RegisterBuiltin("net.accept", noEnv(Accept),
+		object.Arg("fd", object.INTEGER_OBJ))
+	RegisterBuiltin("net.write", noEnv(Write),
+		object.Arg("fd", object.INTEGER_OBJ),
+		object.Arg("data", object.STRING_OBJ))
+	RegisterBuiltin("net.read", noEnv(Read),
+		object.Arg("fd", object.INTEGER_OBJ),
+		object.OptionalArg("size", object.INTEGER_OBJ))
 }
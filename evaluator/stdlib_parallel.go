@@ -0,0 +1,166 @@
+package evaluator
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zacanger/cozy/object"
+)
+
+// workersOf returns how many workers the options of a parallel method ask
+// for: {"workers": n}, or one for each CPU.
+func workersOf(name string, args []OBJ) (int, OBJ) {
+	if len(args) == 0 {
+		return runtime.NumCPU(), nil
+	}
+	opts := args[0].(*object.Hash)
+	pair, ok := opts.Pairs[(&object.String{Value: "workers"}).HashKey()]
+	if !ok {
+		return runtime.NumCPU(), nil
+	}
+	n, ok := pair.Value.(*object.Integer)
+	if !ok || n.Value < 1 {
+		return 0, NewError("%s: workers must be a positive integer, got %s",
+			name, pair.Value.Inspect())
+	}
+	return int(n.Value), nil
+}
+
+// parallel calls a function with each element of an array and its index,
+// on as many goroutines at once as the options allow, returning the
+// results in order. If any calls raise an error, the one for the lowest
+// index is returned, as map would; the calls for higher indexes which are
+// still running are cancelled, and the rest aren't made.
+func parallel(env *ENV, name string, self OBJ, args []OBJ) ([]OBJ, OBJ) {
+	elements := self.(*object.Array).Elements
+	fn := args[0]
+	workers, err := workersOf(name, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]OBJ, len(elements))
+	errs := make([]OBJ, len(elements))
+
+	// mu guards failed, the lowest index which raised an error, and the
+	// cancel functions of the calls running.
+	var mu sync.Mutex
+	failed := len(elements)
+	cancels := map[int]context.CancelFunc{}
+
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(elements); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				mu.Lock()
+				if i >= failed || (env.Context != nil && env.Context.Err() != nil) {
+					mu.Unlock()
+					return
+				}
+				callEnv, cancel := cancellable(env)
+				cancels[i] = cancel
+				mu.Unlock()
+
+				index := &object.Integer{Value: int64(i)}
+				res := ApplyFunction(callEnv, fn, []OBJ{elements[i], index})
+
+				mu.Lock()
+				delete(cancels, i)
+				cancel()
+				if isError(res) {
+					errs[i] = res
+					if i < failed {
+						failed = i
+						for j, c := range cancels {
+							if j > i {
+								c()
+							}
+						}
+					}
+				}
+				mu.Unlock()
+				if res == nil {
+					res = NULL
+				}
+				results[i] = res
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed < len(elements) {
+		return nil, errs[failed]
+	}
+	return results, nil
+}
+
+// arrayPmap is array.map, calling the function on several elements at
+// once, on as many workers as {"workers": n} says.
+func arrayPmap(env *ENV, self OBJ, args ...OBJ) OBJ {
+	results, err := parallel(env, "array.pmap", self, args)
+	if err != nil {
+		return err
+	}
+	return &object.Array{Elements: results}
+}
+
+// arrayPfilter is array.filter, calling the predicate on several elements
+// at once, on as many workers as {"workers": n} says.
+func arrayPfilter(env *ENV, self OBJ, args ...OBJ) OBJ {
+	results, err := parallel(env, "array.pfilter", self, args)
+	if err != nil {
+		return err
+	}
+	elements := self.(*object.Array).Elements
+	kept := make([]OBJ, 0, len(elements))
+	for i, res := range results {
+		if isTruthy(res) {
+			kept = append(kept, elements[i])
+		}
+	}
+	return &object.Array{Elements: kept}
+}
+
+// poolFn makes a pool of n workers, which run the functions submitted to
+// it.
+func poolFn(env *ENV, args ...OBJ) OBJ {
+	n := args[0].(*object.Integer).Value
+	if n < 1 {
+		return NewError("core.pool: size must be positive, got %d", n)
+	}
+	return object.NewPool(int(n))
+}
+
+// poolSubmitMethod calls a function with the rest of the arguments once a
+// worker is free, returning a future for its result.
+func poolSubmitMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	fnArgs := append(make([]OBJ, 0, len(args)-1), args[1:]...)
+	return async(env, args[0], fnArgs, self.(*object.Pool))
+}
+
+func poolSizeMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return &object.Integer{Value: int64(self.(*object.Pool).Size())}
+}
+
+func init() {
+	RegisterBuiltin("core.pool", poolFn,
+		object.Arg("size", object.INTEGER_OBJ))
+
+	registerMethod(object.ARRAY_OBJ, "pmap", arrayPmap,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ),
+		object.OptionalArg("options", object.HASH_OBJ))
+	registerMethod(object.ARRAY_OBJ, "pfilter", arrayPfilter,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ),
+		object.OptionalArg("options", object.HASH_OBJ))
+
+	registerMethod(object.POOL_OBJ, "submit", poolSubmitMethod,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ),
+		object.VariadicArg("args"))
+	registerMethod(object.POOL_OBJ, "size", poolSizeMethod)
+}
@@ -0,0 +1,168 @@
+package evaluator
+
+import (
+	"github.com/zacanger/cozy/object"
+)
+
+func syncMutex(args ...OBJ) OBJ {
+	return object.NewMutex()
+}
+
+// mutexLockMethod waits for the lock, which can be cancelled.
+func mutexLockMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	if err := acquire(env, self.(*object.Mutex).Acquire()); err != nil {
+		return err
+	}
+	return NULL
+}
+
+func mutexUnlockMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	if !self.(*object.Mutex).Unlock() {
+		return NewError("mutex.unlock: the mutex isn't locked")
+	}
+	return NULL
+}
+
+// mutexWithMethod calls a function holding the lock, which is released
+// when it returns, even if it raises an error.
+func mutexWithMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	m := self.(*object.Mutex)
+	if err := acquire(env, m.Acquire()); err != nil {
+		return err
+	}
+	defer m.Unlock()
+	return ApplyFunction(env, args[0], make([]OBJ, 0))
+}
+
+func mutexLockedMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return nativeBoolToBooleanObject(self.(*object.Mutex).Locked())
+}
+
+func syncWaitGroup(args ...OBJ) OBJ {
+	return object.NewWaitGroup()
+}
+
+// waitGroupAddMethod adds to the count, one by default, returning the new count.
+func waitGroupAddMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	delta := int64(1)
+	if len(args) > 0 {
+		delta = args[0].(*object.Integer).Value
+	}
+	n, ok := self.(*object.WaitGroup).Add(delta)
+	if !ok {
+		return NewError("wait_group.add: the count can't go below zero")
+	}
+	return &object.Integer{Value: n}
+}
+
+func waitGroupDoneMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	n, ok := self.(*object.WaitGroup).Add(-1)
+	if !ok {
+		return NewError("wait_group.done: nothing is left to be done")
+	}
+	return &object.Integer{Value: n}
+}
+
+// waitGroupWaitMethod waits until the count is zero, or raises an error
+// if it isn't within the optional timeout in milliseconds.
+func waitGroupWaitMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	zero := self.(*object.WaitGroup).Zero()
+	i, err := waitFor(env, []<-chan struct{}{zero}, deadlineOf(args, 0))
+	switch {
+	case err != nil:
+		return err
+	case i < 0:
+		return timedOut("wait_group.wait", args, 0)
+	}
+	return NULL
+}
+
+func waitGroupCountMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return &object.Integer{Value: self.(*object.WaitGroup).Count()}
+}
+
+func syncAtomic(args ...OBJ) OBJ {
+	n := int64(0)
+	if len(args) > 0 {
+		n = args[0].(*object.Integer).Value
+	}
+	return object.NewAtomic(n)
+}
+
+// atomicAddMethod adds to the value, one by default, returning the new
+// value.
+func atomicAddMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	delta := int64(1)
+	if len(args) > 0 {
+		delta = args[0].(*object.Integer).Value
+	}
+	return &object.Integer{Value: self.(*object.Atomic).Add(delta)}
+}
+
+func atomicGetMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	return &object.Integer{Value: self.(*object.Atomic).Get()}
+}
+
+func atomicSetMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	self.(*object.Atomic).Set(args[0].(*object.Integer).Value)
+	return args[0]
+}
+
+// atomicCompareAndSwapMethod sets the value to new if it's old, reporting
+// whether it was.
+func atomicCompareAndSwapMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	old := args[0].(*object.Integer).Value
+	new := args[1].(*object.Integer).Value
+	return nativeBoolToBooleanObject(self.(*object.Atomic).CompareAndSwap(old, new))
+}
+
+func syncOnce(args ...OBJ) OBJ {
+	return object.NewOnce(args[0])
+}
+
+// onceCallMethod calls the function the first time, and returns what it
+// returned, or raises the error it raised, every time.
+func onceCallMethod(env *ENV, self OBJ, args ...OBJ) OBJ {
+	o := self.(*object.Once)
+	res := o.Do(func() OBJ {
+		res := ApplyFunction(env, o.Fn, make([]OBJ, 0))
+		if res == nil {
+			res = NULL
+		}
+		return res
+	})
+	return reraise(res)
+}
+
+func init() {
+	RegisterBuiltin("sync.mutex", noEnv(syncMutex))
+	RegisterBuiltin("sync.wait_group", noEnv(syncWaitGroup))
+	RegisterBuiltin("sync.atomic", noEnv(syncAtomic),
+		object.OptionalArg("n", object.INTEGER_OBJ))
+	RegisterBuiltin("sync.once", noEnv(syncOnce),
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ))
+
+	registerMethod(object.MUTEX_OBJ, "lock", mutexLockMethod)
+	registerMethod(object.MUTEX_OBJ, "unlock", mutexUnlockMethod)
+	registerMethod(object.MUTEX_OBJ, "with", mutexWithMethod,
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ))
+	registerMethod(object.MUTEX_OBJ, "locked?", mutexLockedMethod)
+
+	registerMethod(object.WAIT_GROUP_OBJ, "add", waitGroupAddMethod,
+		object.OptionalArg("n", object.INTEGER_OBJ))
+	registerMethod(object.WAIT_GROUP_OBJ, "done", waitGroupDoneMethod)
+	registerMethod(object.WAIT_GROUP_OBJ, "wait", waitGroupWaitMethod,
+		object.OptionalArg("ms", object.INTEGER_OBJ))
+	registerMethod(object.WAIT_GROUP_OBJ, "count", waitGroupCountMethod)
+
+	registerMethod(object.ATOMIC_OBJ, "add", atomicAddMethod,
+		object.OptionalArg("n", object.INTEGER_OBJ))
+	registerMethod(object.ATOMIC_OBJ, "get", atomicGetMethod)
+	registerMethod(object.ATOMIC_OBJ, "set", atomicSetMethod,
+		object.Arg("n", object.INTEGER_OBJ))
+	registerMethod(object.ATOMIC_OBJ, "compare_and_swap", atomicCompareAndSwapMethod,
+		object.Arg("old", object.INTEGER_OBJ),
+		object.Arg("new", object.INTEGER_OBJ))
+
+	registerMethod(object.ONCE_OBJ, "call", onceCallMethod)
+}
@@ -0,0 +1,57 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/parser"
+)
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let count = fn () {
+			let m = sync.mutex()
+			let wg = sync.wait_group()
+			mutable n = 0
+			foreach i in [1, 2, 3, 4, 5, 6, 7, 8] {
+				wg.add()
+				core.spawn(fn () {
+					foreach j in [1, 2, 3, 4, 5, 6, 7, 8] { m.with(fn () { n += 1 }) }
+					wg.done()
+				})
+			}
+			wg.wait()
+			n
+		}
+		count()`, "64"},
+		{`let m = sync.mutex(); m.lock(); let l = m.locked?(); m.unlock(); util.string([l, m.locked?()])`, "[true, false]"},
+		{`let m = sync.mutex(); try { m.with(fn () { panic(error("no")) }) } catch e { m.locked?() }`, "false"},
+		{`try { sync.mutex().unlock() } catch e { e.message }`, "mutex.unlock: the mutex isn't locked"},
+		{`let wg = sync.wait_group(); wg.add(2); wg.done(); util.string([wg.count(), wg])`, "[1, <wait_group:1>]"},
+		{`let wg = sync.wait_group(); wg.add(); try { wg.wait(10) } catch e { e.message }`,
+			"wait_group.wait: timed out after 10ms"},
+		{`try { sync.wait_group().done() } catch e { e.message }`, "wait_group.done: nothing is left to be done"},
+		{`let a = sync.atomic(5); util.string([a.add(), a.add(-3), a.get()])`, "[6, 3, 3]"},
+		{`let a = sync.atomic(); util.string([a.compare_and_swap(0, 7), a.compare_and_swap(0, 8), a.get()])`,
+			"[true, false, 7]"},
+		{`let a = sync.atomic(); a.set(4); a`, "<atomic:4>"},
+		{`let a = sync.atomic(); let o = sync.once(fn () { a.add() }); o.call(); o.call(); util.string([o.call(), a.get()])`,
+			"[1, 1]"},
+		{`let o = sync.once(fn () { panic(error("bad")) }); try { o.call() } catch e { try { o.call() } catch e { e.message } }`,
+			"bad"},
+		{`try { sync.atomic().add("x") } catch e { e.message }`,
+			"atomic.add: argument 1 (n) must be INTEGER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, NewRuntime().NewEnvironment())
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
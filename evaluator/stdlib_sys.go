@@ -2,7 +2,7 @@ package evaluator
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"os"
 	"os/exec"
 	"regexp"
@@ -10,7 +10,6 @@ import (
 	"strings"
 
 	"github.com/zacanger/cozy/object"
-	"github.com/zacanger/cozy/utils"
 )
 
 // Split a line of text into tokens, but keep anything "quoted"
@@ -39,46 +38,35 @@ func splitCommand(input string) []string {
 }
 
 // environment() -> (Hash)
-func envFn(args ...OBJ) OBJ {
-	env := os.Environ()
+func envFn(env *ENV, args ...OBJ) OBJ {
+	if err := runtimeOf(env).checkEnv(); err != nil {
+		return err
+	}
+	vars := os.Environ()
 	vals := make(StringObjectMap)
 
 	// If we get a match then the output is an array
 	// First entry is the match, any additional parts
 	// are the capture-groups.
-	for i := 1; i < len(env); i++ {
-		vals[env[i]] = &object.String{Value: os.Getenv(env[i])}
+	for i := 1; i < len(vars); i++ {
+		vals[vars[i]] = &object.String{Value: os.Getenv(vars[i])}
 	}
 	return NewHash(vals)
 }
 
 // getenv("PATH") -> string
-func getEnvFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-	if args[0].Type() != object.STRING_OBJ {
-		return NewError("argument must be a string, got=%s",
-			args[0].Type())
+func getEnvFn(env *ENV, args ...OBJ) OBJ {
+	if err := runtimeOf(env).checkEnv(); err != nil {
+		return err
 	}
 	input := args[0].(*object.String).Value
 	return &object.String{Value: os.Getenv(input)}
 }
 
 // setenv("PATH", "/home/z/bin:/usr/bin");
-func setEnvFn(args ...OBJ) OBJ {
-	if len(args) != 2 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-	if args[0].Type() != object.STRING_OBJ {
-		return NewError("argument must be a string, got=%s",
-			args[0].Type())
-	}
-	if args[1].Type() != object.STRING_OBJ {
-		return NewError("argument must be a string, got=%s",
-			args[1].Type())
+func setEnvFn(env *ENV, args ...OBJ) OBJ {
+	if err := runtimeOf(env).checkEnv(); err != nil {
+		return err
 	}
 	name := args[0].(*object.String).Value
 	value := args[1].(*object.String).Value
@@ -86,7 +74,9 @@ func setEnvFn(args ...OBJ) OBJ {
 	return NULL
 }
 
-func sysExit(args ...OBJ) OBJ {
+// sysExit ends the program. The process only exits once the error it
+// returns has unwound to whatever is running the program.
+func sysExit(env *ENV, args ...OBJ) OBJ {
 	code := 0
 
 	// Optionally an exit-code might be supplied as an argument
@@ -99,30 +89,21 @@ func sysExit(args ...OBJ) OBJ {
 		}
 	}
 
-	utils.ExitConditionally(code)
-	return &object.Integer{Value: int64(code)}
+	return runtimeOf(env).exit(code)
 }
 
 // Run a command and return a hash containing the result.
 // `stderr`, `stdout`, and `error` will be the fields
-func sysExec(args ...OBJ) OBJ {
-	if len(args) < 1 {
-		return NewError("`sys.exec` wanted string, got invalid argument")
-	}
-
-	var command string
-	switch c := args[0].(type) {
-	case *object.String:
-		command = c.Value
-	default:
-		return NewError("`sys.exec` wanted string, got invalid argument")
-	}
-
-	if len(command) < 1 {
-		return NewError("`sys.exec` expected string, got invalid argument")
-	}
+func sysExec(env *ENV, args ...OBJ) OBJ {
+	command := args[0].(*object.String).Value
 	// split the command
 	toExec := splitCommand(command)
+	if len(toExec) == 0 {
+		return NewError("sys.exec: the command is empty")
+	}
+	if err := runtimeOf(env).checkRun(toExec[0]); err != nil {
+		return err
+	}
 	cmd := exec.Command(toExec[0], toExec[1:]...)
 
 	// get the result
@@ -131,12 +112,11 @@ func sysExec(args ...OBJ) OBJ {
 	cmd.Stderr = &errb
 	err := cmd.Run()
 
-	// If the command exits with a non-zero exit-code it
-	// is regarded as a failure. Here we test for ExitError
-	// to regard that as a non-failure.
-	if err != nil && err != err.(*exec.ExitError) {
-		fmt.Printf("Failed to run '%s' -> %s\n", command, err.Error())
-		return &object.Error{Message: "Failed to run command!"}
+	// A command which exits with a non-zero exit-code still ran, so only
+	// a command which couldn't be run at all is an error.
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return NewError("sys.exec: %s", err)
 	}
 
 	// The result-objects to store in our hash.
@@ -206,13 +186,12 @@ func flagFn(args ...OBJ) OBJ {
 	return FALSE
 }
 
-func cdFn(args ...OBJ) OBJ {
-	switch a := args[0].(type) {
-	case *object.String:
-		os.Chdir(a.Value)
-	default:
-		return NewError("cd expected string argument!")
+func cdFn(env *ENV, args ...OBJ) OBJ {
+	path := args[0].(*object.String).Value
+	if err := runtimeOf(env).checkRead(path); err != nil {
+		return err
 	}
+	os.Chdir(path)
 	return NULL
 }
 
@@ -225,40 +204,20 @@ func infoFn(args ...OBJ) OBJ {
 }
 
 func init() {
-	RegisterBuiltin("sys.getenv",
-		func(env *ENV, args ...OBJ) OBJ {
-			return getEnvFn(args...)
-		})
-	RegisterBuiltin("sys.setenv",
-		func(env *ENV, args ...OBJ) OBJ {
-			return setEnvFn(args...)
-		})
-	RegisterBuiltin("sys.environment",
-		func(env *ENV, args ...OBJ) OBJ {
-			return envFn(args...)
-		})
-	RegisterBuiltin("sys.exit",
-		func(env *ENV, args ...OBJ) OBJ {
-			return sysExit(args...)
-		})
-	RegisterBuiltin("sys.exec",
-		func(env *ENV, args ...OBJ) OBJ {
-			return sysExec(args...)
-		})
-	RegisterBuiltin("sys.flag",
-		func(env *ENV, args ...OBJ) OBJ {
-			return flagFn(args...)
-		})
-	RegisterBuiltin("sys.args",
-		func(env *ENV, args ...OBJ) OBJ {
-			return argsFn(args...)
-		})
-	RegisterBuiltin("sys.cd",
-		func(env *ENV, args ...OBJ) OBJ {
-			return cdFn(args...)
-		})
-	RegisterBuiltin("sys.info",
-		func(env *ENV, args ...OBJ) OBJ {
-			return infoFn(args...)
-		})
+	RegisterBuiltin("sys.getenv", getEnvFn,
+		object.Arg("name", object.STRING_OBJ))
+	RegisterBuiltin("sys.setenv", setEnvFn,
+		object.Arg("name", object.STRING_OBJ),
+		object.Arg("value", object.STRING_OBJ))
+	RegisterBuiltin("sys.environment", envFn)
+	RegisterBuiltin("sys.exit", sysExit,
+		object.OptionalArg("code", object.INTEGER_OBJ, object.FLOAT_OBJ))
+	RegisterBuiltin("sys.exec", sysExec,
+		object.Arg("command", object.STRING_OBJ))
+	RegisterBuiltin("sys.flag", noEnv(flagFn),
+		object.Arg("name", object.STRING_OBJ))
+	RegisterBuiltin("sys.args", noEnv(argsFn))
+	RegisterBuiltin("sys.cd", cdFn,
+		object.Arg("path", object.STRING_OBJ))
+	RegisterBuiltin("sys.info", noEnv(infoFn))
 }
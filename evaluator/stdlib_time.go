@@ -1,22 +1,19 @@
 package evaluator
 
 import (
-	"math/rand"
 	"time"
 
 	"github.com/zacanger/cozy/object"
 )
 
-func timeSleep(args ...OBJ) OBJ {
-	var ms int64
-	switch arg := args[0].(type) {
-	case *object.Integer:
-		ms = arg.Value
-	default:
-		return NewError("argument to `time.sleep` not supported, got=%s", arg.Type())
-	}
+func timeSleep(env *ENV, args ...OBJ) OBJ {
+	ms := args[0].(*object.Integer).Value
 
-	time.Sleep(time.Duration(ms) * time.Millisecond)
+	// Wake up early if the run is cancelled.
+	deadline := time.Now().Add(time.Duration(ms) * time.Millisecond)
+	if _, err := waitFor(env, nil, deadline); err != nil {
+		return err
+	}
 	return &object.Integer{Value: ms}
 }
 
@@ -28,55 +25,26 @@ func timeUtc(args ...OBJ) OBJ {
 	return &object.String{Value: time.Now().Format(time.RFC3339)}
 }
 
-var intervalIDs map[int64]chan bool
-var timeoutIDs map[int64]bool
-
 func timeTimeout(env *ENV, args ...OBJ) OBJ {
-	var ms int64
-	var f *object.Function
-	switch t := args[0].(type) {
-	case *object.Integer:
-		ms = t.Value
-	default:
-		return NewError("First argument to `time.timeout` should be integer!")
-	}
+	ms := args[0].(*object.Integer).Value
+	f := args[1]
 
-	switch tt := args[1].(type) {
-	case *object.Function:
-		f = tt
-	default:
-		return NewError("Second argument to `time.timeout should be function!`")
-	}
-
-	timeoutID := rand.Int63()
-	timeoutIDs[timeoutID] = false
-	time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
-		v, ok := timeoutIDs[timeoutID]
-		if ok && !v {
-			ApplyFunction(env, f, make([]OBJ, 0))
-		}
+	rt := runtimeOf(env)
+	timeoutID := rt.addTimeout(time.Duration(ms)*time.Millisecond, func() {
+		rt.callback(env, f)
 	})
 
 	return &object.Integer{Value: timeoutID}
 }
 
 func timeInterval(env *ENV, args ...OBJ) OBJ {
-	var ms int64
-	var f *object.Function
-	switch t := args[0].(type) {
-	case *object.Integer:
-		ms = t.Value
-	default:
-		return NewError("First argument to `time.interval` should be integer!")
-	}
-
-	switch tt := args[1].(type) {
-	case *object.Function:
-		f = tt
-	default:
-		return NewError("Second argument to `time.interval should be function!`")
+	ms := args[0].(*object.Integer).Value
+	f := args[1]
+	if ms <= 0 {
+		return NewError("time.interval: ms must be positive")
 	}
 
+	rt := runtimeOf(env)
 	ticker := time.NewTicker(time.Duration(ms) * time.Millisecond)
 	clear := make(chan bool)
 
@@ -84,7 +52,13 @@ func timeInterval(env *ENV, args ...OBJ) OBJ {
 		for {
 			select {
 			case <-ticker.C:
-				go ApplyFunction(env, f, make([]OBJ, 0))
+				// Each call keeps the event loop running, even if
+				// it cancels the interval.
+				rt.hold()
+				go func() {
+					defer rt.release()
+					rt.callback(env, f)
+				}()
 			case <-clear:
 				ticker.Stop()
 				return
@@ -92,55 +66,27 @@ func timeInterval(env *ENV, args ...OBJ) OBJ {
 		}
 	}()
 
-	intervalID := rand.Int63()
-	intervalIDs[intervalID] = clear
+	intervalID := rt.addInterval(clear)
 	return &object.Integer{Value: intervalID}
 }
 
-func timeCancel(args ...OBJ) OBJ {
-	switch t := args[0].(type) {
-	case *object.Integer:
-		if intervalIDs[t.Value] != nil {
-			intervalIDs[t.Value] <- true
-		} else {
-			_, ok := timeoutIDs[t.Value]
-			if ok {
-				timeoutIDs[t.Value] = true
-			}
-		}
-	default:
-		return NewError("Expected timerid, got %s", args[0].Type())
-	}
-
+func timeCancel(env *ENV, args ...OBJ) OBJ {
+	id := args[0].(*object.Integer).Value
+	runtimeOf(env).cancelTimer(id)
 	return NULL
 }
 
 func init() {
-	intervalIDs = make(map[int64]chan bool)
-	timeoutIDs = make(map[int64]bool)
-
-	RegisterBuiltin("time.sleep",
-		func(env *ENV, args ...OBJ) OBJ {
-			return timeSleep(args...)
-		})
-	RegisterBuiltin("time.unix",
-		func(env *ENV, args ...OBJ) OBJ {
-			return timeUnix(args...)
-		})
-	RegisterBuiltin("time.utc",
-		func(env *ENV, args ...OBJ) OBJ {
-			return timeUtc(args...)
-		})
-	RegisterBuiltin("time.interval",
-		func(env *ENV, args ...OBJ) OBJ {
-			return timeInterval(env, args...)
-		})
-	RegisterBuiltin("time.timeout",
-		func(env *ENV, args ...OBJ) OBJ {
-			return timeTimeout(env, args...)
-		})
-	RegisterBuiltin("time.cancel",
-		func(env *ENV, args ...OBJ) OBJ {
-			return timeCancel(args...)
-		})
+	RegisterBuiltin("time.sleep", timeSleep,
+		object.Arg("ms", object.INTEGER_OBJ))
+	RegisterBuiltin("time.unix", noEnv(timeUnix))
+	RegisterBuiltin("time.utc", noEnv(timeUtc))
+	RegisterBuiltin("time.interval", timeInterval,
+		object.Arg("ms", object.INTEGER_OBJ),
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ))
+	RegisterBuiltin("time.timeout", timeTimeout,
+		object.Arg("ms", object.INTEGER_OBJ),
+		object.Arg("fn", object.FUNCTION_OBJ, object.BUILTIN_OBJ))
+	RegisterBuiltin("time.cancel", timeCancel,
+		object.Arg("id", object.INTEGER_OBJ))
 }
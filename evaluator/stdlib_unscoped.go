@@ -2,37 +2,26 @@ package evaluator
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/zacanger/cozy/object"
-	"github.com/zacanger/cozy/utils"
+	"github.com/zacanger/cozy/token"
 )
 
 // These stdlib functions aren't scoped/namespaced
 
-// panic
+// panic raises an error, which stops the program unless it's caught
 func panicFn(args ...OBJ) OBJ {
-	switch e := args[0].(type) {
-	case *object.Error:
-		c := 1
-		fmt.Println(e.Message)
-		if e.Code != nil {
-			c = int(*e.Code)
-		}
-		utils.ExitConditionally(c)
-	default:
-		return NewError("panic expected an error!")
-	}
-	return NULL
+	raised := *args[0].(*object.Error)
+	raised.BuiltinCall = false
+	// report where it was raised, not where it was made
+	raised.Pos = token.Position{}
+	raised.Stack = nil
+	raised.CaughtIn = ""
+	return &raised
 }
 
 // error
 func errorFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
 	switch t := args[0].(type) {
 	case *object.String:
 		return &object.Error{Message: t.Value, BuiltinCall: true}
@@ -62,37 +51,17 @@ func errorFn(args ...OBJ) OBJ {
 			}
 		}
 		if data != nil {
-			e.Data = data.JSON(false)
+			e.Data = data
 		}
 		return e
-	default:
-		return NewError("error() expected a string or hash!")
 	}
+	return NULL
 }
 
 // output a string to stdout
 func printFn(args ...OBJ) OBJ {
 	for _, arg := range args {
-		var e error
-		s := arg.Inspect()
-
-		if arg.Type() == object.STRING_OBJ {
-			if strings.Contains(s, "\\") {
-				orig := s
-				// double escape;
-				// used for ansi escape codes and some other things
-				s, e = strconv.Unquote(`"` + s + `"`)
-				if e != nil {
-					// this happens sometimes when working on things like
-					// nested json, so we just use the original string instead
-					fmt.Printf(orig + " ")
-				}
-				fmt.Println(s + " ")
-				return NULL
-			}
-		}
-
-		fmt.Printf(s + " ")
+		fmt.Print(arg.Inspect() + " ")
 	}
 
 	fmt.Println()
@@ -100,16 +69,10 @@ func printFn(args ...OBJ) OBJ {
 }
 
 func init() {
-	RegisterBuiltin("print",
-		func(env *ENV, args ...OBJ) OBJ {
-			return printFn(args...)
-		})
-	RegisterBuiltin("error",
-		func(env *ENV, args ...OBJ) OBJ {
-			return errorFn(args...)
-		})
-	RegisterBuiltin("panic",
-		func(env *ENV, args ...OBJ) OBJ {
-			return panicFn(args...)
-		})
+	RegisterBuiltin("print", noEnv(printFn),
+		object.VariadicArg("values"))
+	RegisterBuiltin("error", noEnv(errorFn),
+		object.Arg("value", object.STRING_OBJ, object.HASH_OBJ))
+	RegisterBuiltin("panic", noEnv(panicFn),
+		object.Arg("error", object.ERROR_OBJ))
 }
@@ -10,10 +10,6 @@ import (
 
 // convert a string to a float
 func floatFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
 	switch args[0].(type) {
 	case *object.String:
 		input := args[0].(*object.String).Value
@@ -40,18 +36,12 @@ func floatFn(args ...OBJ) OBJ {
 	case *object.Integer:
 		input := args[0].(*object.Integer).Value
 		return &object.Float{Value: float64(input)}
-	default:
-		return NewError("argument to `float` not supported, got=%s",
-			args[0].Type())
 	}
+	return NULL
 }
 
 // convert a double/string to an int
 func intFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
 	switch args[0].(type) {
 	case *object.String:
 		input := args[0].(*object.String).Value
@@ -74,18 +64,12 @@ func intFn(args ...OBJ) OBJ {
 	case *object.Float:
 		input := args[0].(*object.Float).Value
 		return &object.Integer{Value: int64(input)}
-	default:
-		return NewError("argument to `int` not supported, got=%s",
-			args[0].Type())
 	}
+	return NULL
 }
 
 // length of item
 func lenFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
 	switch arg := args[0].(type) {
 	case *object.String:
 		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
@@ -97,50 +81,30 @@ func lenFn(args ...OBJ) OBJ {
 		return &object.Integer{Value: 0}
 	case *object.Hash:
 		return &object.Integer{Value: int64(len(arg.Pairs))}
-	default:
-		return NewError("argument to `len` not supported, got=%s",
-			args[0].Type())
 	}
+	return NULL
 }
 
 func strFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
-
 	out := args[0].Inspect()
 	return &object.String{Value: out}
 }
 
 // type of an item
 func typeFn(args ...OBJ) OBJ {
-	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1",
-			len(args))
-	}
 	return &object.String{Value: strings.ToLower(string(args[0].Type()))}
 }
 
 func init() {
-	RegisterBuiltin("util.int",
-		func(env *ENV, args ...OBJ) OBJ {
-			return intFn(args...)
-		})
-	RegisterBuiltin("util.float",
-		func(env *ENV, args ...OBJ) OBJ {
-			return floatFn(args...)
-		})
-	RegisterBuiltin("util.len",
-		func(env *ENV, args ...OBJ) OBJ {
-			return lenFn(args...)
-		})
-	RegisterBuiltin("util.string",
-		func(env *ENV, args ...OBJ) OBJ {
-			return strFn(args...)
-		})
-	RegisterBuiltin("util.type",
-		func(env *ENV, args ...OBJ) OBJ {
-			return typeFn(args...)
-		})
+	RegisterBuiltin("util.int", noEnv(intFn),
+		object.Arg("value", object.STRING_OBJ, object.BOOLEAN_OBJ, object.INTEGER_OBJ, object.FLOAT_OBJ))
+	RegisterBuiltin("util.float", noEnv(floatFn),
+		object.Arg("value", object.STRING_OBJ, object.BOOLEAN_OBJ, object.INTEGER_OBJ, object.FLOAT_OBJ))
+	RegisterBuiltin("util.len", noEnv(lenFn),
+		object.Arg("value", object.STRING_OBJ, object.DOCSTRING_OBJ,
+			object.ARRAY_OBJ, object.NULL_OBJ, object.HASH_OBJ))
+	RegisterBuiltin("util.string", noEnv(strFn),
+		object.Arg("value"))
+	RegisterBuiltin("util.type", noEnv(typeFn),
+		object.Arg("value"))
 }
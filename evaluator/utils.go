@@ -5,7 +5,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 
@@ -49,8 +48,12 @@ func exists(path string) bool {
 
 // FindModule finds a module based on name, used by the evaluator
 func FindModule(name string) string {
+	return findModuleIn(searchPaths, name)
+}
+
+func findModuleIn(paths []string, name string) string {
 	basename := fmt.Sprintf("%s.cz", name)
-	for _, p := range searchPaths {
+	for _, p := range paths {
 		filename := filepath.Join(p, basename)
 		if exists(filename) {
 			return filename
@@ -65,52 +68,32 @@ func IsNumber(s string) bool {
 	return err == nil
 }
 
-// Interpolate (str, env)
-// return input string with $vars interpolated from environment
-func Interpolate(str string, env *ENV) string {
-	// Match all strings preceded by {{
-	re := regexp.MustCompile(`(?s)(\\)?(\{\{)(.*?)(\}\})`)
-	str = re.ReplaceAllStringFunc(str, func(m string) string {
-		// If the string starts with a backslash, that's an escape, so we should
-		// replace it with the remaining portion of the match. \{{VAR}} becomes
-		// {{VAR}}
-		if string(m[0]) == "\\" {
-			return m[1:]
-		}
-
-		varName := ""
-
-		// If you type a variable wrong, forgetting the closing bracket, we
-		// simply return it to you: eg "my {{variable"
+// Interpolate returns a template, read from file, with the expressions
+// in its {{ }} parts evaluated in env and filled in, or the first error
+// in it.
+func Interpolate(file string, str string, env *ENV) OBJ {
+	parts, err := lexer.Template(file, str)
+	if err != nil {
+		return NewError("ParseError: %s", err)
+	}
 
-		if m[len(m)-1] != '}' || m[len(m)-2] != '}' {
-			return m
+	var out strings.Builder
+	for _, part := range parts {
+		if !part.IsExpr {
+			out.WriteString(part.Text)
+			continue
 		}
-
-		varName = m[2 : len(m)-2]
-
-		v, ok := env.Get(varName)
-
-		// The variable might be an index expression
-		if !ok {
-			// Basically just spinning up a whole new instance of cozy; very
-			// inefficient, but it's the same thing we do on every module import
-			l := lexer.New(string(varName))
-			p := parser.New(l)
-			program := p.ParseProgram()
-			evaluated := Eval(program, env)
-			if evaluated != nil {
-				return evaluated.Inspect()
-			}
-
-			// Still no match found, so return an empty string
-			return ""
+		exp, errs := parser.ParseInterpolation(part)
+		if len(errs) > 0 {
+			return NewError("ParseError: %s", errs[0])
 		}
-
-		return v.Inspect()
-	})
-
-	return str
+		val := Eval(exp, env)
+		if isError(val) {
+			return val
+		}
+		out.WriteString(val.Inspect())
+	}
+	return &object.String{Value: out.String()}
 }
 
 // NewError prints and returns an error
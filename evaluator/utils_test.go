@@ -34,15 +34,18 @@ func TestInterpolate(t *testing.T) {
 		{`xy\z`, `xy\z`},
 		{"{{string}}", "test"},
 		{"{{string}}_", "test_"},
-		{"{{string x", "{{string x"},
-		{"{{string} x", "{{string} x"},
+		{`<a href="{{string}}">`, `<a href="test">`},
+		{`{{ "{{string}}!" + "}" }}`, "test!}"},
+		{"{{string x", "ERROR: ParseError: page.html:1:1: unterminated interpolation"},
+		{"{{string} x", "ERROR: ParseError: page.html:1:1: unterminated interpolation"},
+		{"a\n{{ string + }}", "ERROR: ParseError: page.html:2:13: expected an expression before }}"},
 	}
 
 	env := object.NewEnvironment()
 	env.SetLet("string", &object.String{Value: "test"})
 
 	for _, tt := range tests {
-		output := Interpolate(tt.input, env)
+		output := Interpolate("page.html", tt.input, env).Inspect()
 		if tt.expected != output {
 			t.Fatalf(
 				"expected '%v', got '%v' (original: %s)",
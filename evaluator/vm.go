@@ -0,0 +1,434 @@
+package evaluator
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/zacanger/cozy/ast"
+	"github.com/zacanger/cozy/object"
+)
+
+// Backend is the way a runtime evaluates programs.
+type Backend int
+
+const (
+	// VM compiles programs to bytecode, and runs that. It's the
+	// default.
+	VM Backend = iota
+
+	// TreeWalker evaluates the syntax tree directly.
+	TreeWalker
+)
+
+// defaultBackend is the backend new runtimes start with.
+var defaultBackend = VM
+
+// SetBackend sets how the runtime evaluates programs.
+func (r *Runtime) SetBackend(b Backend) {
+	r.backend = b
+}
+
+// stepBatch is how many instructions the VM runs between checking its
+// context, unless it's counting every step towards Limits.MaxSteps.
+const stepBatch = 1024
+
+// raised stamps an error resulting from the instruction at an offset
+// with its position, like Eval does, and reports whether it was raised.
+func (u *unit) raised(obj OBJ, offset int) bool {
+	e, ok := obj.(*object.Error)
+	if !ok {
+		return false
+	}
+	if !e.Pos.IsValid() {
+		e.Pos = u.posAt(offset)
+	}
+	return !e.BuiltinCall
+}
+
+// runUnit runs compiled code in an environment, returning its value.
+// A raised error stops it, as does a return, or a break or continue for a
+// loop outside of it.
+func runUnit(u *unit, env *ENV) OBJ {
+	rt := runtimeOf(env)
+	if err := rt.step(env.Context); err != nil {
+		err.Pos = u.posAt(0)
+		return err
+	}
+	batch := stepBatch
+	if atomic.LoadInt64(&rt.stepLimit) > 0 {
+		batch = 1
+	}
+	steps := 0
+
+	var small [16]OBJ
+	stack := small[:]
+	if u.maxStack > len(small) {
+		stack = make([]OBJ, u.maxStack)
+	}
+	sp := 0
+
+	code := u.code
+	for ip := 0; ip < len(code); {
+		start := ip
+		op := Opcode(code[ip])
+		ip++
+
+		if steps++; steps == batch {
+			steps = 0
+			if err := rt.step(env.Context); err != nil {
+				err.Pos = u.posAt(start)
+				return err
+			}
+		}
+
+		switch op {
+		case OpConstant:
+			stack[sp] = u.constants[readOperand(code[ip:])]
+			sp++
+			ip += 2
+		case OpNull:
+			stack[sp] = NULL
+			sp++
+		case OpTrue:
+			stack[sp] = TRUE
+			sp++
+		case OpFalse:
+			stack[sp] = FALSE
+			sp++
+		case OpNil:
+			stack[sp] = nil
+			sp++
+		case OpPop:
+			sp--
+			stack[sp] = nil
+
+		case OpGetName:
+			name := u.names[readOperand(code[ip:])]
+			ip += 2
+			val := evalName(name, env)
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp] = val
+			sp++
+		case OpGetLocal:
+			val := env.Local(readOperand(code[ip:]), readOperand(code[ip+2:]))
+			if val == nil {
+				err := NewError("identifier not found: " + u.names[readOperand(code[ip+4:])])
+				err.Pos = u.posAt(start)
+				return err
+			}
+			ip += 6
+			stack[sp] = val
+			sp++
+		case OpSetLet:
+			env.SetLet(u.names[readOperand(code[ip:])], stack[sp-1])
+			ip += 2
+		case OpSetMutable:
+			env.Set(u.names[readOperand(code[ip:])], stack[sp-1])
+			ip += 2
+		case OpSetLocal:
+			object.NameFunction(u.names[readOperand(code[ip+4:])], stack[sp-1])
+			env.SetLocal(readOperand(code[ip:]), readOperand(code[ip+2:]), stack[sp-1])
+			ip += 6
+		case OpDestructure:
+			p := u.nodes[readOperand(code[ip:])].(*ast.Pattern)
+			readonly := readOperand(code[ip+2:]) == 1
+			ip += 4
+			if err := destructure(env, p, stack[sp-1], readonly); u.raised(err, start) {
+				return err
+			}
+		case OpAssign:
+			node := u.nodes[readOperand(code[ip:])].(*ast.AssignStatement)
+			ip += 2
+			val := assign(env, node.Name, node.Operator, stack[sp-1])
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp-1] = val
+		case OpPostfix:
+			node := u.nodes[readOperand(code[ip:])].(*ast.PostfixExpression)
+			ip += 2
+			val := evalPostfixExpression(env, node.Operator, node)
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp] = val
+			sp++
+
+		case OpPrefix:
+			val := evalPrefixExpression(u.names[readOperand(code[ip:])], stack[sp-1])
+			ip += 2
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp-1] = val
+		case OpInfix:
+			operator := u.names[readOperand(code[ip:])]
+			ip += 2
+			sp--
+			left, right := stack[sp-1], stack[sp]
+			stack[sp] = nil
+			if val, ok := fastIntegerInfix(operator, left, right); ok {
+				stack[sp-1] = val
+				continue
+			}
+			val := evalInfixExpression(operator, left, right, env)
+			if e, ok := val.(*object.Error); ok {
+				e.Pos = u.posAt(start)
+				if !e.BuiltinCall {
+					return e
+				}
+			}
+			stack[sp-1] = val
+		case OpIndex:
+			sp--
+			val := evalIndexExpression(stack[sp-1], stack[sp], env)
+			stack[sp] = nil
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp-1] = val
+
+		case OpArray:
+			n := readOperand(code[ip:])
+			ip += 2
+			var elements []OBJ
+			if n > 0 {
+				elements = make([]OBJ, n)
+				copy(elements, stack[sp-n:sp])
+			}
+			sp -= n
+			stack[sp] = &object.Array{Elements: elements}
+			sp++
+		case OpHash:
+			n := readOperand(code[ip:])
+			ip += 2
+			pairs := make(map[object.HashKey]object.HashPair, n)
+			for i := sp - 2*n; i < sp; i += 2 {
+				key, value := stack[i], stack[i+1]
+				hashKey, ok := key.(object.Hashable)
+				if !ok {
+					err := NewError("unusable as hash key: %s", key.Type())
+					err.Pos = u.posAt(start)
+					return err
+				}
+				pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+			}
+			sp -= 2 * n
+			stack[sp] = &object.Hash{Pairs: pairs}
+			sp++
+		case OpInterpolate:
+			n := readOperand(code[ip:])
+			ip += 2
+			var out strings.Builder
+			for _, val := range stack[sp-n : sp] {
+				out.WriteString(val.Inspect())
+			}
+			sp -= n
+			stack[sp] = &object.String{Value: out.String()}
+			sp++
+		case OpClosure:
+			f := u.funcs[readOperand(code[ip:])]
+			ip += 2
+			stack[sp] = &object.Function{
+				Parameters: f.literal.Parameters,
+				Env:        env,
+				Body:       f.literal.Body,
+				Defaults:   f.literal.Defaults,
+				Patterns:   f.literal.Patterns,
+				DocString:  f.literal.DocString,
+				Locals:     f.literal.Locals,
+				Compiled:   f.body,
+			}
+			sp++
+		case OpCall, OpTailCall:
+			n := readOperand(code[ip:])
+			ip += 2
+			var args []OBJ
+			if n > 0 {
+				args = make([]OBJ, n)
+				copy(args, stack[sp-n:sp])
+				// check for current args (...)
+				if first, ok := args[0].(*object.Array); ok && first.IsCurrentArgs {
+					args = append(env.CurrentArgs, args[1:]...)
+				}
+			}
+			sp -= n
+			if fn, ok := stack[sp-1].(*object.Function); ok && op == OpTailCall {
+				return &object.TailCall{Fn: fn, Args: args}
+			}
+			val := ApplyFunction(env, stack[sp-1], args)
+			calledFrom(val, u.posAt(start))
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp-1] = val
+		case OpEval:
+			val := Eval(u.nodes[readOperand(code[ip:])], env)
+			ip += 2
+			if u.raised(val, start) {
+				return val
+			}
+			stack[sp] = val
+			sp++
+
+		case OpJump:
+			ip = readOperand(code[ip:])
+		case OpJumpNotTruthy:
+			sp--
+			if isTruthy(stack[sp]) {
+				ip += 2
+			} else {
+				ip = readOperand(code[ip:])
+			}
+			stack[sp] = nil
+		case OpJumpOut:
+			sp = readOperand(code[ip:])
+			ip = readOperand(code[ip+2:])
+
+		case OpReturn:
+			if u.kind == blockUnit {
+				return &object.ReturnValue{Value: stack[sp-1]}
+			}
+			return stack[sp-1]
+		case OpComplete:
+			return u.constants[readOperand(code[ip:])]
+
+		case OpForeach:
+			iter, err := iterate(stack[sp-1], env)
+			if err != nil {
+				err.Pos = u.posAt(start)
+				return err
+			}
+			stack[sp-1] = iter
+		case OpIterNext:
+			iter := stack[sp-1].(*object.Iterator)
+			ret, idx, ok := iter.Next()
+			if !ok {
+				if err := iter.Err; err != nil {
+					err.Pos = u.posAt(start)
+					return err
+				}
+				ip = readOperand(code[ip:])
+				continue
+			}
+			env.SetLocal(0, readOperand(code[ip+2:]), ret)
+			if index := readOperand(code[ip+4:]); index != noOperand {
+				env.SetLocal(0, index, idx)
+			}
+			ip += 6
+		case OpForeachEnd:
+			stack[sp-1] = NULL
+
+		case OpTry:
+			t := u.tries[readOperand(code[ip:])]
+			ip += 2
+			var label string
+			var isBreak bool
+			switch val := runTry(t, env).(type) {
+			case *object.ReturnValue:
+				if u.kind == blockUnit {
+					return val
+				}
+				return val.Value
+			case *object.Break:
+				label, isBreak = val.Label, true
+			case *object.Continue:
+				label = val.Label
+			default:
+				if u.raised(val, start) {
+					return val
+				}
+				stack[sp] = val
+				sp++
+				continue
+			}
+
+			l := t.findLoop(label)
+			if l == nil {
+				if isBreak {
+					return &object.Break{Label: label}
+				}
+				return &object.Continue{Label: label}
+			}
+			sp = l.stack
+			if isBreak {
+				ip = l.brk
+			} else {
+				ip = l.cont
+			}
+		}
+	}
+
+	return stack[sp-1]
+}
+
+// runTry runs the blocks of a try expression, just like
+// evalTryExpression.
+func runTry(t *compiledTry, env *ENV) OBJ {
+	res := runUnit(t.block, env)
+
+	if isError(res) && t.catch != nil {
+		caught := *res.(*object.Error)
+		caught.BuiltinCall = true
+		caught.CaughtIn = env.Function
+
+		if t.catchIdent != nil {
+			setLocal(env, t.catchIdent.Local, t.catchIdent.Value, &caught)
+		}
+		res = runUnit(t.catch, env)
+	}
+
+	if t.finally != nil {
+		fin := runUnit(t.finally, env)
+		if fin != nil {
+			switch fin.Type() {
+			case object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return fin
+			}
+			if isError(fin) {
+				return fin
+			}
+		}
+	}
+
+	if res == nil {
+		return NULL
+	}
+	return res
+}
+
+// fastIntegerInfix applies the most common operators to two integers,
+// without going through evalInfixExpression.
+func fastIntegerInfix(operator string, left, right OBJ) (OBJ, bool) {
+	l, ok := left.(*object.Integer)
+	if !ok {
+		return nil, false
+	}
+	r, ok := right.(*object.Integer)
+	if !ok {
+		return nil, false
+	}
+	switch operator {
+	case "+":
+		return &object.Integer{Value: l.Value + r.Value}, true
+	case "-":
+		return &object.Integer{Value: l.Value - r.Value}, true
+	case "*":
+		return &object.Integer{Value: l.Value * r.Value}, true
+	case "<":
+		return nativeBoolToBooleanObject(l.Value < r.Value), true
+	case "<=":
+		return nativeBoolToBooleanObject(l.Value <= r.Value), true
+	case ">":
+		return nativeBoolToBooleanObject(l.Value > r.Value), true
+	case ">=":
+		return nativeBoolToBooleanObject(l.Value >= r.Value), true
+	case "==":
+		return nativeBoolToBooleanObject(l.Value == r.Value), true
+	case "!=":
+		return nativeBoolToBooleanObject(l.Value != r.Value), true
+	}
+	return nil, false
+}
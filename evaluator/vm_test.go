@@ -0,0 +1,117 @@
+package evaluator
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/parser"
+)
+
+// TestMain runs every test and benchmark in the package twice, once with
+// each backend, so the VM is held to the tree-walker's behaviour. Setting
+// COZY_TEST_BACKEND to "vm" or "tree-walker" runs them with just that one,
+// which is needed for -cpuprofile and friends.
+func TestMain(m *testing.M) {
+	backends := []struct {
+		name    string
+		backend Backend
+	}{{"vm", VM}, {"tree-walker", TreeWalker}}
+
+	// the tests evaluate snippets as the REPL does, so mutable variables
+	// are allowed at the top level.
+	defaultRuntime.SetRepl(true)
+
+	only := os.Getenv("COZY_TEST_BACKEND")
+	for _, b := range backends {
+		if only != "" && only != b.name {
+			continue
+		}
+		defaultBackend = b.backend
+		defaultRuntime.SetBackend(b.backend)
+		if code := m.Run(); code != 0 {
+			fmt.Printf("failed with the %s backend\n", b.name)
+			os.Exit(code)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestCompile(t *testing.T) {
+	program := parser.New(lexer.New("let x = 1 + y; print(x)")).ParseProgram()
+	u, err := compileProgram(program)
+	if err != nil {
+		t.Fatalf("compileProgram failed: %s", err)
+	}
+
+	expected := `0000 OpConstant 0
+0003 OpGetName 0
+0006 OpInfix 1
+0009 OpSetLet 2
+0012 OpPop
+0013 OpGetName 3
+0016 OpGetName 2
+0019 OpCall 1
+`
+	if got := disassemble(u.code); got != expected {
+		t.Errorf("wrong bytecode. expected=\n%s\ngot=\n%s", expected, got)
+	}
+	if u.maxStack != 2 {
+		t.Errorf("wrong maxStack. expected=2, got=%d", u.maxStack)
+	}
+}
+
+func TestLoopsAcrossTry(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let f = fn () {
+			mutable n = 0
+			foreach x in [1, 2, 3, 4] {
+				try { if x == 3 { break } } finally { n += x }
+			}
+			n
+		}; f()`, 6},
+		{`let f = fn () {
+			mutable n = 0
+			outer: foreach x in [1, 2, 3] {
+				foreach y in [1, 2, 3] {
+					try { if y == 2 { continue outer } } catch {}
+					n += x * y
+				}
+			}
+			n
+		}; f()`, 6},
+		{`let f = fn () {
+			foreach x in [1, 2, 3] {
+				try { panic(error("no")) } catch e { return x * 10 }
+			}
+		}; f()`, 10},
+		{`let f = fn () {
+			mutable n = 0
+			for n < 10 {
+				n += 1
+				try { try { if n == 4 { break } } finally { n += 0 } } catch {}
+			}
+			n
+		}; f()`, 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	program := parser.New(lexer.New(`
+		let fib = fn (n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }
+		fib(20)
+	`)).ParseProgram()
+
+	rt := NewRuntime()
+	for i := 0; i < b.N; i++ {
+		Eval(program, rt.NewEnvironment())
+	}
+}
@@ -0,0 +1,255 @@
+// Package interpreter lets Go programs embed cozy. Each Interpreter has its
+// own globals, Go functions, imported modules, timers, and http server, so
+// several can run side by side in the same process.
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/zacanger/cozy/ast"
+	"github.com/zacanger/cozy/evaluator"
+	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/object"
+	"github.com/zacanger/cozy/parser"
+	"github.com/zacanger/cozy/stdlib"
+)
+
+// Options configures a new Interpreter.
+type Options struct {
+	// NoStdlib skips loading the parts of the standard library written
+	// in cozy. The builtin modules written in Go are always available.
+	NoStdlib bool
+
+	// SearchPaths are directories to look for imported modules in,
+	// before the working directory (or $COZY_PATH).
+	SearchPaths []string
+
+	// Limits apply to each call to Run.
+	Limits evaluator.Limits
+
+	// Permissions sandbox the builtins which touch the filesystem,
+	// network, commands, and environment. With nil they can do anything.
+	Permissions *evaluator.Permissions
+
+	// Backend is how programs are evaluated; the bytecode VM by
+	// default.
+	Backend evaluator.Backend
+}
+
+// Interpreter is a single, isolated cozy instance. It's not safe to call
+// Run on the same Interpreter from more than one goroutine at once.
+type Interpreter struct {
+	runtime *evaluator.Runtime
+	env     *object.Environment
+	limits  evaluator.Limits
+}
+
+// ParseErrors is returned by Run when the source doesn't parse.
+type ParseErrors []*parser.ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ResolveErrors is returned by Run when the source parses, but has
+// mistakes which are found before it runs, like undefined variables.
+type ResolveErrors []*evaluator.ResolveError
+
+func (e ResolveErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// RuntimeError is returned by Run when the program raises an error which
+// nothing catches.
+type RuntimeError struct {
+	Err *object.Error
+}
+
+// Error returns the error with its stack trace.
+func (e *RuntimeError) Error() string {
+	return e.Err.Trace()
+}
+
+// Code returns the exit code the error asked for, or 1.
+func (e *RuntimeError) Code() int {
+	if e.Err.Code != nil {
+		return *e.Err.Code
+	}
+	return 1
+}
+
+// ExitError is returned by Run when the program calls sys.exit. It's up to
+// the caller whether the process exits.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exited with code %d", e.Code)
+}
+
+// New creates a new Interpreter.
+func New(opts Options) (*Interpreter, error) {
+	rt := evaluator.NewRuntime()
+	rt.SetPermissions(opts.Permissions)
+	rt.SetBackend(opts.Backend)
+	for _, p := range opts.SearchPaths {
+		if err := rt.AddSearchPath(p); err != nil {
+			return nil, err
+		}
+	}
+
+	i := &Interpreter{
+		runtime: rt,
+		env:     rt.NewEnvironment(),
+		limits:  opts.Limits,
+	}
+
+	if !opts.NoStdlib {
+		// Parse and evaluate the standard library one file at a
+		// time, so errors point at the right file.
+		for _, f := range stdlib.Files() {
+			p := parser.New(lexer.NewWithFile(f.Name, f.Source))
+			program := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				return nil, ParseErrors(p.Errors())
+			}
+			res := evaluator.Eval(program, i.env)
+			if e, ok := res.(*object.Error); ok && !e.BuiltinCall {
+				return nil, &RuntimeError{Err: e}
+			}
+		}
+	}
+
+	return i, nil
+}
+
+// Check parses and resolves source without running it, returning
+// ParseErrors or ResolveErrors for any mistakes. The filename is only used
+// when reporting errors.
+func (i *Interpreter) Check(source string, filename string) error {
+	_, err := i.parse(source, filename)
+	return err
+}
+
+// parse parses and resolves source.
+func (i *Interpreter) parse(source string, filename string) (*ast.Program, error) {
+	p := parser.New(lexer.NewWithFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, ParseErrors(p.Errors())
+	}
+	if errs := evaluator.Resolve(program, i.env); len(errs) != 0 {
+		return nil, ResolveErrors(errs)
+	}
+	return program, nil
+}
+
+// Run parses and evaluates source, returning the value of the last
+// statement. Like a script, it then waits for any timers, futures, and
+// servers the program left pending, and an error raised by one of their
+// callbacks is returned too. The filename is only used when reporting
+// errors. Cancelling ctx, or going over one of the Limits, stops the
+// program with a RuntimeError, and calling sys.exit stops it with an
+// ExitError.
+func (i *Interpreter) Run(
+	ctx context.Context,
+	source string,
+	filename string,
+) (object.Object, error) {
+	program, err := i.parse(source, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.limits.Timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	i.runtime.SetLimits(i.limits)
+	i.runtime.SetContext(ctx)
+	defer i.runtime.SetContext(context.Background())
+
+	res := evaluator.Eval(program, i.env)
+	if e, ok := res.(*object.Error); !ok || e.BuiltinCall {
+		if err := i.runtime.Wait(); err != nil {
+			res = err
+		}
+	}
+	// Anything still pending ends with the run.
+	cancel()
+	i.runtime.Stop()
+	if e, ok := res.(*object.Error); ok && !e.BuiltinCall {
+		if e.Exit {
+			return nil, &ExitError{Code: *e.Code}
+		}
+		return nil, &RuntimeError{Err: e}
+	}
+	return res, nil
+}
+
+// Get returns the value of a global.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// Set sets a global to a cozy object, or to any Go value which can be
+// converted to one (see evaluator.ToObject), including functions. Like any
+// top-level binding in cozy, it can't be modified by the program.
+func (i *Interpreter) Set(name string, val interface{}) error {
+	var obj object.Object
+	var err error
+	if reflect.ValueOf(val).Kind() == reflect.Func {
+		obj, err = evaluator.WrapFunc(name, val)
+	} else {
+		obj, err = evaluator.ToObject(val)
+	}
+	if err != nil {
+		return err
+	}
+	i.env.SetLet(name, obj)
+	return nil
+}
+
+// GetValue converts the value of a global to a Go value, storing it in the
+// value target points to (see evaluator.FromObject). Functions it's given
+// are called in this Interpreter.
+func (i *Interpreter) GetValue(name string, target interface{}) error {
+	obj, ok := i.env.Get(name)
+	if !ok {
+		return fmt.Errorf("%s is not defined", name)
+	}
+	return evaluator.FromObjectIn(i.env, obj, target)
+}
+
+// RegisterBuiltin registers a Go function on this Interpreter only. It's
+// called like any other global function. The params declare the arguments
+// it takes, which are checked before it's called; with none, it takes no
+// arguments.
+func (i *Interpreter) RegisterBuiltin(
+	name string,
+	fn object.BuiltinFunction,
+	params ...object.Param,
+) {
+	i.runtime.RegisterBuiltin(name, fn, params...)
+}
+
+// Env returns the global environment.
+func (i *Interpreter) Env() *object.Environment {
+	return i.env
+}
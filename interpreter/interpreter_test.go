@@ -0,0 +1,359 @@
+package interpreter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zacanger/cozy/evaluator"
+	"github.com/zacanger/cozy/object"
+)
+
+func newInterpreter(t *testing.T) *Interpreter {
+	t.Helper()
+	i, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New() failed: %s", err)
+	}
+	return i
+}
+
+func TestRun(t *testing.T) {
+	i := newInterpreter(t)
+	res, err := i.Run(context.Background(), "let x = [1, 2, 3].sum(); x * 2", "test.cz")
+	if err != nil {
+		t.Fatalf("Run() failed: %s", err)
+	}
+	if res.Inspect() != "12" {
+		t.Errorf("wrong result. got=%q", res.Inspect())
+	}
+
+	x, ok := i.Get("x")
+	if !ok || x.Inspect() != "6" {
+		t.Errorf("wrong value for x. got=%v", x)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	source := `
+		let words = fn (s) { s.split(" ").map(fn (w) { w.toupper() }) }
+		try { panic(error("oops")) } catch e { "caught {{e.message}}" }
+		words("hello cozy world").join("-")
+	`
+	for _, b := range []evaluator.Backend{evaluator.VM, evaluator.TreeWalker} {
+		i, err := New(Options{Backend: b})
+		if err != nil {
+			t.Fatalf("New() failed: %s", err)
+		}
+		res, err := i.Run(context.Background(), source, "backends.cz")
+		if err != nil {
+			t.Fatalf("backend %d: Run() failed: %s", b, err)
+		}
+		if res.Inspect() != "HELLO-COZY-WORLD" {
+			t.Errorf("backend %d: wrong result. got=%q", b, res.Inspect())
+		}
+	}
+}
+
+func TestGlobalsAreIsolated(t *testing.T) {
+	a := newInterpreter(t)
+	b := newInterpreter(t)
+
+	a.Set("name", &object.String{Value: "a"})
+	b.Set("name", &object.String{Value: "b"})
+
+	res, err := a.Run(context.Background(), "name", "a.cz")
+	if err != nil || res.Inspect() != "a" {
+		t.Errorf("wrong result from a. got=%v (%v)", res, err)
+	}
+	res, err = b.Run(context.Background(), "name", "b.cz")
+	if err != nil || res.Inspect() != "b" {
+		t.Errorf("wrong result from b. got=%v (%v)", res, err)
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	a := newInterpreter(t)
+	b := newInterpreter(t)
+
+	a.RegisterBuiltin("double",
+		func(env *object.Environment, args ...object.Object) object.Object {
+			n := args[0].(*object.Integer)
+			return &object.Integer{Value: n.Value * 2}
+		}, object.Arg("n", object.INTEGER_OBJ))
+
+	res, err := a.Run(context.Background(), "double(21)", "a.cz")
+	if err != nil || res.Inspect() != "42" {
+		t.Errorf("wrong result. got=%v (%v)", res, err)
+	}
+
+	// the other interpreter doesn't have it
+	_, err = b.Run(context.Background(), "double(21)", "b.cz")
+	if _, ok := err.(ResolveErrors); !ok {
+		t.Errorf("expected ResolveErrors. got=%T (%v)", err, err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	i := newInterpreter(t)
+
+	_, err := i.Run(context.Background(), "let = 1", "bad.cz")
+	if _, ok := err.(ParseErrors); !ok {
+		t.Errorf("expected ParseErrors. got=%T (%v)", err, err)
+	}
+
+	// nothing runs if there are undefined variables
+	_, err = i.Run(context.Background(), "panic(error(\"ran\")); x = y", "bad.cz")
+	errs, ok := err.(ResolveErrors)
+	if !ok {
+		t.Fatalf("expected ResolveErrors. got=%T (%v)", err, err)
+	}
+	expected := "bad.cz:1:22: setting unknown variable 'x' is an error\n" +
+		"bad.cz:1:26: identifier not found: y"
+	if errs.Error() != expected {
+		t.Errorf("wrong errors. expected=%q, got=%q", expected, errs.Error())
+	}
+
+	_, err = i.Run(
+		context.Background(),
+		`panic(error({"message": "oh no", "code": 3}))`,
+		"panic.cz",
+	)
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected a RuntimeError. got=%T (%v)", err, err)
+	}
+	if re.Err.Message != "oh no" || re.Code() != 3 {
+		t.Errorf("wrong error. got=%q (code %d)", re.Err.Message, re.Code())
+	}
+
+	// recursion is summed up in the stack trace
+	_, err = i.Run(context.Background(), `let f = fn (n) {
+    if (n == 0) { panic(error("bottom")) }
+    1 + f(n - 1)
+}
+f(3)`, "deep.cz")
+	expected = "Traceback (most recent call last):\n" +
+		"  deep.cz:5:1, in <main>\n" +
+		"  deep.cz:3:9, in f\n" +
+		"  [previous line repeated 2 more times]\n" +
+		"  deep.cz:2:19, in f\n" +
+		"ERROR: deep.cz:2:19: bottom"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong trace. expected=%q, got=%v", expected, err)
+	}
+}
+
+func TestRunExit(t *testing.T) {
+	i := newInterpreter(t)
+
+	_, err := i.Run(context.Background(), "let f = fn () { sys.exit(5) }; f()", "exit.cz")
+	exit, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("expected an ExitError. got=%T (%v)", err, err)
+	}
+	if exit.Code != 5 {
+		t.Errorf("wrong code. expected=5, got=%d", exit.Code)
+	}
+
+	// the next run isn't affected
+	res, err := i.Run(context.Background(), "1 + 1", "next.cz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Inspect() != "2" {
+		t.Errorf("wrong result. got=%s", res.Inspect())
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	i := newInterpreter(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := i.Run(ctx, "let f = fn () { for true { 1 } }; f()", "loop.cz")
+	if _, ok := err.(*RuntimeError); !ok {
+		t.Errorf("expected a RuntimeError. got=%T (%v)", err, err)
+	}
+}
+
+func TestEventLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`time.timeout(20, fn () { record("timeout") })`, "timeout"},
+		{`let t = time.timeout(20, fn () { record("timeout") })
+		time.cancel(t)
+		record("main")`, "main"},
+		{`let f = fn () {
+			mutable n = 0
+			let id = time.interval(5, fn () {
+				n++
+				if n == 3 {
+					time.cancel(id)
+					time.sleep(20)
+					record(n)
+				}
+			})
+		}
+		f()`, "3"},
+		{`let f = core.async(fn () { time.sleep(20); 1 })
+		core.background(fn () { record(core.await(f) + 1) })`, "2"},
+	}
+
+	for _, tt := range tests {
+		i := newInterpreter(t)
+		var got []string
+		i.RegisterBuiltin("record",
+			func(env *object.Environment, args ...object.Object) object.Object {
+				got = append(got, args[0].Inspect())
+				return args[0]
+			}, object.Arg("value"))
+
+		_, err := i.Run(context.Background(), tt.input, "loop.cz")
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != tt.expected {
+			t.Errorf("%q: wrong calls. expected=[%s], got=%v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEventLoopErrors(t *testing.T) {
+	i, err := New(Options{Limits: evaluator.Limits{Timeout: 200 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("New() failed: %s", err)
+	}
+
+	// an error which nothing catches ends the loop, stopping the
+	// interval
+	_, err = i.Run(context.Background(), `time.interval(5, fn () { 1 })
+	time.timeout(10, fn () { panic(error("boom")) })`, "fail.cz")
+	if e, ok := err.(*RuntimeError); !ok || e.Err.Message != "boom" {
+		t.Errorf("expected boom. got=%T (%v)", err, err)
+	}
+
+	_, err = i.Run(context.Background(), `time.interval(5, fn () { 1 })
+	time.timeout(10, fn () { sys.exit(4) })`, "exit.cz")
+	if e, ok := err.(*ExitError); !ok || e.Code != 4 {
+		t.Errorf("expected exit code 4. got=%T (%v)", err, err)
+	}
+
+	_, err = i.Run(context.Background(), "time.interval(5, fn () { 1 })", "forever.cz")
+	if e, ok := err.(*RuntimeError); !ok || e.Err.Message != "execution timed out" {
+		t.Errorf("expected a timeout. got=%T (%v)", err, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits   evaluator.Limits
+		input    string
+		expected string
+	}{
+		{evaluator.Limits{Timeout: 50 * time.Millisecond},
+			"for true { 1 }", "execution timed out"},
+		{evaluator.Limits{Timeout: 50 * time.Millisecond},
+			"time.sleep(60000)", "execution timed out"},
+		{evaluator.Limits{MaxSteps: 1000},
+			"let f = fn () { mutable i = 0; for true { i += 1 } }; f()",
+			"execution exceeded the limit of 1000 steps"},
+		{evaluator.Limits{MaxDepth: 50},
+			"let f = fn (n) { 1 + f(n + 1) }; f(0)",
+			"maximum call depth of 50 exceeded"},
+		{evaluator.Limits{},
+			"let f = fn (n) { 1 + f(n + 1) }; f(0)",
+			"maximum call depth of 10000 exceeded"},
+		// tail calls don't count towards the depth
+		{evaluator.Limits{MaxDepth: 50, MaxSteps: 10000},
+			"let f = fn (n) { f(n + 1) }; f(0)",
+			"execution exceeded the limit of 10000 steps"},
+	}
+
+	for _, tt := range tests {
+		i, err := New(Options{NoStdlib: true, Limits: tt.limits})
+		if err != nil {
+			t.Fatalf("New() failed: %s", err)
+		}
+		_, err = i.Run(context.Background(), tt.input, "limits.cz")
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("%s: expected a RuntimeError. got=%T (%v)",
+				tt.input, err, err)
+			continue
+		}
+		if rerr.Err.Message != tt.expected {
+			t.Errorf("%s: wrong error. expected=%q, got=%q",
+				tt.input, tt.expected, rerr.Err.Message)
+		}
+	}
+
+	// Limits apply to each run separately.
+	i, _ := New(Options{NoStdlib: true, Limits: evaluator.Limits{MaxSteps: 100}})
+	for n := 0; n < 3; n++ {
+		if _, err := i.Run(context.Background(), "1 + 2", "limits.cz"); err != nil {
+			t.Errorf("run %d failed: %s", n, err)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	i, err := New(Options{Permissions: &evaluator.Permissions{}})
+	if err != nil {
+		t.Fatalf("New() failed: %s", err)
+	}
+	_, err = i.Run(context.Background(), `sys.getenv("HOME")`, "env.cz")
+	if rerr, ok := err.(*RuntimeError); !ok ||
+		rerr.Err.Message != "PermissionError: environment access denied (--allow-env)" {
+		t.Errorf("expected a PermissionError. got=%v", err)
+	}
+}
+
+func TestGoValues(t *testing.T) {
+	type config struct {
+		Name  string `json:"name"`
+		Ports []int  `json:"ports"`
+	}
+
+	i := newInterpreter(t)
+	if err := i.Set("config", config{Name: "web", Ports: []int{80, 443}}); err != nil {
+		t.Fatalf("Set() failed: %s", err)
+	}
+	if err := i.Set("add", func(a, b int) int { return a + b }); err != nil {
+		t.Fatalf("Set() failed: %s", err)
+	}
+
+	_, err := i.Run(
+		context.Background(),
+		`let total = add(config.ports[0], config.ports[1])
+let out = {"name": config.name, "ports": [total]}`,
+		"values.cz",
+	)
+	if err != nil {
+		t.Fatalf("Run() failed: %s", err)
+	}
+
+	var total int
+	if err := i.GetValue("total", &total); err != nil || total != 523 {
+		t.Errorf("wrong total. got=%d (%v)", total, err)
+	}
+	var out config
+	if err := i.GetValue("out", &out); err != nil {
+		t.Fatalf("GetValue() failed: %s", err)
+	}
+	if out.Name != "web" || len(out.Ports) != 1 || out.Ports[0] != 523 {
+		t.Errorf("wrong out. got=%+v", out)
+	}
+	if err := i.GetValue("nope", &out); err == nil {
+		t.Errorf("expected an error for an undefined global")
+	}
+
+	_, err = i.Run(context.Background(), `add(1)`, "values.cz")
+	if err == nil || !strings.Contains(err.Error(), "add: wrong number of arguments") {
+		t.Errorf("expected an error naming add. got=%v", err)
+	}
+}
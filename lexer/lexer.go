@@ -3,8 +3,11 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/zacanger/cozy/token"
 )
@@ -25,39 +28,68 @@ type Lexer struct {
 
 	// Previous token.
 	prevToken token.Token
+
+	// file is the name of the source being lexed, if known.
+	file string
+
+	// line and column of the current character, both 1-based.
+	line   int
+	column int
+
+	// positions, if set, are where each character came from, for the
+	// expression in an interpolated string.
+	positions []token.Position
 }
 
 // New a Lexer instance from string input.
 func New(inputs ...string) *Lexer {
+	return NewWithFile("", inputs...)
+}
+
+// NewWithFile returns a Lexer whose tokens record the given file name
+// in their positions.
+func NewWithFile(file string, inputs ...string) *Lexer {
 	input := ""
 	for _, inp := range inputs {
 		input += inp
 		input += "\n\n"
 	}
-	l := &Lexer{characters: []rune(input)}
+	l := &Lexer{characters: []rune(input), file: file, line: 1}
 	l.readChar()
 	return l
 }
 
-// GetLine returns the rough line-number of our current position.
+// NewInterpolation returns a Lexer for the expression in part of an
+// interpolated string, whose tokens have the positions they have in the
+// string.
+func NewInterpolation(part token.Part) *Lexer {
+	l := &Lexer{characters: part.Source, positions: part.Positions, line: 1}
+	l.readChar()
+	return l
+}
+
+// GetLine returns the line-number of our current position.
 func (l *Lexer) GetLine() int {
-	line := 0
-	chars := len(l.characters)
-	i := 0
+	return l.line
+}
 
-	for i < l.readPosition && i < chars {
-		if l.characters[i] == rune('\n') {
-			line++
+// pos returns the position of the current character.
+func (l *Lexer) pos() token.Position {
+	if l.positions != nil {
+		if l.position < len(l.positions) {
+			return l.positions[l.position]
 		}
-
-		i++
+		return l.positions[len(l.positions)-1]
 	}
-
-	return line
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
 }
 
 // read one forward character
 func (l *Lexer) readChar() {
+	if l.ch == rune('\n') {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.characters) {
 		l.ch = rune(0)
 	} else {
@@ -65,6 +97,7 @@ func (l *Lexer) readChar() {
 	}
 	l.position = l.readPosition
 	l.readPosition++
+	l.column++
 }
 
 // NextToken to read next token, skipping the white space.
@@ -73,9 +106,19 @@ func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
 
 	// skip comments
-	if l.ch == rune('#') {
+	for l.ch == rune('#') {
 		l.skipComment()
-		return l.NextToken()
+	}
+
+	pos := l.pos()
+
+	// Numbers can start with a dot, like .5, unless the dot comes right
+	// after something it could be getting a member of.
+	if isDigit(l.ch) || l.ch == rune('.') && isDigit(l.peekChar()) && !l.afterValue() {
+		tok = l.readNumber()
+		tok.Pos = pos
+		l.prevToken = tok
+		return tok
 	}
 
 	switch l.ch {
@@ -294,11 +337,18 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case rune('"'):
-		tok.Type = token.STRING
-		tok.Literal = l.readString(false)
+		if l.peekChar() == '"' && l.peekCharAt(2) == '"' {
+			tok = l.readHeredoc()
+		} else {
+			tok = l.readString('"', true)
+		}
 	case rune('\''):
-		tok.Type = token.DOCSTRING
-		tok.Literal = l.readString(true)
+		tok = l.readString('\'', false)
+		if tok.Type == token.STRING {
+			tok.Type = token.DOCSTRING
+		}
+	case rune('`'):
+		tok = l.readRawString()
 	case rune('['):
 		tok = newToken(token.LBRACKET, l.ch)
 	case rune(']'):
@@ -309,20 +359,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
-		if isDigit(l.ch) {
-			tok = l.readDecimal()
-			l.prevToken = tok
-			return tok
-
-		}
 		tok.Literal = l.readIdentifier()
 		tok.Type = token.LookupIdentifier(tok.Literal)
+		tok.Pos = pos
 		l.prevToken = tok
 
 		return tok
 	}
 
 	l.readChar()
+	if !tok.Pos.IsValid() {
+		tok.Pos = pos
+	}
 	l.prevToken = tok
 	return tok
 }
@@ -332,94 +380,16 @@ func newToken(tokenType token.Type, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
-// readIdentifier is designed to read an identifier (name of variable,
-// function, etc).
-//
-// However there is a complication due to our historical implementation
-// of the standard library. We really want to stop identifiers if we hit
-// a period, to allow method-calls to work on objects.
-//
-// So with input like this:
-//
-//	a.blah();
-//
-// Our identifier should be "a" (then we have a period, then a second
-// identifier "blah", followed by opening & closing parenthesis).
-//
-// However we also have to cover the case of:
-//
-//	string.toupper("blah");
-//	os.getenv("PATH");
-//	etc.
+// readIdentifier reads an identifier: the name of a variable, function,
+// etc. It stops at a period, so a.b.c is three identifiers, which the
+// parser puts back together; that's how stdlib names like fs.glob work
+// too.
 func (l *Lexer) readIdentifier() string {
-	// Types and objects which will have valid methods.
-	types := []string{
-		"array.",
-		"core.",
-		"float.",
-		"fs.",
-		"hash.",
-		"http.",
-		"integer.",
-		"json.",
-		"math.",
-		"net.",
-		"object.",
-		"string.",
-		"sys.",
-		"time.",
-		"util.",
-	}
-
 	id := ""
-
-	// Save our position, in case we need to jump backwards in
-	// our scanning.
-	position := l.position
-	rposition := l.readPosition
-
-	// Build up our identifier, handling only valid characters.
-	// NOTE: This WILL consider the period valid, allowing the
-	// parsing of "foo.bar", "os.getenv", "blah.blah.blah", etc.
 	for isIdentifier(l.ch) {
 		id += string(l.ch)
 		l.readChar()
 	}
-
-	// Now we to see if our identifier had a period inside it.
-	if strings.Contains(id, ".") {
-		ok := false
-		for _, i := range types {
-			if strings.HasPrefix(id, i) {
-				ok = true
-			}
-		}
-
-		// Not permitted? Then we abort.
-		// We reset our lexer-state to the position just ahead
-		// of the period. This will then lead to a syntax
-		// error.
-		// Which probably means our lexer should abort instead.
-		// For the moment we'll leave as-is.
-		if !ok {
-			// OK first of all we truncate our identifier
-			// at the position before the "."
-			offset := strings.Index(id, ".")
-			id = id[:offset]
-
-			// Now we have to move backwards - as a quickie
-			// We'll reset our position and move forwards
-			// the length of the bits we went too-far.
-			l.position = position
-			l.readPosition = rposition
-			for offset > 0 {
-				l.readChar()
-				offset--
-			}
-		}
-	}
-
-	// And now our pain is over.
 	return id
 }
 
@@ -438,110 +408,489 @@ func (l *Lexer) skipComment() {
 	l.skipWhitespace()
 }
 
-// read number - this handles 0x1234 and 0b101010101 too.
-func (l *Lexer) readNumber() string {
-	str := ""
+// readNumber reads an integer, like 10, 1_000, 0xff, 0o755, or 0b1010,
+// or a float, like 1.5, .5, or 1.5e-3. What follows 0x, 0o, or 0b is
+// read up to the next character which can't be in a name, so the parser
+// can say what's wrong with a bad digit.
+//
+// A dot followed by something other than a digit isn't part of the
+// number, so 3.foo() is a method-call on a raw number.
+func (l *Lexer) readNumber() token.Token {
+	start := l.position
+	if l.ch == '0' && strings.ContainsRune("xXoObB", l.peekChar()) {
+		l.readChar()
+		l.readChar()
+		for isDigit(l.ch) || unicode.IsLetter(l.ch) || l.ch == '_' {
+			l.readChar()
+		}
+		return token.Token{Type: token.INT, Literal: string(l.characters[start:l.position])}
+	}
 
-	// We usually just accept digits.
-	accept := "0123456789"
+	var tokenType token.Type = token.INT
+	l.readDigits()
+	if l.ch == rune('.') && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if next == '+' || next == '-' {
+			next = l.peekCharAt(2)
+		}
+		if isDigit(next) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return token.Token{Type: tokenType, Literal: string(l.characters[start:l.position])}
+}
 
-	// But if we have `0x` as a prefix we accept hexadecimal instead.
-	if l.ch == '0' && l.peekChar() == 'x' {
-		accept = "0x123456789abcdefABCDEF"
+// readDigits reads decimal digits, and the underscores between them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.readChar()
 	}
+}
 
-	// If we have `0b` as a prefix we accept binary digits only.
-	if l.ch == '0' && l.peekChar() == 'b' {
-		accept = "b01"
+// afterValue reports whether the current character comes right after a
+// token which ends a value, which a dot there would be getting a member
+// of.
+func (l *Lexer) afterValue() bool {
+	if l.position > 0 && isWhitespace(l.characters[l.position-1]) {
+		return false
+	}
+	switch l.prevToken.Type {
+	case token.IDENT, token.INT, token.FLOAT, token.STRING, token.INTERPOLATED,
+		token.RPAREN, token.RBRACKET, token.RBRACE:
+		return true
 	}
+	return false
+}
 
-	for strings.Contains(accept, string(l.ch)) {
-		str += string(l.ch)
+// readString reads a string, starting on the character before it and
+// leaving the lexer on delim, which ends it; a delim of 0 reads the rest
+// of the input. If interpolate is set, a string with {{ }} interpolations
+// in it is split into the text between them and their expressions, as an
+// INTERPOLATED token.
+func (l *Lexer) readString(delim rune, interpolate bool) token.Token {
+	start := l.position + 1
+	out := ""
+	var parts []token.Part
+	var bad *token.Token
+	for {
 		l.readChar()
+		if l.ch == delim {
+			break
+		}
+		if l.ch == rune(0) {
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated string"}
+		}
+
+		if l.ch == '\\' {
+			pos := l.pos()
+			text, err := l.readEscape()
+			if err != "" && bad == nil {
+				// The rest of the string is still read, so it isn't
+				// lexed as code.
+				bad = &token.Token{Type: token.ILLEGAL, Literal: err, Pos: pos}
+			}
+			out = out + text
+			continue
+		}
+
+		if interpolate && l.ch == '{' && l.peekChar() == '{' {
+			pos := l.pos()
+			part, ok := l.readInterpolation(delim)
+			if !ok {
+				return token.Token{
+					Type:    token.ILLEGAL,
+					Literal: "unterminated interpolation",
+					Pos:     pos,
+				}
+			}
+			parts = append(parts, token.Part{Text: out}, part)
+			out = ""
+			continue
+		}
+
+		out = out + string(l.ch)
+	}
+
+	switch {
+	case bad != nil:
+		return *bad
+	case parts == nil:
+		return token.Token{Type: token.STRING, Literal: out}
+	}
+	return token.Token{
+		Type:    token.INTERPOLATED,
+		Literal: string(l.characters[start:l.position]),
+		Parts:   append(parts, token.Part{Text: out}),
 	}
-	return str
 }
 
-// read decimal
-func (l *Lexer) readDecimal() token.Token {
-	// Read an integer-number.
-	integer := l.readNumber()
+// escapes are the characters which stand for themselves, or another
+// character, after a backslash.
+var escapes = map[rune]string{
+	'a':  "\a",
+	'b':  "\b",
+	'e':  "\x1b",
+	'f':  "\f",
+	'n':  "\n",
+	'r':  "\r",
+	't':  "\t",
+	'v':  "\v",
+	'0':  "\x00",
+	'\\': "\\",
+	'"':  "\"",
+	'\'': "'",
+	'{':  "{",
+}
 
-	// Now we either expect:
-	//   .[digits]  -> Which converts us from an int to a float.
-	//   .blah      -> Which is a method-call on a raw number.
-	if l.ch == rune('.') && isDigit(l.peekChar()) {
-		// OK here we think we've got a float.
+// readEscape reads the escape sequence starting on a backslash, leaving
+// the lexer on its last character. It returns the text it stands for,
+// or what's wrong with it.
+func (l *Lexer) readEscape() (string, string) {
+	l.readChar()
+	if text, ok := escapes[l.ch]; ok {
+		return text, ""
+	}
+
+	switch l.ch {
+	case rune(0):
+		// The string is unterminated, which is found next.
+		return "", ""
+	case 'x':
+		digits := ""
+		for len(digits) < 2 && isHexDigit(l.peekChar()) {
+			l.readChar()
+			digits += string(l.ch)
+		}
+		if len(digits) < 2 {
+			return "", "\\x must be followed by two hex digits"
+		}
+		n, _ := strconv.ParseUint(digits, 16, 8)
+		return string(rune(n)), ""
+	case 'u':
+		if l.peekChar() != '{' {
+			return "", "\\u must be followed by hex digits in braces, like \\u{e9}"
+		}
 		l.readChar()
-		fraction := l.readNumber()
-		return token.Token{Type: token.FLOAT, Literal: integer + "." + fraction}
+		digits := ""
+		for isHexDigit(l.peekChar()) {
+			l.readChar()
+			digits += string(l.ch)
+		}
+		if l.peekChar() != '}' || digits == "" || len(digits) > 6 {
+			return "", "\\u must be followed by hex digits in braces, like \\u{e9}"
+		}
+		l.readChar()
+		n, _ := strconv.ParseUint(digits, 16, 32)
+		if !utf8.ValidRune(rune(n)) {
+			return "", fmt.Sprintf("\\u{%s} isn't a valid character", digits)
+		}
+		return string(rune(n)), ""
 	}
-	return token.Token{Type: token.INT, Literal: integer}
+	return string(l.ch), fmt.Sprintf("unknown escape sequence \\%c", l.ch)
 }
 
-// read strings and docstrings
-func (l *Lexer) readString(isDocString bool) string {
-	out := ""
-	delim := '"'
-	if isDocString {
-		delim = '\''
+// readRawString reads a string between backticks, which has no escapes
+// or interpolations, leaving the lexer on the closing backtick.
+func (l *Lexer) readRawString() token.Token {
+	start := l.position + 1
+	for {
+		l.readChar()
+		switch l.ch {
+		case '`':
+			return token.Token{
+				Type:    token.STRING,
+				Literal: string(l.characters[start:l.position]),
+			}
+		case rune(0):
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated raw string"}
+		}
 	}
+}
 
+// readHeredoc reads a string between triple quotes, leaving the lexer on
+// the last of the closing ones. Quotes in it don't need escaping, even
+// in its interpolations. If it starts with a line break, that's left
+// out, as is the line the closing quotes are on if they're all there is
+// on it, and the indentation the rest of its lines have in common is
+// taken off of them.
+func (l *Lexer) readHeredoc() token.Token {
+	l.readChar()
+	l.readChar()
+
+	var src []rune
+	var positions []token.Position
 	for {
 		l.readChar()
-		if l.ch == delim {
+		if l.ch == rune(0) {
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated heredoc"}
+		}
+		if l.ch == '"' && l.peekChar() == '"' && l.peekCharAt(2) == '"' {
 			break
 		}
-
-		// Handle \n, \r, \t, \", etc.
 		if l.ch == '\\' {
+			src = append(src, l.ch)
+			positions = append(positions, l.pos())
 			l.readChar()
+		}
+		src = append(src, l.ch)
+		positions = append(positions, l.pos())
+	}
+	end := l.pos()
+	l.readChar()
+	l.readChar()
 
-			// escaped string delimiters
-			if isDocString {
-				if l.ch == rune('\'') {
-					l.ch = '\''
-				}
-			} else {
-				if l.ch == rune('"') {
-					l.ch = '"'
-				}
+	// What's left is read like any other string, which starts on the
+	// character before it.
+	src, positions = dedent(src, positions)
+	sub := &Lexer{characters: src, positions: append(positions, end), position: -1}
+	return sub.readString(0, true)
+}
+
+// dedent takes the first and last lines off of the source of a heredoc,
+// if they're blank, and the indentation its lines have in common, along
+// with the positions of what it takes off. Blank lines are left empty.
+func dedent(src []rune, positions []token.Position) ([]rune, []token.Position) {
+	type line struct {
+		text      []rune
+		positions []token.Position
+
+		// end is where the line break after the line is.
+		end token.Position
+	}
+	var lines []line
+	from := 0
+	for i := 0; i <= len(src); i++ {
+		if i == len(src) || src[i] == '\n' {
+			l := line{text: src[from:i], positions: positions[from:i]}
+			if i < len(src) {
+				l.end = positions[i]
 			}
+			lines = append(lines, l)
+			from = i + 1
+		}
+	}
+
+	blank := func(l line) bool {
+		return strings.TrimLeft(string(l.text), " \t") == ""
+	}
+	if len(lines) > 1 && blank(lines[0]) {
+		lines = lines[1:]
+	}
+	if len(lines) > 1 && blank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
 
-			// other escapes
-			if l.ch == rune('n') {
-				l.ch = '\n'
+	var indent []rune
+	first := true
+	for _, l := range lines {
+		if blank(l) {
+			continue
+		}
+		n := 0
+		for n < len(l.text) && (l.text[n] == ' ' || l.text[n] == '\t') {
+			n++
+		}
+		if first {
+			indent, first = l.text[:n], false
+			continue
+		}
+		common := 0
+		for common < len(indent) && common < n && indent[common] == l.text[common] {
+			common++
+		}
+		indent = indent[:common]
+	}
+
+	var out []rune
+	var outPositions []token.Position
+	for i, l := range lines {
+		if i > 0 {
+			out = append(out, '\n')
+			outPositions = append(outPositions, lines[i-1].end)
+		}
+		if !blank(l) {
+			out = append(out, l.text[len(indent):]...)
+			outPositions = append(outPositions, l.positions[len(indent):]...)
+		}
+	}
+	return out, outPositions
+}
+
+// readInterpolation reads the expression between {{ and }}, starting on
+// the first {, and leaving the lexer on the last }. It reports whether the
+// end was found.
+func (l *Lexer) readInterpolation(delim rune) (token.Part, bool) {
+	part := token.Part{IsExpr: true}
+	l.readChar()
+	end, ok := l.readExpression(&part, delim)
+	if ok {
+		part.Positions = append(part.Positions, end)
+	}
+	return part, ok
+}
+
+// readExpression reads the expression of an interpolation into part,
+// starting on the second { of its {{, and leaving the lexer on the last
+// } of its }}, returning where the }} is. In a string ending with delim,
+// the expression's quotes and backslashes may be escaped, so they're
+// unescaped here; in a template or heredoc, whose delim is 0, they
+// aren't. Braces in the expression's strings don't count towards finding
+// its end. It reports whether the end was found.
+func (l *Lexer) readExpression(part *token.Part, delim rune) (token.Position, bool) {
+	depth := 0
+	quote, escaped := rune(0), false
+	for {
+		l.readChar()
+		pos := l.pos()
+		unescaped := false
+		switch {
+		case l.ch == rune(0):
+			return pos, false
+		case delim != 0 && l.ch == '\\':
+			l.readChar()
+			if l.ch == rune(0) {
+				return pos, false
+			}
+			// Escapes other than these belong to a string in the
+			// expression, so they're left for it.
+			if l.ch != delim && l.ch != '\\' {
+				part.Source = append(part.Source, '\\')
+				part.Positions = append(part.Positions, pos)
+				escaped = quote == '"' && !escaped
 			}
-			if l.ch == rune('r') {
-				l.ch = '\r'
+			unescaped = true
+		}
+
+		switch {
+		case quote == '`':
+			if l.ch == '`' {
+				quote = 0
 			}
-			if l.ch == rune('t') {
-				l.ch = '\t'
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case l.ch == '\\':
+				escaped = true
+			case l.ch == '"':
+				quote = 0
 			}
-			if l.ch == rune('\\') {
-				l.ch = '\\'
+		case l.ch == '"' && !unescaped:
+			// A string whose quotes aren't escaped is read as it is,
+			// with any interpolations of its own.
+			if !l.readNestedString(part) {
+				return pos, false
 			}
+			continue
+		case l.ch == '"', l.ch == '`':
+			quote = l.ch
+		case l.ch == '{':
+			depth++
+		case l.ch == '}' && depth > 0:
+			depth--
+		case l.ch == '}' && l.peekChar() == '}':
+			l.readChar()
+			return pos, true
 		}
+		part.Source = append(part.Source, l.ch)
+		part.Positions = append(part.Positions, pos)
+	}
+}
 
-		out = out + string(l.ch)
+// readNestedString reads a string in an interpolation's expression,
+// starting on its opening quote, and leaving the lexer on its closing
+// one. It's added to part as it is, escapes and all, along with any
+// interpolations in it. It reports whether the end was found.
+func (l *Lexer) readNestedString(part *token.Part) bool {
+	add := func() {
+		part.Source = append(part.Source, l.ch)
+		part.Positions = append(part.Positions, l.pos())
+	}
+	add()
+	for {
+		l.readChar()
+		switch {
+		case l.ch == rune(0):
+			return false
+		case l.ch == '\\':
+			add()
+			l.readChar()
+			if l.ch == rune(0) {
+				return false
+			}
+		case l.ch == '{' && l.peekChar() == '{':
+			add()
+			l.readChar()
+			add()
+			end, ok := l.readExpression(part, 0)
+			if !ok {
+				return false
+			}
+			part.Source = append(part.Source, '}', '}')
+			part.Positions = append(part.Positions, end, l.pos())
+			continue
+		case l.ch == '"':
+			add()
+			return true
+		}
+		add()
 	}
+}
 
-	return out
+// Template splits the text of a template into parts like an interpolated
+// string's. There are no escapes in a template but \{, for a literal {.
+func Template(file string, text string) ([]token.Part, error) {
+	l := &Lexer{characters: []rune(text), file: file, line: 1}
+	l.readChar()
+
+	var parts []token.Part
+	out := ""
+	for ; l.ch != rune(0); l.readChar() {
+		switch {
+		case l.ch == '\\' && l.peekChar() == '{':
+			l.readChar()
+		case l.ch == '{' && l.peekChar() == '{':
+			pos := l.pos()
+			part, ok := l.readInterpolation(0)
+			if !ok {
+				return nil, fmt.Errorf("%s: unterminated interpolation", pos)
+			}
+			parts = append(parts, token.Part{Text: out}, part)
+			out = ""
+			continue
+		}
+		out = out + string(l.ch)
+	}
+	return append(parts, token.Part{Text: out}), nil
 }
 
 // peek character
 func (l *Lexer) peekChar() rune {
-	if l.readPosition >= len(l.characters) {
+	return l.peekCharAt(1)
+}
+
+// peekCharAt returns the character n ahead of the current one.
+func (l *Lexer) peekCharAt(n int) rune {
+	if l.readPosition+n-1 >= len(l.characters) {
 		return rune(0)
 	}
-	return l.characters[l.readPosition]
+	return l.characters[l.readPosition+n-1]
 }
 
 // determinate ch is identifier or not
 func isIdentifier(ch rune) bool {
 	return unicode.IsLetter(ch) ||
 		unicode.IsDigit(ch) ||
-		ch == '.' ||
 		ch == '?' ||
 		ch == '$' ||
 		ch == '_'
@@ -559,3 +908,10 @@ func isWhitespace(ch rune) bool {
 func isDigit(ch rune) bool {
 	return rune('0') <= ch && ch <= rune('9')
 }
+
+// is hex Digit
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) ||
+		rune('a') <= ch && ch <= rune('f') ||
+		rune('A') <= ch && ch <= rune('F')
+}
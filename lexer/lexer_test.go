@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/zacanger/cozy/token"
@@ -367,6 +368,51 @@ func TestIntegers(t *testing.T) {
 	}
 }
 
+func TestNumbers(t *testing.T) {
+	input := `1_000 0o755 0B11 0xdead_BEEF 1.5e-3 2E10 1e+2 .5 (.25) 1..5 x.5 f().5 0b102 1e 1_`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.INT, "1_000"},
+		{token.INT, "0o755"},
+		{token.INT, "0B11"},
+		{token.INT, "0xdead_BEEF"},
+		{token.FLOAT, "1.5e-3"},
+		{token.FLOAT, "2E10"},
+		{token.FLOAT, "1e+2"},
+		{token.FLOAT, ".5"},
+		{token.LPAREN, "("},
+		{token.FLOAT, ".25"},
+		{token.RPAREN, ")"},
+		{token.INT, "1"},
+		{token.RANGE, ".."},
+		{token.INT, "5"},
+		{token.IDENT, "x"},
+		{token.PERIOD, "."},
+		{token.INT, "5"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.PERIOD, "."},
+		{token.INT, "5"},
+		{token.INT, "0b102"},
+		{token.INT, "1"},
+		{token.IDENT, "e"},
+		{token.INT, "1_"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token, expected=%s %q, got=%s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
 // Test that the shebang-line is handled specially.
 func TestShebang(t *testing.T) {
 	input := `#!/bin/cozy
@@ -448,22 +494,13 @@ let c = 3.113;
 	}
 }
 
-// TestStdLib ensures that identifiers are parsed correctly
+// TestStdLib ensures that dotted names, like those in the standard
+// library, are lexed as identifiers separated by periods
 func TestStdLib(t *testing.T) {
 	input := `
 sys.getenv
-sys.setenv
-sys.environment
 fs.glob
-math.abs
-math.random
-math.sqrt
-string.interpolate
-string.toupper
-string.tolower
-string.trim
-string.reverse
-string.split
+http.constants.status
 foo.bar
 `
 
@@ -471,19 +508,17 @@ foo.bar
 		expectedType    token.Type
 		expectedLiteral string
 	}{
-		{token.IDENT, "sys.getenv"},
-		{token.IDENT, "sys.setenv"},
-		{token.IDENT, "sys.environment"},
-		{token.IDENT, "fs.glob"},
-		{token.IDENT, "math.abs"},
-		{token.IDENT, "math.random"},
-		{token.IDENT, "math.sqrt"},
-		{token.IDENT, "string.interpolate"},
-		{token.IDENT, "string.toupper"},
-		{token.IDENT, "string.tolower"},
-		{token.IDENT, "string.trim"},
-		{token.IDENT, "string.reverse"},
-		{token.IDENT, "string.split"},
+		{token.IDENT, "sys"},
+		{token.PERIOD, "."},
+		{token.IDENT, "getenv"},
+		{token.IDENT, "fs"},
+		{token.PERIOD, "."},
+		{token.IDENT, "glob"},
+		{token.IDENT, "http"},
+		{token.PERIOD, "."},
+		{token.IDENT, "constants"},
+		{token.PERIOD, "."},
+		{token.IDENT, "status"},
 		{token.IDENT, "foo"},
 		{token.PERIOD, "."},
 		{token.IDENT, "bar"},
@@ -504,8 +539,8 @@ foo.bar
 			t.Fatalf(
 				"tests[%d] - Literal wrong, expected=%q, got=%q",
 				i,
-				tt,
-				tok,
+				tt.expectedLiteral,
+				tok.Literal,
 			)
 		}
 	}
@@ -552,8 +587,8 @@ a?.b?();
 			t.Fatalf(
 				"tests[%d] - Literal wrong, expected=%q, got=%q",
 				i,
-				tt,
-				tok,
+				tt.expectedLiteral,
+				tok.Literal,
 			)
 		}
 	}
@@ -600,8 +635,8 @@ func TestIntDotMethod(t *testing.T) {
 			t.Fatalf(
 				"tests[%d] - Literal wrong, expected=%q, got=%q",
 				i,
-				tt,
-				tok,
+				tt.expectedLiteral,
+				tok.Literal,
 			)
 		}
 	}
@@ -690,3 +725,256 @@ func TestRangeDots(t *testing.T) {
 		}
 	}
 }
+
+func TestPositions(t *testing.T) {
+	input := `let x = 1;
+  # comment
+  x + "two"`
+
+	tests := []struct {
+		expectedType   token.Type
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 3, 3},
+		{token.PLUS, 3, 5},
+		{token.STRING, 3, 7},
+	}
+	l := NewWithFile("main.cz", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.File != "main.cz" {
+			t.Fatalf("tests[%d] - file wrong, got=%q", i, tok.Pos.File)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong, expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	input := `"a {{x}} b {{ \"{{y}}\" + \"}\" }}"`
+	tok := NewWithFile("main.cz", input).NextToken()
+	if tok.Type != token.INTERPOLATED {
+		t.Fatalf("tokentype wrong, expected=%q, got=%q", token.INTERPOLATED, tok.Type)
+	}
+	if tok.Literal != input[1:len(input)-1] {
+		t.Fatalf("literal wrong, got=%q", tok.Literal)
+	}
+
+	expected := []struct {
+		text   string
+		source string
+		column int
+	}{
+		{"a ", "", 0},
+		{"", "x", 6},
+		{" b ", "", 0},
+		{"", ` "{{y}}" + "}" `, 14},
+		{"", "", 0},
+	}
+	if len(tok.Parts) != len(expected) {
+		t.Fatalf("wrong number of parts, expected=%d, got=%d", len(expected), len(tok.Parts))
+	}
+	for i, tt := range expected {
+		part := tok.Parts[i]
+		if part.IsExpr != (tt.source != "") {
+			t.Fatalf("parts[%d] - IsExpr wrong, got=%t", i, part.IsExpr)
+		}
+		if part.Text != tt.text || string(part.Source) != tt.source {
+			t.Fatalf("parts[%d] - wrong, expected=%q%q, got=%q%q",
+				i, tt.text, tt.source, part.Text, string(part.Source))
+		}
+		if !part.IsExpr {
+			continue
+		}
+		if len(part.Positions) != len(part.Source)+1 {
+			t.Fatalf("parts[%d] - wrong number of positions, got=%d", i, len(part.Positions))
+		}
+		if pos := part.Positions[0]; pos.String() != fmt.Sprintf("main.cz:1:%d", tt.column) {
+			t.Fatalf("parts[%d] - position wrong, got=%s", i, pos)
+		}
+	}
+
+	// The nested string is lexed where it is, with its own parts.
+	l := NewInterpolation(tok.Parts[3])
+	nested := l.NextToken()
+	if nested.Type != token.INTERPOLATED || nested.Pos.Column != 15 {
+		t.Fatalf("nested string wrong, got=%s at %s", nested.Type, nested.Pos)
+	}
+	if y := nested.Parts[1].Positions[0]; y.Column != 19 {
+		t.Fatalf("nested interpolation position wrong, got=%s", y)
+	}
+}
+
+func TestNestedStrings(t *testing.T) {
+	// The strings in an interpolation needn't have their quotes escaped,
+	// and they can have interpolations of their own.
+	input := `"a {{ f("}}") }} b {{ "in {{ g("x") }}" }}"`
+	tok := NewWithFile("main.cz", input).NextToken()
+	if tok.Type != token.INTERPOLATED {
+		t.Fatalf("tokentype wrong, expected=%q, got=%q (%s)", token.INTERPOLATED, tok.Type, tok.Literal)
+	}
+
+	expected := []struct {
+		text   string
+		source string
+	}{
+		{"a ", ""},
+		{"", ` f("}}") `},
+		{" b ", ""},
+		{"", ` "in {{ g("x") }}" `},
+		{"", ""},
+	}
+	if len(tok.Parts) != len(expected) {
+		t.Fatalf("wrong number of parts, expected=%d, got=%d", len(expected), len(tok.Parts))
+	}
+	for i, tt := range expected {
+		part := tok.Parts[i]
+		if part.Text != tt.text || string(part.Source) != tt.source {
+			t.Fatalf("parts[%d] - wrong, expected=%q%q, got=%q%q",
+				i, tt.text, tt.source, part.Text, string(part.Source))
+		}
+		if part.IsExpr && len(part.Positions) != len(part.Source)+1 {
+			t.Fatalf("parts[%d] - wrong number of positions, got=%d", i, len(part.Positions))
+		}
+	}
+
+	// The nested string is lexed where it is, with its own parts.
+	l := NewInterpolation(tok.Parts[3])
+	nested := l.NextToken()
+	if nested.Type != token.INTERPOLATED || nested.Pos.Column != 23 {
+		t.Fatalf("nested string wrong, got=%s at %s", nested.Type, nested.Pos)
+	}
+	inner := NewInterpolation(nested.Parts[1])
+	for _, want := range []struct {
+		typ    token.Type
+		column int
+	}{{token.IDENT, 30}, {token.LPAREN, 31}, {token.STRING, 32}} {
+		if tok := inner.NextToken(); tok.Type != want.typ || tok.Pos.Column != want.column {
+			t.Fatalf("expected %s at column %d, got=%s at %s", want.typ, want.column, tok.Type, tok.Pos)
+		}
+	}
+}
+
+func TestBadStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		column   int
+	}{
+		{`"abc`, "unterminated string", 1},
+		{`"abc {{x" + 1`, "unterminated interpolation", 6},
+		{`"abc {{ f(\"}}\") `, "unterminated interpolation", 6},
+		{`"abc {{ f("x) }}"`, "unterminated interpolation", 6},
+		{"`abc", "unterminated raw string", 1},
+		{`"""abc""`, "unterminated heredoc", 1},
+		{`"a\qb" + "c"`, `unknown escape sequence \q`, 3},
+		{`"a\x4"`, `\x must be followed by two hex digits`, 3},
+		{`"a\u41"`, `\u must be followed by hex digits in braces, like \u{e9}`, 3},
+		{`"a\u{110000}"`, `\u{110000} isn't a valid character`, 3},
+		{"\"\"\"\n  ok\n  \\z\n\"\"\"", `unknown escape sequence \z`, 3},
+	}
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != token.ILLEGAL || tok.Literal != tt.expected {
+			t.Errorf("%s: expected %q, got=%s %q", tt.input, tt.expected, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Column != tt.column {
+			t.Errorf("%s: expected column %d, got=%d", tt.input, tt.column, tok.Pos.Column)
+		}
+	}
+
+	// After a bad escape, the rest of the string is still skipped.
+	l := New(`"a\q \"b\"" + 1`)
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.PLUS {
+		t.Errorf("expected + after the bad string, got=%s", tok.Type)
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\nb\tc\r"`, "a\nb\tc\r"},
+		{`"\a\b\f\v\0\e"`, "\a\b\f\v\x00\x1b"},
+		{`"\x41\x7e\xE9"`, "A~é"},
+		{`"\u{1F600} \u{e9} \u{41}"`, "😀 é A"},
+		{`"\"quoted\" \\ \'single\'"`, `"quoted" \ 'single'`},
+		{`"\{{x}}"`, "{{x}}"},
+		{`'it\'s'`, "it's"},
+	}
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != token.STRING && tok.Type != token.DOCSTRING {
+			t.Errorf("%s: tokentype wrong, got=%q (%s)", tt.input, tok.Type, tok.Literal)
+			continue
+		}
+		if tok.Literal != tt.expected {
+			t.Errorf("%s: literal wrong, expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	input := "`a\\.b\\n{{c}}\n\"d\"` + 1"
+	l := New(input)
+	tok := l.NextToken()
+	if tok.Type != token.STRING || tok.Literal != "a\\.b\\n{{c}}\n\"d\"" {
+		t.Fatalf("raw string wrong, got=%s %q", tok.Type, tok.Literal)
+	}
+	if tok := l.NextToken(); tok.Type != token.PLUS || tok.Pos.Line != 2 {
+		t.Fatalf("expected + on line 2, got=%s at %s", tok.Type, tok.Pos)
+	}
+}
+
+func TestHeredocs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"""one "line" here"""`, `one "line" here`},
+		{"\"\"\"\n    a\n      b\n\n    c\n    \"\"\"", "a\n  b\n\nc"},
+		{"let x = \"\"\"\n\t\ttab\\tbed\n\t\t\"\"\"", "tab\tbed"},
+		{"\"\"\"\n  first\n  last\"\"\"", "first\nlast"},
+		{`""""""`, ""},
+	}
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		for tok.Type != token.STRING && tok.Type != token.EOF {
+			tok = l.NextToken()
+		}
+		if tok.Literal != tt.expected {
+			t.Errorf("%q: literal wrong, expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("%q: expected EOF after the heredoc, got=%s", tt.input, next.Type)
+		}
+	}
+
+	// Interpolations in heredocs are where they are in the source.
+	input := "\"\"\"\n    <a href=\"{{url}}\">\n    \"\"\""
+	tok := New(input).NextToken()
+	if tok.Type != token.INTERPOLATED || len(tok.Parts) != 3 {
+		t.Fatalf("heredoc not interpolated, got=%s %v", tok.Type, tok.Parts)
+	}
+	if tok.Parts[0].Text != `<a href="` || tok.Parts[2].Text != `">` {
+		t.Fatalf("heredoc text wrong, got=%q %q", tok.Parts[0].Text, tok.Parts[2].Text)
+	}
+	if pos := tok.Parts[1].Positions[0]; pos.Line != 2 || pos.Column != 16 {
+		t.Fatalf("interpolation position wrong, got=%s", pos)
+	}
+}
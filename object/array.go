@@ -15,9 +15,6 @@ type Array struct {
 	// Elements holds the individual members of the array we're wrapping.
 	Elements []Object
 
-	// offset holds our iteration-offset.
-	offset int
-
 	// special arr when used for ... args
 	IsCurrentArgs bool
 }
@@ -55,7 +52,8 @@ func (ao *Array) GetMethod(method string) BuiltinFunction {
 		}
 	case "methods":
 		return func(env *Environment, args ...Object) Object {
-			static := []string{"methods", "append"}
+			// pmap and pfilter are the evaluator's.
+			static := []string{"methods", "append", "pmap", "pfilter"}
 			dynamic := env.Names("array.")
 
 			var names []string
@@ -76,29 +74,27 @@ func (ao *Array) GetMethod(method string) BuiltinFunction {
 	return nil
 }
 
-// Reset implements the Iterable interface, and allows the contents
-// of the array to be reset to allow re-iteration.
-func (ao *Array) Reset() {
-	ao.offset = 0
-}
-
-// Next implements the Iterable interface, and allows the contents
+// Iter implements the Iterable interface, and allows the contents
 // of our array to be iterated over.
-func (ao *Array) Next() (Object, Object, bool) {
-	if ao.offset < len(ao.Elements) {
-		ao.offset++
-
-		element := ao.Elements[ao.offset-1]
-		return element, &Integer{Value: int64(ao.offset - 1)}, true
-	}
-
-	return nil, &Integer{Value: 0}, false
+func (ao *Array) Iter() *Iterator {
+	offset := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if offset < len(ao.Elements) {
+			offset++
+			return ao.Elements[offset-1], &Integer{Value: int64(offset - 1)}, true
+		}
+		return nil, &Integer{Value: 0}, false
+	}}
 }
 
 // ToInterface converts this object to a go-interface, which will allow
 // it to be used naturally in our sprintf/printf primitives.
 func (ao *Array) ToInterface() interface{} {
-	return "<ARRAY>"
+	res := make([]interface{}, len(ao.Elements))
+	for i, e := range ao.Elements {
+		res[i] = e.ToInterface()
+	}
+	return res
 }
 
 // JSON returns a json-friendly string
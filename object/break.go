@@ -0,0 +1,39 @@
+package object
+
+// Break is returned from evaluating a break-statement, and unwinds
+// blocks until it reaches the loop it applies to.
+type Break struct {
+	// Label is the label of the loop being broken out of, if any.
+	Label string
+}
+
+// Type returns the type of this object.
+func (b *Break) Type() Type {
+	return BREAK_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (b *Break) Inspect() string {
+	if b.Label != "" {
+		return "break " + b.Label
+	}
+	return "break"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (b *Break) GetMethod(string) BuiltinFunction {
+	// There are no methods available upon a break-object.
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (b *Break) ToInterface() interface{} {
+	return "<BREAK>"
+}
+
+// JSON returns a json-friendly string
+func (b *Break) JSON(indent bool) string {
+	return b.Inspect()
+}
@@ -7,6 +7,9 @@ type BuiltinFunction func(env *Environment, args ...Object) Object
 type Builtin struct {
 	// Value holds the function we wrap.
 	Fn BuiltinFunction
+
+	// Signature describes the arguments the function takes, if known.
+	Signature *Signature
 }
 
 // Type returns the type of this object.
@@ -24,7 +27,7 @@ func (b *Builtin) Inspect() string {
 func (b *Builtin) GetMethod(method string) BuiltinFunction {
 	if method == "methods" {
 		return func(env *Environment, args ...Object) Object {
-			names := []string{"methods"}
+			names := []string{"doc", "methods", "name"}
 
 			result := make([]Object, len(names))
 			for i, txt := range names {
@@ -33,6 +36,25 @@ func (b *Builtin) GetMethod(method string) BuiltinFunction {
 			return &Array{Elements: result}
 		}
 	}
+
+	if method == "doc" {
+		return func(env *Environment, args ...Object) Object {
+			if b.Signature != nil {
+				return &String{Value: b.Signature.String()}
+			}
+			return &String{Value: ""}
+		}
+	}
+
+	if method == "name" {
+		return func(env *Environment, args ...Object) Object {
+			if b.Signature != nil {
+				return &String{Value: b.Signature.Name}
+			}
+			return &String{Value: ""}
+		}
+	}
+
 	return nil
 }
 
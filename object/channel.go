@@ -0,0 +1,80 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Channel passes values between functions running at the same time, like
+// a Go channel, which it's built on. It can be buffered or not. Sending
+// and receiving are done by the evaluator, which can cancel them.
+type Channel struct {
+	// C carries the values. It's never closed, so sending can't panic;
+	// closed is closed instead.
+	C chan Object
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewChannel returns an open channel which buffers size values.
+func NewChannel(size int) *Channel {
+	return &Channel{C: make(chan Object, size), closed: make(chan struct{})}
+}
+
+// Close closes the channel, reporting whether it was open. Values still
+// buffered can be received, but no more can be sent.
+func (c *Channel) Close() bool {
+	closed := false
+	c.once.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	return closed
+}
+
+// Closed is closed once the channel is.
+func (c *Channel) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// IsClosed reports whether the channel was closed.
+func (c *Channel) IsClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Type returns the type of this object.
+func (c *Channel) Type() Type {
+	return CHANNEL_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (c *Channel) Inspect() string {
+	if c.IsClosed() {
+		return "<channel:closed>"
+	}
+	return fmt.Sprintf("<channel:%d/%d>", len(c.C), cap(c.C))
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (c *Channel) GetMethod(method string) BuiltinFunction {
+	// The methods block, so they're the evaluator's.
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (c *Channel) ToInterface() interface{} {
+	return c.Inspect()
+}
+
+// JSON returns a json-friendly string
+func (c *Channel) JSON(indent bool) string {
+	return c.Inspect()
+}
@@ -0,0 +1,39 @@
+package object
+
+// Continue is returned from evaluating a continue-statement, and
+// unwinds blocks until it reaches the loop it applies to.
+type Continue struct {
+	// Label is the label of the loop being continued, if any.
+	Label string
+}
+
+// Type returns the type of this object.
+func (c *Continue) Type() Type {
+	return CONTINUE_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (c *Continue) Inspect() string {
+	if c.Label != "" {
+		return "continue " + c.Label
+	}
+	return "continue"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (c *Continue) GetMethod(string) BuiltinFunction {
+	// There are no methods available upon a continue-object.
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (c *Continue) ToInterface() interface{} {
+	return "<CONTINUE>"
+}
+
+// JSON returns a json-friendly string
+func (c *Continue) JSON(indent bool) string {
+	return c.Inspect()
+}
@@ -1,62 +1,129 @@
 package object
 
 import (
-	"fmt"
+	"context"
 	"strings"
-
-	"github.com/zacanger/cozy/utils"
+	"sync"
 )
 
-// Environment stores our functions, variables, constants, etc.
+// Environment stores our functions, variables, constants, etc. It's safe
+// to use from several goroutines, since async functions, timers, and http
+// handlers all run on their own, sharing the environments their functions
+// were defined in.
 type Environment struct {
-	// store holds variables, including functions.
+	// mu guards the variables: store, readonly, slots, and slotNames.
+	mu sync.RWMutex
+
+	// store holds variables, including functions, by name. It's only
+	// made once something is stored, since function calls keep their
+	// variables in slots.
 	store map[string]Object
 
-	// readonly marks names as read-only.
+	// readonly marks names as read-only. It's only made once something
+	// is bound with let.
 	readonly map[string]bool
 
+	// slots holds the local variables of a function call, or of the
+	// blocks in a program, in the places the resolver gave them.
+	slots []Object
+
+	// slotNames names the slots, for looking them up by name.
+	slotNames []string
+
 	// outer holds any parent environment. Our env. allows
 	// nesting to implement scope.
 	outer *Environment
 
-	// permit stores the names of variables we can set in this
-	// environment, if any
-	permit []string
-
 	// Args used when creating this env. Used in ...
 	CurrentArgs []Object
 
 	// Spread elements from an array, used in ....
 	SpreadElements []Object
+
+	// Runtime is the interpreter instance this environment belongs to.
+	// It's owned by the evaluator, and shared with every nested scope.
+	Runtime interface{}
+
+	// CallDepth is how many function calls deep this environment is.
+	CallDepth int
+
+	// Function is the name of the function called, for stack traces.
+	Function string
+
+	// Context cancels the code running in this environment, along with
+	// the runtime's own context. Async functions have one, which the
+	// functions they call share; it's nil otherwise.
+	Context context.Context
 }
 
 // NewEnvironment creates new environment
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	r := make(map[string]bool)
-	return &Environment{store: s, readonly: r, outer: nil}
+	return &Environment{store: s, outer: nil}
 }
 
 // NewEnclosedEnvironment create new environment by outer parameter
 func NewEnclosedEnvironment(outer *Environment, args []Object) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	env.CurrentArgs = args
-	return env
+	return NewFrame(outer, args, nil)
 }
 
-// NewTemporaryScope creates a temporary scope where some values
-// are ignored.
-// This is used as a sneaky hack to allow `foreach` to access all
-// global values as if they were local, but prevent the index/value
-// keys from persisting.
-func NewTemporaryScope(outer *Environment, keys []string) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	env.permit = keys
+// NewFrame creates the environment for a function call, with a slot for
+// each of the names.
+func NewFrame(outer *Environment, args []Object, names []string) *Environment {
+	env := &Environment{outer: outer, CurrentArgs: args, slotNames: names}
+	if len(names) > 0 {
+		env.slots = make([]Object, len(names))
+	}
+	env.Runtime = outer.Runtime
+	env.CallDepth = outer.CallDepth
+	env.Context = outer.Context
 	return env
 }
 
+// AddSlots adds slots with the given names, for the blocks of a program
+// run in this environment.
+func (e *Environment) AddSlots(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// The names might be shared with a function, so they're copied
+	// rather than appended to in place.
+	e.slotNames = append(e.slotNames[:len(e.slotNames):len(e.slotNames)], names...)
+	e.slots = append(e.slots, make([]Object, len(names))...)
+}
+
+// SlotCount returns how many slots the environment has.
+func (e *Environment) SlotCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.slots)
+}
+
+// Local returns the value in a slot of the environment depth frames out
+// from this one, which is nil if nothing's been put there yet.
+func (e *Environment) Local(depth, slot int) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.mu.RLock()
+	val := e.slots[slot]
+	e.mu.RUnlock()
+	return val
+}
+
+// SetLocal puts a value in a slot of the environment depth frames out
+// from this one.
+func (e *Environment) SetLocal(depth, slot int, val Object) {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.mu.Lock()
+	e.slots[slot] = val
+	e.mu.Unlock()
+}
+
 // Names returns the names of every known-value with the
 // given prefix.
 // This function is used by `invokeMethod` to get the methods
@@ -64,6 +131,8 @@ func NewTemporaryScope(outer *Environment, keys []string) *Environment {
 func (e *Environment) Names(prefix string) []string {
 	var ret []string
 
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	for key := range e.store {
 		if strings.HasPrefix(key, prefix) {
 			ret = append(ret, key)
@@ -80,87 +149,110 @@ func (e *Environment) Names(prefix string) []string {
 
 // Get returns the value of a given variable, by name.
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+	for env := e; env != nil; env = env.outer {
+		env.mu.RLock()
+		obj, ok := env.store[name]
+		env.mu.RUnlock()
+		if ok {
+			return obj, true
+		}
 	}
-	return obj, ok
-}
 
-// Set stores the value of a variable, by name.
-func (e *Environment) Set(name string, val Object) Object {
-	cur := e.store[name]
-
-	if e.outer == nil && !utils.IsRepl {
-		fmt.Printf(
-			"No mutable variables at the top level! %s must be bound with let!\n",
-			name,
-		)
-		utils.ExitConditionally(3)
+	// Local variables are normally found by their slots, so they're
+	// only looked for by name when nothing else has it, like when
+	// interpolating a template.
+	for env := e; env != nil; env = env.outer {
+		if obj, ok := env.slotNamed(name); ok {
+			return obj, true
+		}
 	}
+	return nil, false
+}
 
-	if (cur != nil && e.readonly[name]) ||
-		(e.outer != nil && e.outer.store[name] != nil &&
-			e.outer.readonly[name]) {
-		fmt.Printf(
-			"Attempting to modify '%s' denied; it was defined as a constant.\n",
-			name,
-		)
-		utils.ExitConditionally(3)
+// slotNamed returns the value of the newest slot with a name, if it's
+// been set.
+func (e *Environment) slotNamed(name string) (Object, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for i := len(e.slotNames) - 1; i >= 0; i-- {
+		if e.slotNames[i] == name && e.slots[i] != nil {
+			return e.slots[i], true
+		}
 	}
+	return nil, false
+}
 
-	ff, ok := val.(*Function)
-	if ok {
-		ff.Name = name
-	}
-	// Store the (updated) value.
-
-	// This chunk is used for temporary environments (foreach loops)
-	if len(e.permit) > 0 {
-		for _, v := range e.permit {
-			// we're permitted to store this variable
-			if v == name {
-				e.store[name] = val
-				return val
-			}
-		}
-		// ok we're not permitted, we must store in the parent
-		if e.outer != nil {
-			return e.outer.Set(name, val)
+// Readonly reports whether a variable was bound with let.
+func (e *Environment) Readonly(name string) bool {
+	for env := e; env != nil; env = env.outer {
+		env.mu.RLock()
+		_, ok := env.store[name]
+		readonly := env.readonly[name]
+		env.mu.RUnlock()
+		if ok {
+			return readonly
 		}
-
-		// Otherwise something is very broken!
-		fmt.Printf("Something is broken with scope!\n")
-		utils.ExitConditionally(5)
 	}
+	return false
+}
 
-	// Otherwise we're just in a regular block
-	// First check to see if this is a shadowed var
-	if e.outer != nil && e.outer.store[name] != nil {
-		return e.outer.Set(name, val)
-	}
+// Set stores the value of a variable, by name, wherever it's already
+// defined, or otherwise in this environment.
+func (e *Environment) Set(name string, val Object) Object {
+	NameFunction(name, val)
 
-	// ...and otherwise, just store it in the current scope
-	e.store[name] = val
+	for env := e; env != nil; env = env.outer {
+		env.mu.Lock()
+		_, ok := env.store[name]
+		if ok {
+			env.store[name] = val
+		}
+		env.mu.Unlock()
+		if ok {
+			return val
+		}
+	}
+	e.mu.Lock()
+	e.put(name, val)
+	e.mu.Unlock()
 	return val
 }
 
 // SetLet sets the value of a constant by name.
 func (e *Environment) SetLet(name string, val Object) Object {
-	ff, ok := val.(*Function)
-	if ok {
-		ff.Name = name
-	}
+	NameFunction(name, val)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
 	// store the value
-	e.store[name] = val
+	e.put(name, val)
 
 	// flag as read-only.
+	if e.readonly == nil {
+		e.readonly = make(map[string]bool)
+	}
 	e.readonly[name] = true
 
 	return val
 }
 
+// put stores a value in this environment, which must be locked.
+func (e *Environment) put(name string, val Object) {
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
+	e.store[name] = val
+}
+
+// NameFunction names a function after the variable it's first bound to.
+// Binding it again, once it may be in use elsewhere, keeps the name.
+func NameFunction(name string, val Object) {
+	if ff, ok := val.(*Function); ok && name != "" {
+		ff.name.CompareAndSwap(nil, name)
+	}
+}
+
 // ExportedHash returns a new Hash with the names and values of every publically
 // exported binding in the environment; that is, every top-level binding (not
 // in a block).
@@ -168,6 +260,8 @@ func (e *Environment) SetLet(name string, val Object) Object {
 // evaulated module into an object.
 func (e *Environment) ExportedHash() *Hash {
 	pairs := make(map[HashKey]HashPair)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	for k, v := range e.store {
 		s := &String{Value: k}
 		pairs[s.HashKey()] = HashPair{Key: s, Value: v}
@@ -1,7 +1,11 @@
 package object
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+
+	"github.com/zacanger/cozy/token"
 )
 
 // Error wraps string and implements Object interface.
@@ -16,7 +20,104 @@ type Error struct {
 	BuiltinCall bool
 
 	// Any extra data
-	Data string
+	Data Object
+
+	// Pos is where in the source the error was raised, if known
+	Pos token.Position
+
+	// Stack is the function calls the error was raised in, innermost
+	// first, recorded as it leaves each of them.
+	Stack []StackFrame
+
+	// CaughtIn is the function the error was caught in, which is where
+	// its stack trace starts. It's empty for the top level.
+	CaughtIn string
+
+	// Exit is set on the error sys.exit() raises to end the program with
+	// Code. Nothing can catch it.
+	Exit bool
+}
+
+// StackFrame is a function call an error was raised in: the name of the
+// function, and where it was called from.
+type StackFrame struct {
+	Function string
+	Pos      token.Position
+}
+
+// FunctionName returns the name of the function, or <anonymous>.
+func (f StackFrame) FunctionName() string {
+	if f.Function == "" {
+		return "<anonymous>"
+	}
+	return f.Function
+}
+
+// TraceLine is a line of a stack trace: where the program was in a
+// function.
+type TraceLine struct {
+	Function string
+	Pos      token.Position
+}
+
+// TraceLines returns where the program was in each function the error was
+// raised in, most recent call last, starting where it was caught.
+func (e *Error) TraceLines() []TraceLine {
+	lines := make([]TraceLine, 0, len(e.Stack)+1)
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		// A callback, like a timer's, wasn't called from anywhere in
+		// the program.
+		if i == len(e.Stack)-1 && !e.Stack[i].Pos.IsValid() {
+			continue
+		}
+		caller := "<main>"
+		if e.CaughtIn != "" {
+			caller = e.CaughtIn
+		}
+		if i+1 < len(e.Stack) {
+			caller = e.Stack[i+1].FunctionName()
+		}
+		lines = append(lines, TraceLine{Function: caller, Pos: e.Stack[i].Pos})
+	}
+	switch {
+	case len(e.Stack) > 0:
+		lines = append(lines, TraceLine{Function: e.Stack[0].FunctionName(), Pos: e.Pos})
+	case e.Pos.IsValid():
+		// It was caught in the function which raised it.
+		caller := "<main>"
+		if e.CaughtIn != "" {
+			caller = e.CaughtIn
+		}
+		lines = append(lines, TraceLine{Function: caller, Pos: e.Pos})
+	}
+	return lines
+}
+
+// Trace returns the error with a stack trace, like Python's, if it was
+// raised in a function.
+func (e *Error) Trace() string {
+	if len(e.Stack) == 0 {
+		return e.Inspect()
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Traceback (most recent call last):\n")
+	lines := e.TraceLines()
+	for i := 0; i < len(lines); {
+		fmt.Fprintf(&out, "  %s, in %s\n", lines[i].Pos, lines[i].Function)
+
+		// Deep recursion is summed up rather than printed in full.
+		n := 1
+		for i+n < len(lines) && lines[i+n] == lines[i] {
+			n++
+		}
+		if n > 1 {
+			fmt.Fprintf(&out, "  [previous line repeated %d more times]\n", n-1)
+		}
+		i += n
+	}
+	out.WriteString(e.Inspect())
+	return out.String()
 }
 
 // Type returns the type of this object.
@@ -27,11 +128,14 @@ func (e *Error) Type() Type {
 // Inspect returns a string-representation of the given object.
 func (e *Error) Inspect() string {
 	msg := "ERROR: " + e.Message
+	if e.Pos.IsValid() {
+		msg = "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
 	if e.Code != nil {
 		msg += "; CODE: " + fmt.Sprint(*e.Code)
 	}
-	if e.Data != "" {
-		msg += "; DATA: " + fmt.Sprint(e.Data)
+	if e.Data != nil {
+		msg += "; DATA: " + e.Data.Inspect()
 	}
 	return msg
 }
@@ -46,7 +150,7 @@ func (e *Error) GetMethod(string) BuiltinFunction {
 // ToInterface converts this object to a go-interface, which will allow
 // it to be used naturally in our sprintf/printf primitives.
 func (e *Error) ToInterface() interface{} {
-	return "<ERROR>"
+	return errors.New(e.Message)
 }
 
 // JSON returns a json-friendly string
@@ -55,8 +159,8 @@ func (e *Error) JSON(indent bool) string {
 	if e.Code != nil {
 		s += `,"code":` + fmt.Sprint(*e.Code)
 	}
-	if e.Data != "" {
-		s += `,"data":` + fmt.Sprint(*e.Code)
+	if e.Data != nil {
+		s += `,"data":` + e.Data.JSON(false)
 	}
 
 	s += "}"
@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/zacanger/cozy/ast"
 )
 
-var stringifiedAnonymousFunctionMap map[string]int
+var (
+	stringifiedAnonymousFunctionMap   map[string]int
+	stringifiedAnonymousFunctionMapMu sync.Mutex
+)
 
 func init() {
 	stringifiedAnonymousFunctionMap = make(map[string]int)
@@ -21,9 +26,25 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Defaults   map[string]ast.Expression
+	Patterns   map[string]*ast.Pattern
 	Env        *Environment
 	DocString  *ast.DocStringLiteral
-	Name       string
+
+	// name is the variable the function was first bound to. It's set
+	// once, atomically, since the function may already be running in
+	// other goroutines when it's bound.
+	name atomic.Value
+
+	// Locals names the slots of the function's frame.
+	Locals []string
+
+	// Self is the value a method was looked up on, which is "self"
+	// within it.
+	Self Object
+
+	// Compiled is the body compiled to bytecode by the evaluator, if
+	// the function was made by its VM.
+	Compiled interface{}
 }
 
 func (f *Function) stringify() string {
@@ -43,11 +64,38 @@ func (f *Function) stringify() string {
 	return out.String()
 }
 
+// Name returns the name of the function, or "" if it's anonymous.
+func (f *Function) Name() string {
+	name, _ := f.name.Load().(string)
+	return name
+}
+
+// WithSelf returns a copy of the function which is a method of self.
+func (f *Function) WithSelf(self Object) *Function {
+	fn := &Function{
+		Parameters: f.Parameters,
+		Body:       f.Body,
+		Defaults:   f.Defaults,
+		Patterns:   f.Patterns,
+		Env:        f.Env,
+		DocString:  f.DocString,
+		Locals:     f.Locals,
+		Self:       self,
+		Compiled:   f.Compiled,
+	}
+	if name := f.Name(); name != "" {
+		fn.name.Store(name)
+	}
+	return fn
+}
+
 func (f *Function) getNameOrDefault() string {
-	if f.Name != "" {
-		return "FN_" + f.Name
+	if name := f.Name(); name != "" {
+		return "FN_" + name
 	}
 
+	stringifiedAnonymousFunctionMapMu.Lock()
+	defer stringifiedAnonymousFunctionMapMu.Unlock()
 	n := 1
 	if stringifiedAnonymousFunctionMap[f.stringify()] != 0 {
 		n = stringifiedAnonymousFunctionMap[f.stringify()]
@@ -0,0 +1,120 @@
+package object
+
+import (
+	"sync"
+)
+
+// Future is the result of an async function, which is settled once the
+// function returns, raises an error, or is cancelled. It can be awaited
+// any number of times, from anywhere.
+type Future struct {
+	mu        sync.Mutex
+	done      chan struct{}
+	result    Object
+	cancelled bool
+
+	// cancel stops the function, if it's still running.
+	cancel func()
+}
+
+// NewFuture returns a pending future, which calls cancel if it's
+// cancelled before it's settled.
+func NewFuture(cancel func()) *Future {
+	return &Future{done: make(chan struct{}), cancel: cancel}
+}
+
+// Settle sets the result of the future, unless it's already settled,
+// reporting whether it was.
+func (f *Future) Settle(result Object) bool {
+	return f.settle(result, false)
+}
+
+func (f *Future) settle(result Object, cancelled bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.result != nil {
+		return false
+	}
+	f.result = result
+	f.cancelled = cancelled
+	close(f.done)
+	return true
+}
+
+// Cancel stops the function, and settles the future with an error, unless
+// it's already settled. It reports whether it was cancelled.
+func (f *Future) Cancel() bool {
+	if !f.settle(&Error{Message: "future was cancelled"}, true) {
+		return false
+	}
+	if f.cancel != nil {
+		f.cancel()
+	}
+	return true
+}
+
+// Done is closed once the future is settled.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result returns what the function returned, or the error it raised, once
+// the future is settled; it's nil before then.
+func (f *Future) Result() Object {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.result
+}
+
+// State returns "pending", "done", "failed", or "cancelled".
+func (f *Future) State() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.result == nil:
+		return "pending"
+	case f.cancelled:
+		return "cancelled"
+	}
+	if e, ok := f.result.(*Error); ok && !e.BuiltinCall {
+		return "failed"
+	}
+	return "done"
+}
+
+// Type returns the type of this object.
+func (f *Future) Type() Type {
+	return FUTURE_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (f *Future) Inspect() string {
+	return "<future:" + f.State() + ">"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (f *Future) GetMethod(method string) BuiltinFunction {
+	switch method {
+	case "state":
+		return func(env *Environment, args ...Object) Object {
+			return &String{Value: f.State()}
+		}
+	case "cancel":
+		return func(env *Environment, args ...Object) Object {
+			return &Boolean{Value: f.Cancel()}
+		}
+	}
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (f *Future) ToInterface() interface{} {
+	return f.Inspect()
+}
+
+// JSON returns a json-friendly string
+func (f *Future) JSON(indent bool) string {
+	return f.Inspect()
+}
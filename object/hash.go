@@ -29,9 +29,6 @@ type HashPair struct {
 type Hash struct {
 	// Pairs holds the key/value pairs of the hash we wrap
 	Pairs map[HashKey]HashPair
-
-	// offset holds our iteration-offset.
-	offset int
 }
 
 // Type returns the type of this object.
@@ -53,12 +50,6 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
-// Reset implements the Iterable interface, and allows the contents
-// of the array to be reset to allow re-iteration.
-func (h *Hash) Reset() {
-	h.offset = 0
-}
-
 // GetMethod returns a method against the object.
 // (Built-in methods only.)
 func (h *Hash) GetMethod(method string) BuiltinFunction {
@@ -134,28 +125,37 @@ func (h *Hash) GetMethod(method string) BuiltinFunction {
 	return nil
 }
 
-// Next implements the Iterable interface, and allows the contents
-// of our array to be iterated over.
-func (h *Hash) Next() (Object, Object, bool) {
-	if h.offset < len(h.Pairs) {
-		idx := 0
-
-		for _, pair := range h.Pairs {
-			if h.offset == idx {
-				h.offset++
-				return pair.Key, pair.Value, true
-			}
-			idx++
-		}
+// Iter implements the Iterable interface, and allows the keys and
+// values of our hash to be iterated over.
+func (h *Hash) Iter() *Iterator {
+	// The pairs are taken in one go, since the order of a map can change
+	// from one range over it to the next.
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
 	}
-
-	return nil, &Integer{Value: 0}, false
+	offset := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if offset < len(pairs) {
+			offset++
+			return pairs[offset-1].Key, pairs[offset-1].Value, true
+		}
+		return nil, &Integer{Value: 0}, false
+	}}
 }
 
 // ToInterface converts this object to a go-interface, which will allow
 // it to be used naturally in our sprintf/printf primitives.
 func (h *Hash) ToInterface() interface{} {
-	return "<HASH>"
+	res := make(map[string]interface{}, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		key := pair.Key.Inspect()
+		if s, ok := pair.Key.(*String); ok {
+			key = s.Value
+		}
+		res[key] = pair.Value.ToInterface()
+	}
+	return res
 }
 
 // JSON returns a json-friendly string
@@ -0,0 +1,53 @@
+package object
+
+// Iterator steps through the items of an Iterable for a foreach loop,
+// which the VM keeps on its stack.
+type Iterator struct {
+	next func() (Object, Object, bool)
+
+	// Err is set if the iteration stopped because it failed, like a
+	// receive from a channel which was cancelled.
+	Err *Error
+}
+
+// NewIterator returns an iterator which calls next for each item.
+func NewIterator(next func() (Object, Object, bool)) *Iterator {
+	return &Iterator{next: next}
+}
+
+// Next gets the next "thing" from the object being iterated over.
+// The return values are the item which is to be returned next, the index
+// of that object, and finally a boolean to say whether the function
+// succeeded. If the boolean value returned is false then that means the
+// iteration has completed and no further items are available.
+func (it *Iterator) Next() (Object, Object, bool) {
+	return it.next()
+}
+
+// Type returns the type of this object.
+func (it *Iterator) Type() Type {
+	return ITERATOR_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (it *Iterator) Inspect() string {
+	return "iterator"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (it *Iterator) GetMethod(string) BuiltinFunction {
+	// There are no methods available upon an iterator.
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (it *Iterator) ToInterface() interface{} {
+	return "<ITERATOR>"
+}
+
+// JSON returns a json-friendly string
+func (it *Iterator) JSON(indent bool) string {
+	return it.Inspect()
+}
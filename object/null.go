@@ -22,7 +22,7 @@ func (n *Null) GetMethod(string) BuiltinFunction {
 // ToInterface converts this object to a go-interface, which will allow
 // it to be used naturally in our sprintf/printf primitives.
 func (n *Null) ToInterface() interface{} {
-	return "<NULL>"
+	return nil
 }
 
 // JSON returns a json-friendly string
@@ -7,37 +7,59 @@ type Type string
 // pre-defined constant Type
 const (
 	ARRAY_OBJ        = "ARRAY"
+	ATOMIC_OBJ       = "ATOMIC"
 	BOOLEAN_OBJ      = "BOOLEAN"
+	BREAK_OBJ        = "BREAK"
 	BUILTIN_OBJ      = "BUILTIN"
+	CHANNEL_OBJ      = "CHANNEL"
+	CONTINUE_OBJ     = "CONTINUE"
 	DOCSTRING_OBJ    = "DOCSTRING"
 	ERROR_OBJ        = "ERROR"
 	FILE_OBJ         = "FILE"
 	FLOAT_OBJ        = "FLOAT"
 	FUNCTION_OBJ     = "FUNCTION"
+	FUTURE_OBJ       = "FUTURE"
 	HASH_OBJ         = "HASH"
 	INTEGER_OBJ      = "INTEGER"
+	ITERATOR_OBJ     = "ITERATOR"
 	MODULE_OBJ       = "MODULE"
+	MUTEX_OBJ        = "MUTEX"
 	NULL_OBJ         = "NULL"
+	ONCE_OBJ         = "ONCE"
+	POOL_OBJ         = "POOL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	STRING_OBJ       = "STRING"
+	TAIL_CALL_OBJ    = "TAIL_CALL"
+	WAIT_GROUP_OBJ   = "WAIT_GROUP"
 )
 
 // SystemTypesMap map system types by type name
 var SystemTypesMap = map[Type]Object{
 	ARRAY_OBJ:        &Array{},
+	ATOMIC_OBJ:       &Atomic{},
 	BOOLEAN_OBJ:      &Boolean{},
+	BREAK_OBJ:        &Break{},
 	BUILTIN_OBJ:      &Builtin{},
+	CHANNEL_OBJ:      &Channel{},
+	CONTINUE_OBJ:     &Continue{},
 	DOCSTRING_OBJ:    &DocString{},
 	ERROR_OBJ:        &Error{},
 	FILE_OBJ:         &File{},
 	FLOAT_OBJ:        &Float{},
 	FUNCTION_OBJ:     &Function{},
+	FUTURE_OBJ:       &Future{},
 	HASH_OBJ:         &Hash{},
 	INTEGER_OBJ:      &Integer{},
+	ITERATOR_OBJ:     &Iterator{},
 	MODULE_OBJ:       &Module{},
+	MUTEX_OBJ:        &Mutex{},
 	NULL_OBJ:         &Null{},
+	ONCE_OBJ:         &Once{},
+	POOL_OBJ:         &Pool{},
 	RETURN_VALUE_OBJ: &ReturnValue{},
 	STRING_OBJ:       &String{},
+	TAIL_CALL_OBJ:    &TailCall{},
+	WAIT_GROUP_OBJ:   &WaitGroup{},
 }
 
 // Object is the interface that all of our various object-types must implmenet.
@@ -54,7 +76,7 @@ type Object interface {
 
 	// ToInterface converts the given object to a "native" golang value,
 	// which is required to ensure that we can use the object in our
-	// `sprintf` or `printf` primitives.
+	// `sprintf` or `printf` primitives, and to hand values to Go code.
 	ToInterface() interface{}
 
 	// Return a JSON-friendly string
@@ -73,16 +95,8 @@ type Hashable interface {
 // the interface is not implemented then a run-time error will
 // be generated instead.
 type Iterable interface {
-	// Reset the state of any previous iteration.
-	Reset()
-
-	// Get the next "thing" from the object being iterated
-	// over.
-	// The return values are the item which is to be returned
-	// next, the index of that object, and finally a boolean
-	// to say whether the function succeeded.
-	// If the boolean value returned is false then that
-	// means the iteration has completed and no further
-	// items are available.
-	Next() (Object, Object, bool)
+	// Iter returns a new iterator over the object. Each has its own
+	// state, so the same object can be iterated over by nested loops,
+	// or on several goroutines at once.
+	Iter() *Iterator
 }
@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"sync"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +20,25 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("string with different have same hash key")
 	}
 }
+
+func TestNameFunction(t *testing.T) {
+	fn := &Function{}
+	// the function may be running elsewhere when it's named
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = fn.Name()
+		}()
+	}
+	NameFunction("f", fn)
+	NameFunction("g", fn)
+	wg.Wait()
+	if fn.Name() != "f" {
+		t.Errorf("wrong name. expected=%q, got=%q", "f", fn.Name())
+	}
+	if m := fn.WithSelf(&Integer{Value: 1}); m.Name() != "f" || m.Self == nil {
+		t.Errorf("wrong method. got name=%q, self=%v", m.Name(), m.Self)
+	}
+}
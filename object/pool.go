@@ -0,0 +1,61 @@
+package object
+
+import "fmt"
+
+// Pool runs the functions submitted to it on a fixed number of workers,
+// so only that many run at once. Its methods are the evaluator's.
+type Pool struct {
+	workers chan struct{}
+}
+
+// NewPool returns a pool with n workers.
+func NewPool(n int) *Pool {
+	return &Pool{workers: make(chan struct{}, n)}
+}
+
+// Acquire returns a channel which takes a worker when it's sent to.
+func (p *Pool) Acquire() chan<- struct{} {
+	return p.workers
+}
+
+// Release frees a worker.
+func (p *Pool) Release() {
+	<-p.workers
+}
+
+// Busy returns how many workers are running functions.
+func (p *Pool) Busy() int {
+	return len(p.workers)
+}
+
+// Size returns how many workers there are.
+func (p *Pool) Size() int {
+	return cap(p.workers)
+}
+
+// Type returns the type of this object.
+func (p *Pool) Type() Type {
+	return POOL_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (p *Pool) Inspect() string {
+	return fmt.Sprintf("<pool:%d/%d>", p.Busy(), p.Size())
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (p *Pool) GetMethod(method string) BuiltinFunction {
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (p *Pool) ToInterface() interface{} {
+	return p.Inspect()
+}
+
+// JSON returns a json-friendly string
+func (p *Pool) JSON(indent bool) string {
+	return p.Inspect()
+}
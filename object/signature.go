@@ -0,0 +1,144 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Param describes a single parameter of a builtin function.
+type Param struct {
+	// Name is used in errors and docs.
+	Name string
+
+	// Types the argument may have; any type is allowed if this is empty.
+	Types []Type
+
+	// Optional parameters may be left off the end of a call.
+	Optional bool
+
+	// Variadic parameters take every remaining argument; only the last
+	// parameter may be variadic.
+	Variadic bool
+}
+
+// Arg returns a required parameter.
+func Arg(name string, types ...Type) Param {
+	return Param{Name: name, Types: types}
+}
+
+// OptionalArg returns a parameter which may be left off.
+func OptionalArg(name string, types ...Type) Param {
+	return Param{Name: name, Types: types, Optional: true}
+}
+
+// VariadicArg returns a parameter which takes any number of arguments.
+func VariadicArg(name string, types ...Type) Param {
+	return Param{Name: name, Types: types, Optional: true, Variadic: true}
+}
+
+// typeNames returns the types this parameter accepts, like "STRING or NULL"
+func (p Param) typeNames(sep string) string {
+	if len(p.Types) == 0 {
+		return "ANY"
+	}
+	names := make([]string, len(p.Types))
+	for i, t := range p.Types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, sep)
+}
+
+func (p Param) accepts(arg Object) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, t := range p.Types {
+		if typeOf(arg) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the type of an argument. An empty block evaluates to
+// nothing at all (a Go nil), which is passed on as null.
+func typeOf(arg Object) Type {
+	if arg == nil {
+		return NULL_OBJ
+	}
+	return arg.Type()
+}
+
+// String returns the parameter as it's shown in docs, like
+// "[mode: STRING]".
+func (p Param) String() string {
+	s := p.Name + ": " + p.typeNames("|")
+	if p.Variadic {
+		return s + "..."
+	}
+	if p.Optional {
+		return "[" + s + "]"
+	}
+	return s
+}
+
+// Signature describes the parameters of a builtin function, so its
+// arguments can be checked before it's called.
+type Signature struct {
+	// Name of the builtin, like "fs.mv".
+	Name string
+
+	// Params in order.
+	Params []Param
+}
+
+// String returns the signature as it's shown in docs, like
+// "fs.mv(source: STRING, dest: STRING)".
+func (s *Signature) String() string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = p.String()
+	}
+	return s.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// Check returns an error describing the first argument which doesn't fit
+// the signature, or nil if they all do.
+func (s *Signature) Check(args []Object) *Error {
+	required := 0
+	variadic := false
+	for _, p := range s.Params {
+		if !p.Optional {
+			required++
+		}
+		variadic = variadic || p.Variadic
+	}
+
+	if len(args) < required || (!variadic && len(args) > len(s.Params)) {
+		want := fmt.Sprint(required)
+		if variadic {
+			want = fmt.Sprintf("at least %d", required)
+		} else if required != len(s.Params) {
+			want = fmt.Sprintf("%d to %d", required, len(s.Params))
+		}
+		return &Error{Message: fmt.Sprintf(
+			"%s: wrong number of arguments. got=%d, want=%s",
+			s.Name, len(args), want,
+		)}
+	}
+
+	for i, arg := range args {
+		p := s.Params[len(s.Params)-1]
+		if i < len(s.Params) {
+			p = s.Params[i]
+		}
+		if !p.accepts(arg) {
+			return &Error{Message: fmt.Sprintf(
+				"%s: argument %d (%s) must be %s, got %s",
+				s.Name, i+1, p.Name, p.typeNames(" or "), typeOf(arg),
+			)}
+		}
+	}
+
+	return nil
+}
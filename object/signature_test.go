@@ -0,0 +1,72 @@
+package object
+
+import "testing"
+
+func TestSignatureString(t *testing.T) {
+	sig := &Signature{Name: "fs.open", Params: []Param{
+		Arg("path", STRING_OBJ),
+		OptionalArg("mode", STRING_OBJ, NULL_OBJ),
+	}}
+	exp := "fs.open(path: STRING, [mode: STRING|NULL])"
+	if sig.String() != exp {
+		t.Fatalf("String failed: wanted %s, got %s", exp, sig.String())
+	}
+
+	sig = &Signature{Name: "print", Params: []Param{VariadicArg("values")}}
+	exp = "print(values: ANY...)"
+	if sig.String() != exp {
+		t.Fatalf("String failed: wanted %s, got %s", exp, sig.String())
+	}
+}
+
+func TestSignatureCheck(t *testing.T) {
+	str := &String{Value: "a"}
+	num := &Integer{Value: 1}
+	yes := &Boolean{Value: true}
+	sig := &Signature{Name: "fs.mv", Params: []Param{
+		Arg("source", STRING_OBJ),
+		Arg("dest", STRING_OBJ),
+	}}
+	opt := &Signature{Name: "net.read", Params: []Param{
+		Arg("fd", INTEGER_OBJ),
+		OptionalArg("size", INTEGER_OBJ),
+	}}
+	variadic := &Signature{Name: "join", Params: []Param{
+		Arg("sep", STRING_OBJ),
+		VariadicArg("parts", STRING_OBJ, INTEGER_OBJ),
+	}}
+
+	tests := []struct {
+		sig  *Signature
+		args []Object
+		exp  string
+	}{
+		{sig, []Object{str, str}, ""},
+		{sig, []Object{str}, "fs.mv: wrong number of arguments. got=1, want=2"},
+		{sig, []Object{str, num},
+			"fs.mv: argument 2 (dest) must be STRING, got INTEGER"},
+		{sig, []Object{nil, str},
+			"fs.mv: argument 1 (source) must be STRING, got NULL"},
+		{opt, []Object{num}, ""},
+		{opt, []Object{num, num}, ""},
+		{&Signature{Name: "maybe", Params: []Param{Arg("x", NULL_OBJ)}}, []Object{nil}, ""},
+		{opt, []Object{num, num, num},
+			"net.read: wrong number of arguments. got=3, want=1 to 2"},
+		{variadic, []Object{str, str, num, str}, ""},
+		{variadic, []Object{},
+			"join: wrong number of arguments. got=0, want=at least 1"},
+		{variadic, []Object{str, str, yes},
+			"join: argument 3 (parts) must be STRING or INTEGER, got BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		err := tt.sig.Check(tt.args)
+		res := ""
+		if err != nil {
+			res = err.Message
+		}
+		if res != tt.exp {
+			t.Errorf("Check failed: wanted %q, got %q", tt.exp, res)
+		}
+	}
+}
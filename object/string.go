@@ -7,16 +7,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"unicode/utf8"
 )
 
 // String wraps string and implements Object and Hashable interfaces.
 type String struct {
 	// Value holds the string value this object wraps.
 	Value string
-
-	// Offset holds our iteration-offset
-	offset int
 }
 
 // Type returns the type of this object.
@@ -84,28 +80,19 @@ func (s *String) GetMethod(method string) BuiltinFunction {
 	return nil
 }
 
-// Reset implements the Iterable interface, and allows the contents
-// of the string to be reset to allow re-iteration.
-func (s *String) Reset() {
-	s.offset = 0
-}
-
-// Next implements the Iterable interface, and allows the contents
+// Iter implements the Iterable interface, and allows the characters
 // of our string to be iterated over.
-func (s *String) Next() (Object, Object, bool) {
-	if s.offset < utf8.RuneCountInString(s.Value) {
-		s.offset++
-
-		// Get the characters as an array of runes
-		chars := []rune(s.Value)
-
-		// Now index
-		val := &String{Value: string(chars[s.offset-1])}
-
-		return val, &Integer{Value: int64(s.offset - 1)}, true
-	}
-
-	return nil, &Integer{Value: 0}, false
+func (s *String) Iter() *Iterator {
+	chars := []rune(s.Value)
+	offset := 0
+	return &Iterator{next: func() (Object, Object, bool) {
+		if offset < len(chars) {
+			offset++
+			val := &String{Value: string(chars[offset-1])}
+			return val, &Integer{Value: int64(offset - 1)}, true
+		}
+		return nil, &Integer{Value: 0}, false
+	}}
 }
 
 // ToInterface converts this object to a go-interface, which will allow
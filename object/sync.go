@@ -0,0 +1,253 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Mutex is a lock which one function holds at a time. It's a channel
+// with room for one value, rather than a sync.Mutex, so that waiting for
+// it can be cancelled. Its methods are the evaluator's.
+type Mutex struct {
+	held chan struct{}
+}
+
+// NewMutex returns an unlocked mutex.
+func NewMutex() *Mutex {
+	return &Mutex{held: make(chan struct{}, 1)}
+}
+
+// Acquire returns a channel which takes the lock when it's sent to.
+func (m *Mutex) Acquire() chan<- struct{} {
+	return m.held
+}
+
+// Unlock releases the lock, reporting whether it was held.
+func (m *Mutex) Unlock() bool {
+	select {
+	case <-m.held:
+		return true
+	default:
+		return false
+	}
+}
+
+// Locked reports whether the lock is held.
+func (m *Mutex) Locked() bool {
+	return len(m.held) > 0
+}
+
+// Type returns the type of this object.
+func (m *Mutex) Type() Type {
+	return MUTEX_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (m *Mutex) Inspect() string {
+	if m.Locked() {
+		return "<mutex:locked>"
+	}
+	return "<mutex:unlocked>"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (m *Mutex) GetMethod(method string) BuiltinFunction {
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (m *Mutex) ToInterface() interface{} {
+	return m.Inspect()
+}
+
+// JSON returns a json-friendly string
+func (m *Mutex) JSON(indent bool) string {
+	return m.Inspect()
+}
+
+// WaitGroup waits for a number of things to be done, like a
+// sync.WaitGroup, except that waiting for it can be cancelled. Its
+// methods are the evaluator's.
+type WaitGroup struct {
+	mu   sync.Mutex
+	n    int64
+	zero chan struct{}
+}
+
+// NewWaitGroup returns a wait group with nothing to wait for.
+func NewWaitGroup() *WaitGroup {
+	zero := make(chan struct{})
+	close(zero)
+	return &WaitGroup{zero: zero}
+}
+
+// Add adds delta, which may be negative, to the count, returning the new
+// count. The count can't go below zero; it's left alone and false is
+// returned instead.
+func (wg *WaitGroup) Add(delta int64) (int64, bool) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	n := wg.n + delta
+	switch {
+	case n < 0:
+		return wg.n, false
+	case n == 0 && wg.n > 0:
+		close(wg.zero)
+	case n > 0 && wg.n == 0:
+		wg.zero = make(chan struct{})
+	}
+	wg.n = n
+	return n, true
+}
+
+// Zero returns a channel which is closed once the count is zero.
+func (wg *WaitGroup) Zero() <-chan struct{} {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	return wg.zero
+}
+
+// Count returns how many things are left to be done.
+func (wg *WaitGroup) Count() int64 {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	return wg.n
+}
+
+// Type returns the type of this object.
+func (wg *WaitGroup) Type() Type {
+	return WAIT_GROUP_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (wg *WaitGroup) Inspect() string {
+	return fmt.Sprintf("<wait_group:%d>", wg.Count())
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (wg *WaitGroup) GetMethod(method string) BuiltinFunction {
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (wg *WaitGroup) ToInterface() interface{} {
+	return wg.Inspect()
+}
+
+// JSON returns a json-friendly string
+func (wg *WaitGroup) JSON(indent bool) string {
+	return wg.Inspect()
+}
+
+// Atomic is an integer which can be updated from several functions at
+// once without losing updates. Its methods are the evaluator's.
+type Atomic struct {
+	n int64
+}
+
+// NewAtomic returns an atomic integer set to n.
+func NewAtomic(n int64) *Atomic {
+	return &Atomic{n: n}
+}
+
+// Add adds delta, returning the new value.
+func (a *Atomic) Add(delta int64) int64 {
+	return atomic.AddInt64(&a.n, delta)
+}
+
+// Get returns the value.
+func (a *Atomic) Get() int64 {
+	return atomic.LoadInt64(&a.n)
+}
+
+// Set sets the value.
+func (a *Atomic) Set(n int64) {
+	atomic.StoreInt64(&a.n, n)
+}
+
+// CompareAndSwap sets the value to new if it's old, reporting whether it
+// was.
+func (a *Atomic) CompareAndSwap(old, new int64) bool {
+	return atomic.CompareAndSwapInt64(&a.n, old, new)
+}
+
+// Type returns the type of this object.
+func (a *Atomic) Type() Type {
+	return ATOMIC_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (a *Atomic) Inspect() string {
+	return fmt.Sprintf("<atomic:%d>", a.Get())
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (a *Atomic) GetMethod(method string) BuiltinFunction {
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (a *Atomic) ToInterface() interface{} {
+	return a.Get()
+}
+
+// JSON returns a json-friendly string
+func (a *Atomic) JSON(indent bool) string {
+	return fmt.Sprint(a.Get())
+}
+
+// Once calls a function the first time it's asked to, and returns what it
+// returned every time after that. Its methods are the evaluator's.
+type Once struct {
+	once   sync.Once
+	Fn     Object
+	result Object
+}
+
+// NewOnce returns a Once for a function.
+func NewOnce(fn Object) *Once {
+	return &Once{Fn: fn}
+}
+
+// Do calls call the first time, and returns what it returned. If another
+// function is calling it, it waits for it to return.
+func (o *Once) Do(call func() Object) Object {
+	o.once.Do(func() {
+		o.result = call()
+	})
+	return o.result
+}
+
+// Type returns the type of this object.
+func (o *Once) Type() Type {
+	return ONCE_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (o *Once) Inspect() string {
+	return "<once>"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (o *Once) GetMethod(method string) BuiltinFunction {
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (o *Once) ToInterface() interface{} {
+	return o.Inspect()
+}
+
+// JSON returns a json-friendly string
+func (o *Once) JSON(indent bool) string {
+	return o.Inspect()
+}
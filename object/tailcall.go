@@ -0,0 +1,40 @@
+package object
+
+// TailCall is returned from evaluating a call in tail position, the last
+// thing a function does, so the function calling it can return before
+// it's made. This keeps the stack from growing with recursion.
+type TailCall struct {
+	// Fn is the function to call.
+	Fn *Function
+
+	// Args are the arguments to call it with.
+	Args []Object
+}
+
+// Type returns the type of this object.
+func (t *TailCall) Type() Type {
+	return TAIL_CALL_OBJ
+}
+
+// Inspect returns a string-representation of the given object.
+func (t *TailCall) Inspect() string {
+	return "tail call"
+}
+
+// GetMethod returns a method against the object.
+// (Built-in methods only.)
+func (t *TailCall) GetMethod(string) BuiltinFunction {
+	// There are no methods available upon a tail call.
+	return nil
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (t *TailCall) ToInterface() interface{} {
+	return "<TAIL_CALL>"
+}
+
+// JSON returns a json-friendly string
+func (t *TailCall) JSON(indent bool) string {
+	return t.Inspect()
+}
@@ -3,6 +3,7 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -11,7 +12,6 @@ import (
 	"github.com/zacanger/cozy/ast"
 	"github.com/zacanger/cozy/lexer"
 	"github.com/zacanger/cozy/token"
-	"github.com/zacanger/cozy/utils"
 )
 
 // prefix Parse function
@@ -98,7 +98,20 @@ type Parser struct {
 	peekToken token.Token
 
 	// errors holds parsing-errors.
-	errors []string
+	errors []*ParseError
+
+	// panicking is set once an error has been seen in the current
+	// statement, and is used to suppress the errors which cascade from
+	// it until we resynchronize at the next statement.
+	panicking bool
+
+	// loops holds the labels of the loops enclosing the current
+	// position, innermost last; unlabeled loops have an empty label.
+	// Used to validate break and continue.
+	loops []string
+
+	// label holds the label just parsed, waiting for its loop.
+	label string
 
 	// prefixParseFns holds a map of parsing methods for
 	// prefix-based syntax.
@@ -116,7 +129,7 @@ type Parser struct {
 // New returns our new parser-object.
 func New(l *lexer.Lexer) *Parser {
 	// Create the parser, and prime the pump
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []*ParseError{}}
 	p.nextToken()
 	p.nextToken()
 
@@ -132,8 +145,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.IF, p.parseIfExpression)
-	p.registerPrefix(token.ILLEGAL, p.parsingBroken)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.INT, p.ParseIntegerLiteral)
+	p.registerPrefix(token.INTERPOLATED, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACE, p.ParseHashLiteral)
 	p.registerPrefix(token.LBRACKET, p.ParseArrayLiteral)
 	p.registerPrefix(token.CURRENT_ARGS, p.parseCurrentArgsLiteral)
@@ -144,6 +158,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.STRING, p.ParseStringLiteral)
 	p.registerPrefix(token.DOCSTRING, p.parseDocStringLiteral)
 	p.registerPrefix(token.TRUE, p.ParseBoolean)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 
 	// Register infix functions
@@ -165,7 +180,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MOD, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
-	p.registerInfix(token.PERIOD, p.parseIndexDotExpression)
+	p.registerInfix(token.PERIOD, p.parseMemberExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.PLUS_EQUALS, p.parseAssignExpression)
 	p.registerInfix(token.POW, p.parseInfixExpression)
@@ -201,20 +216,79 @@ func (p *Parser) registerPostfix(tokenType token.Type, fn postfixParseFn) {
 	p.postfixParseFns[tokenType] = fn
 }
 
+// ParseError describes a single syntax error.
+type ParseError struct {
+	// Pos is where the error was found.
+	Pos token.Position `json:"pos"`
+
+	// Expected is the token type we wanted, if there was a
+	// particular one.
+	Expected token.Type `json:"expected,omitempty"`
+
+	// Got is the token type we found instead.
+	Got token.Type `json:"got"`
+
+	// Message describes the error.
+	Message string `json:"message"`
+}
+
+// Error returns the error in file:line:column: message form.
+func (e *ParseError) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
 // Errors return stored errors
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []*ParseError {
 	return p.errors
 }
 
+// addError records an error, unless we're still recovering from an
+// earlier one in the same statement.
+func (p *Parser) addError(err *ParseError) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.errors = append(p.errors, err)
+}
+
+// errorf records an error at the given token.
+func (p *Parser) errorf(tok token.Token, format string, a ...interface{}) {
+	p.addError(&ParseError{
+		Pos:     tok.Pos,
+		Got:     tok.Type,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
 // peekError raises an error if the next token is not the expected type.
 func (p *Parser) peekError(t token.Type) {
-	msg := fmt.Sprintf(
-		"expected next token to be %s, got %s instead around line %d",
-		t,
-		p.curToken.Type,
-		p.l.GetLine(),
-	)
-	p.errors = append(p.errors, msg)
+	p.addError(&ParseError{
+		Pos:      p.peekToken.Pos,
+		Expected: t,
+		Got:      p.peekToken.Type,
+		Message: fmt.Sprintf(
+			"expected next token to be %s, got %s instead",
+			t,
+			p.peekToken.Type,
+		),
+	})
+}
+
+// synchronize skips tokens after an error until we reach something
+// that looks like a statement boundary: a semicolon, the start of a
+// let/mutable/return statement, the end of the enclosing block, or
+// the end of the input. This lets us carry on and report later errors
+// rather than the ones that cascade from the first.
+func (p *Parser) synchronize() {
+	p.panicking = false
+	for !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.EOF) {
+		switch p.peekToken.Type {
+		case token.LET, token.MUTABLE, token.RETURN, token.RBRACE, token.EOF:
+			return
+		}
+		p.nextToken()
+	}
 }
 
 // nextToken moves to our next token from the lexer.
@@ -230,7 +304,11 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 	for p.curToken.Type != token.EOF {
 		stmt := p.parseStatement()
-		program.Statements = append(program.Statements, stmt)
+		if p.panicking {
+			p.synchronize()
+		} else {
+			program.Statements = append(program.Statements, stmt)
+		}
 		p.nextToken()
 	}
 	return program
@@ -245,18 +323,116 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.IDENT:
+		if p.peekTokenIs(token.COLON) {
+			return p.parseLabeledStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
+// parseBreakStatement parses a break-statement.
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	stmt.Label = p.parseLoopControlLabel()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseContinueStatement parses a continue-statement.
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	stmt.Label = p.parseLoopControlLabel()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseLoopControlLabel reads the optional label after `break` or
+// `continue`, and checks there's a loop for it to apply to. The label
+// has to be on the same line as the keyword, so that a bare `break`
+// doesn't swallow the next statement.
+func (p *Parser) parseLoopControlLabel() string {
+	tok := p.curToken
+	label := ""
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Pos.Line == tok.Pos.Line {
+		p.nextToken()
+		label = p.curToken.Literal
+	}
+
+	if len(p.loops) == 0 {
+		p.errorf(tok, "%s outside of a loop", tok.Literal)
+		return label
+	}
+
+	if label != "" {
+		for _, l := range p.loops {
+			if l == label {
+				return label
+			}
+		}
+		p.errorf(p.curToken, "unknown loop label %s", label)
+	}
+
+	return label
+}
+
+// parseLabeledStatement parses `label: for ...` and `label: foreach ...`.
+func (p *Parser) parseLabeledStatement() ast.Statement {
+	label := p.curToken
+	p.nextToken()
+
+	if !p.peekTokenIs(token.FOR) && !p.peekTokenIs(token.FOREACH) {
+		p.addError(&ParseError{
+			Pos:      p.peekToken.Pos,
+			Expected: token.FOR,
+			Got:      p.peekToken.Type,
+			Message: fmt.Sprintf(
+				"label %s must be followed by a loop, got %s instead",
+				label.Literal,
+				p.peekToken.Type,
+			),
+		})
+		return nil
+	}
+	p.nextToken()
+
+	p.label = label.Literal
+	return p.parseExpressionStatement()
+}
+
+// takeLabel returns the label waiting for the loop being parsed, if
+// any, and clears it.
+func (p *Parser) takeLabel() string {
+	label := p.label
+	p.label = ""
+	return label
+}
+
 // parseMutableStatement parses a mutable-statement.
 func (p *Parser) parseMutableStatement() *ast.MutableStatement {
 	stmt := &ast.MutableStatement{Token: p.curToken}
-	if !p.expectPeek(token.IDENT) {
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		if stmt.Pattern = p.parsePattern(); stmt.Pattern == nil {
+			return nil
+		}
+	} else if stmt.Name = p.parseName(); stmt.Name == nil {
 		return nil
 	}
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -273,10 +449,14 @@ func (p *Parser) parseMutableStatement() *ast.MutableStatement {
 // parseLetStatement parses a let (constant) declaration.
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
-	if !p.expectPeek(token.IDENT) {
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		if stmt.Pattern = p.parsePattern(); stmt.Pattern == nil {
+			return nil
+		}
+	} else if stmt.Name = p.parseName(); stmt.Name == nil {
 		return nil
 	}
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -290,6 +470,77 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// parseName parses the name being bound by let or mutable, which may have
+// dots in it, like http.constants; the standard library is defined that
+// way.
+func (p *Parser) parseName() *ast.Identifier {
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	for p.peekTokenIs(token.PERIOD) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		name.Value += "." + p.curToken.Literal
+	}
+	name.Token.Literal = name.Value
+	return name
+}
+
+// parsePattern parses the left side of a destructuring binding, like
+// [a, b = 2, ...rest] or {name, port = 80}, starting at its bracket or
+// brace.
+func (p *Parser) parsePattern() *ast.Pattern {
+	pattern := &ast.Pattern{
+		Token:    p.curToken,
+		Defaults: make(map[string]ast.Expression),
+	}
+	var end token.Type = token.RBRACKET
+	if pattern.IsHash() {
+		end = token.RBRACE
+	}
+
+	for !p.peekTokenIs(end) {
+		if pattern.Rest != nil {
+			p.errorf(p.peekToken, "...%s must be the last thing in the pattern", pattern.Rest.Value)
+			return nil
+		}
+		p.nextToken()
+
+		rest := p.curTokenIs(token.CURRENT_ARGS) || p.curTokenIs(token.SPREAD)
+		if rest {
+			if pattern.IsHash() {
+				p.errorf(p.curToken, "hash patterns can't have a rest")
+				return nil
+			}
+			p.nextToken()
+		}
+		if !p.curTokenIs(token.IDENT) {
+			p.errorf(p.curToken, "expected a name in the pattern, got %s", p.curToken.Type)
+			return nil
+		}
+		name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if rest {
+			pattern.Rest = name
+		} else {
+			pattern.Names = append(pattern.Names, name)
+		}
+
+		if !rest && p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			pattern.Defaults[name.Value] = p.parseExpression(LOWEST)
+		}
+		if !p.peekTokenIs(end) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+	return pattern
+}
+
 // parseReturnStatement parses a return-statement.
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
@@ -305,12 +556,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 // no prefix parse function error
 func (p *Parser) noPrefixParseFnError(t token.Type) {
-	msg := fmt.Sprintf(
-		"no prefix parse function for %s found around line %d",
-		t,
-		p.l.GetLine(),
-	)
-	p.errors = append(p.errors, msg)
+	p.errorf(p.curToken, "no prefix parse function for %s found", t)
 }
 
 // parse Expression Statement
@@ -353,6 +599,12 @@ func (p *Parser) parsingBroken() ast.Expression {
 	return nil
 }
 
+// parseIllegal reports what the lexer found wrong with a token.
+func (p *Parser) parseIllegal() ast.Expression {
+	p.errorf(p.curToken, "%s", p.curToken.Literal)
+	return nil
+}
+
 // parseIdentifier parses an identifier.
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
@@ -362,24 +614,25 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) ParseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
+	// Literals with a base prefix are the same as Go's, but a leading
+	// zero doesn't make a literal octal.
 	var value int64
 	var err error
-
-	if strings.HasPrefix(p.curToken.Literal, "0b") {
-		value, err = strconv.ParseInt(p.curToken.Literal[2:], 2, 64)
-	} else if strings.HasPrefix(p.curToken.Literal, "0x") {
-		value, err = strconv.ParseInt(p.curToken.Literal[2:], 16, 64)
+	literal := p.curToken.Literal
+	if len(literal) > 1 && literal[0] == '0' && strings.ContainsRune("xXoObB", rune(literal[1])) {
+		value, err = strconv.ParseInt(literal, 0, 64)
+	} else if !underscoresOK(literal) {
+		err = strconv.ErrSyntax
 	} else {
-		value, err = strconv.ParseInt(p.curToken.Literal, 10, 64)
+		value, err = strconv.ParseInt(strings.ReplaceAll(literal, "_", ""), 10, 64)
 	}
 
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorf(p.curToken, "integer %s is too big; integers are 64-bit", literal)
+		return nil
+	}
 	if err != nil {
-		msg := fmt.Sprintf(
-			"could not parse %q as integer around line %d",
-			p.curToken.Literal,
-			p.l.GetLine(),
-		)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken, "could not parse %q as integer", literal)
 		return nil
 	}
 	lit.Value = value
@@ -390,19 +643,30 @@ func (p *Parser) ParseIntegerLiteral() ast.Expression {
 func (p *Parser) ParseFloatLiteral() ast.Expression {
 	flo := &ast.FloatLiteral{Token: p.curToken}
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		p.errorf(p.curToken, "float %s is out of range", p.curToken.Literal)
+		return nil
+	}
 	if err != nil {
-		msg := fmt.Sprintf(
-			"could not parse %q as float around line %d",
-			p.curToken.Literal,
-			p.l.GetLine(),
-		)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	flo.Value = value
 	return flo
 }
 
+// underscoresOK reports whether every underscore in a decimal literal is
+// between two digits.
+func underscoresOK(literal string) bool {
+	for i, ch := range literal {
+		if ch == '_' && (i == 0 || i == len(literal)-1 ||
+			literal[i-1] == '_' || literal[i+1] == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 // ParseBoolean parses a boolean token.
 func (p *Parser) ParseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
@@ -464,7 +728,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 	//lint:ignore SA4031 this actually _can_ be nil sometimes
 	if expression == nil {
-		p.errors = append(p.errors, "unexpected nil expression")
+		p.errorf(p.curToken, "unexpected nil expression")
 		return nil
 	}
 
@@ -482,7 +746,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		expression.Consequence = p.parseBlockStatement()
 	}
 	if expression.Consequence == nil {
-		p.errors = append(p.errors, "unexpected nil expression")
+		p.errorf(p.curToken, "unexpected nil expression")
 		return nil
 	}
 
@@ -494,8 +758,10 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		if p.peekTokenIs(token.IF) {
 			p.nextToken()
 			expression.Alternative = &ast.BlockStatement{
+				Token: p.curToken,
 				Statements: []ast.Statement{
 					&ast.ExpressionStatement{
+						Token:      p.curToken,
 						Expression: p.parseIfExpression(),
 					},
 				},
@@ -512,7 +778,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		}
 
 		if expression.Alternative == nil {
-			p.errors = append(p.errors, "unexpected nil expression")
+			p.errorf(p.curToken, "unexpected nil expression")
 			return nil
 		}
 	}
@@ -542,8 +808,7 @@ func (p *Parser) parseBracketExpression() ast.Expression {
 	// if we started with parens...
 	if usingParens {
 		if !p.expectPeek(token.RPAREN) {
-			msg := fmt.Sprintf("expected ')' but got %s", p.curToken.Literal)
-			p.errors = append(p.errors, msg)
+			p.errorf(p.curToken, "expected ')' but got %s", p.curToken.Literal)
 			return nil
 		}
 	}
@@ -559,6 +824,7 @@ func (p *Parser) parseBracketExpression() ast.Expression {
 // parseForLoopExpression parses a for-loop.
 func (p *Parser) parseForLoopExpression() ast.Expression {
 	expression := &ast.ForLoopExpression{Token: p.curToken}
+	expression.Label = p.takeLabel()
 	usingParens := false
 
 	// see if we're using parens
@@ -581,22 +847,27 @@ func (p *Parser) parseForLoopExpression() ast.Expression {
 		p.nextToken()
 	}
 
+	p.loops = append(p.loops, expression.Label)
 	if p.peekTokenIs(token.LBRACE) {
 		p.nextToken()
 		expression.Consequence = p.parseBlockStatement()
 	} else {
 		expression.Consequence = p.parseBlockStatementWithoutBraces()
 	}
+	p.loops = p.loops[:len(p.loops)-1]
 	return expression
 }
 
 // parseForEach parses 'foreach x X { .. block .. }`
 func (p *Parser) parseForEach() ast.Expression {
 	expression := &ast.ForeachStatement{Token: p.curToken}
+	expression.Label = p.takeLabel()
 
 	// get the id
 	p.nextToken()
-	expression.Ident = p.curToken.Literal
+	if !p.parseForEachItem(expression) {
+		return nil
+	}
 
 	// If we find a "," we then get a second identifier too.
 	if p.peekTokenIs(token.COMMA) {
@@ -608,22 +879,26 @@ func (p *Parser) parseForEach() ast.Expression {
 		// skip the comma
 		p.nextToken()
 
-		if !p.peekTokenIs(token.IDENT) {
-			p.errors = append(
-				p.errors,
-				fmt.Sprintf(
-					"second argument to foreach must be ident, got %v",
-					p.peekToken,
-				),
+		if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.LBRACKET) &&
+			!p.peekTokenIs(token.LBRACE) {
+			p.errorf(
+				p.peekToken,
+				"second argument to foreach must be ident, got %s",
+				p.peekToken.Type,
 			)
 			return nil
 		}
+		if expression.Pattern != nil {
+			p.errorf(expression.Pattern.Token, "the index of foreach can't be a pattern")
+			return nil
+		}
 		p.nextToken()
 
 		// Record the updated values.
 		expression.Index = expression.Ident
-		expression.Ident = p.curToken.Literal
-
+		if !p.parseForEachItem(expression) {
+			return nil
+		}
 	}
 
 	// The next token, after the ident(s), should be `in`.
@@ -639,12 +914,96 @@ func (p *Parser) parseForEach() ast.Expression {
 	}
 
 	// parse the block
+	p.loops = append(p.loops, expression.Label)
 	if p.peekTokenIs(token.LBRACE) {
 		p.nextToken()
 		expression.Body = p.parseBlockStatement()
 	} else {
 		expression.Body = p.parseBlockStatementWithoutBraces()
 	}
+	p.loops = p.loops[:len(p.loops)-1]
+
+	return expression
+}
+
+// parseForEachItem parses the name foreach sets with each item, or a
+// pattern to unpack it into.
+func (p *Parser) parseForEachItem(expression *ast.ForeachStatement) bool {
+	expression.Pattern = nil
+	if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+		expression.Pattern = p.parsePattern()
+		if expression.Pattern == nil {
+			return false
+		}
+		expression.Ident = expression.Pattern.String()
+		return true
+	}
+	expression.Ident = p.curToken.Literal
+	return true
+}
+
+// parseTryExpression parses `try { } catch e { } finally { }`. At least
+// one of the catch or finally blocks is required, and the name in the
+// catch block is optional (and may be in parens).
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+	if expression.Block == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		usingParens := false
+		if p.peekTokenIs(token.LPAREN) {
+			usingParens = true
+			p.nextToken()
+		}
+		if p.peekTokenIs(token.IDENT) {
+			p.nextToken()
+			expression.CatchIdent = &ast.Identifier{
+				Token: p.curToken,
+				Value: p.curToken.Literal,
+			}
+		}
+		if usingParens && !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+		if expression.Catch == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+		if expression.Finally == nil {
+			return nil
+		}
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.addError(&ParseError{
+			Pos:      p.peekToken.Pos,
+			Expected: token.CATCH,
+			Got:      p.peekToken.Type,
+			Message:  "try needs a catch or finally block",
+		})
+		return nil
+	}
 
 	return expression
 }
@@ -685,13 +1044,21 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	for !p.curTokenIs(token.RBRACE) {
 		// Don't loop forever
 		if p.curTokenIs(token.EOF) {
-			p.errors = append(p.errors,
-				"unterminated block statement")
+			p.addError(&ParseError{
+				Pos:      block.Token.Pos,
+				Expected: token.RBRACE,
+				Got:      token.EOF,
+				Message:  "unterminated block statement",
+			})
 			return nil
 		}
 
 		stmt := p.parseStatement()
-		block.Statements = append(block.Statements, stmt)
+		if p.panicking {
+			p.synchronize()
+		} else {
+			block.Statements = append(block.Statements, stmt)
+		}
 		p.nextToken()
 	}
 	return block
@@ -703,7 +1070,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	lit.Defaults, lit.Parameters = p.parseFunctionParameters()
+	lit.Defaults, lit.Parameters, lit.Patterns = p.parseFunctionParameters()
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -714,7 +1081,12 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 			lit.DocString = a
 		}
 	}
+
+	// break and continue can't reach loops outside of the function
+	loops := p.loops
+	p.loops = nil
 	lit.Body = p.parseBlockStatement()
+	p.loops = loops
 	return lit
 }
 
@@ -735,6 +1107,7 @@ func (p *Parser) parseSpreadLiteral() ast.Expression {
 func (p *Parser) parseFunctionParameters() (
 	map[string]ast.Expression,
 	[]*ast.Identifier,
+	map[string]*ast.Pattern,
 ) {
 	// Any default parameters.
 	m := make(map[string]ast.Expression)
@@ -742,22 +1115,33 @@ func (p *Parser) parseFunctionParameters() (
 	// The argument-definitions.
 	identifiers := make([]*ast.Identifier, 0)
 
+	// Any parameters which are unpacked.
+	patterns := make(map[string]*ast.Pattern)
+
 	// Is the next parameter ")" ?  If so we're done. No args.
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return m, identifiers
+		return m, identifiers, patterns
 	}
 	p.nextToken()
 
 	// Keep going until we find a ")"
 	for !p.curTokenIs(token.RPAREN) {
 		if p.curTokenIs(token.EOF) {
-			p.errors = append(p.errors, "unterminated function parameters")
-			return nil, nil
+			p.errorf(p.curToken, "unterminated function parameters")
+			return nil, nil, nil
 		}
 
-		// Get the identifier.
+		// Get the identifier, which for a pattern is how it's written.
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+			pattern := p.parsePattern()
+			if pattern == nil {
+				return nil, nil, nil
+			}
+			ident.Value = pattern.String()
+			patterns[ident.Value] = pattern
+		}
 		identifiers = append(identifiers, ident)
 		p.nextToken()
 
@@ -776,7 +1160,7 @@ func (p *Parser) parseFunctionParameters() (
 		}
 	}
 
-	return m, identifiers
+	return m, identifiers, patterns
 }
 
 // ParseStringLiteral parses a string-literal.
@@ -784,6 +1168,45 @@ func (p *Parser) ParseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseInterpolatedString parses a string with {{ }} interpolations in
+// it, each of whose expressions is parsed where it is in the source.
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+	for _, part := range p.curToken.Parts {
+		if !part.IsExpr {
+			if part.Text != "" {
+				str.Parts = append(str.Parts,
+					&ast.StringLiteral{Token: p.curToken, Value: part.Text})
+			}
+			continue
+		}
+
+		exp, errs := ParseInterpolation(part)
+		if len(errs) > 0 {
+			p.addError(errs[0])
+			return nil
+		}
+		str.Parts = append(str.Parts, exp)
+	}
+	return str
+}
+
+// ParseInterpolation parses the expression in part of an interpolated
+// string, or of a template.
+func ParseInterpolation(part token.Part) (ast.Expression, []*ParseError) {
+	p := New(lexer.NewInterpolation(part))
+	p.registerPrefix(token.EOF, func() ast.Expression {
+		p.errorf(p.curToken, "expected an expression before }}")
+		return nil
+	})
+	exp := p.parseExpression(LOWEST)
+	if len(p.errors) == 0 && !p.peekTokenIs(token.EOF) {
+		p.errorf(p.peekToken,
+			"expected }} after the interpolated expression, got %s", p.peekToken.Type)
+	}
+	return exp, p.errors
+}
+
 // parseDocStringLiteral parses a docstring-literal.
 func (p *Parser) parseDocStringLiteral() ast.Expression {
 	p.nextToken()
@@ -838,12 +1261,15 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	if n, ok := name.(*ast.Identifier); ok {
 		stmt.Name = n
 	} else {
-		msg := fmt.Sprintf(
-			"expected assign token to be IDENT, got %s instead around line %d",
-			name.TokenLiteral(),
-			p.l.GetLine(),
-		)
-		p.errors = append(p.errors, msg)
+		p.addError(&ParseError{
+			Pos:      name.Pos(),
+			Expected: token.IDENT,
+			Got:      p.prevToken.Type,
+			Message: fmt.Sprintf(
+				"expected assign token to be IDENT, got %s instead",
+				name.TokenLiteral(),
+			),
+		})
 	}
 
 	oper := p.curToken
@@ -900,22 +1326,14 @@ func (p *Parser) ParseHashLiteral() ast.Expression {
 	return hash
 }
 
-// parseIndexDotExpression parses an index with DOT separator.
-func (p *Parser) parseIndexDotExpression(obj ast.Expression) ast.Expression {
-	curToken := p.curToken
-	p.nextToken()
-	name := p.parseIdentifier()
-	return &ast.IndexExpression{
-		Token: curToken,
-		Left:  obj,
-		Index: &ast.StringLiteral{
-			Token: token.Token{
-				Type:    token.IDENT,
-				Literal: name.TokenLiteral(),
-			},
-			Value: name.String(),
-		},
+// parseMemberExpression parses a dot access, like a.b.
+func (p *Parser) parseMemberExpression(obj ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.curToken, Object: obj}
+	if !p.expectPeek(token.IDENT) {
+		return nil
 	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
 }
 
 // curTokenIs tests if the current token has the given type.
@@ -958,24 +1376,22 @@ func (p *Parser) curPrecedence() int {
 
 // ParserErrorsParams is used in main and repl
 type ParserErrorsParams struct {
-	Errors []string
+	Errors []*ParseError
 	Out    io.Writer
 }
 
-// PrintParserErrors prints parser errors
+// PrintParserErrors prints parser errors. It doesn't exit; what to do
+// next is up to the caller.
 func PrintParserErrors(arg ParserErrorsParams) {
 	if arg.Out != nil {
 		io.WriteString(arg.Out, "ERROR!\n")
 		io.WriteString(arg.Out, " parser errors:\n")
-		for _, msg := range arg.Errors {
-			io.WriteString(arg.Out, "\t"+msg+"\n")
+		for _, err := range arg.Errors {
+			io.WriteString(arg.Out, "\t"+err.Error()+"\n")
 		}
-		// we're in a repl, so don't do anything else
 	} else {
-		for _, msg := range arg.Errors {
-			fmt.Printf("\t%s\n", msg)
+		for _, err := range arg.Errors {
+			fmt.Printf("\t%s\n", err.Error())
 		}
-		// we're not in a repl, so exit
-		utils.ExitConditionally(1)
 	}
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/zacanger/cozy/ast"
 	"github.com/zacanger/cozy/lexer"
+	"github.com/zacanger/cozy/token"
 )
 
 func TestMutableStatements(t *testing.T) {
@@ -73,6 +74,7 @@ func TestLetStatements(t *testing.T) {
 		{"let y = true;", "y", true},
 		{"let foobar=y;", "foobar", "y"},
 		{"let baz = quux", "baz", "quux"},
+		{"let http.constants = 1", "http.constants", 1},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -221,6 +223,125 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1_000_000", 1000000},
+		{"0755", 755},
+		{"0o755", 0o755},
+		{"0xFF", 255},
+		{"0b1010", 10},
+		{"0x_ff_ff", 0xffff},
+		{"9223372036854775807", 9223372036854775807},
+		{"1.5e-3", 1.5e-3},
+		{".5", 0.5},
+		{"1_000.25", 1000.25},
+		{"2E3", 2000.0},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		var value interface{}
+		switch lit := program.Statements[0].(*ast.ExpressionStatement).Expression.(type) {
+		case *ast.IntegerLiteral:
+			value = int(lit.Value)
+		case *ast.FloatLiteral:
+			value = lit.Value
+		}
+		if value != tt.expected {
+			t.Errorf("%s: wrong value. expected=%v, got=%v", tt.input, tt.expected, value)
+		}
+	}
+}
+
+func TestBadNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 9223372036854775808", "1:9: integer 9223372036854775808 is too big; integers are 64-bit"},
+		{"let x = 0xffffffffffffffff", "1:9: integer 0xffffffffffffffff is too big; integers are 64-bit"},
+		{"let x = 1\nlet y = 1e400", "2:9: float 1e400 is out of range"},
+		{"let x = 0b102", `1:9: could not parse "0b102" as integer`},
+		{"let x = 1__000", `1:9: could not parse "1__000" as integer`},
+		{"let x = 1_", `1:9: could not parse "1_" as integer`},
+		{"let x = 1_.5", `1:9: could not parse "1_.5" as float`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.errors) != 1 {
+			t.Errorf("%s: expected 1 error, got=%v", tt.input, p.errors)
+			continue
+		}
+		if p.errors[0].Error() != tt.expected {
+			t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expected, p.errors[0].Error())
+		}
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b, ...rest] = xs", "let [a, b, ...rest] = xs;"},
+		{"let {name, port = 80} = config", "let {name, port = 80} = config;"},
+		{"mutable [a, b = a + 1] = xs", "mutable [a, b = (a + 1)] = xs;"},
+		{"let [] = xs", "let [] = xs;"},
+		{"foreach [k, v] in pairs { k }", "foreach [k, v] pairsk"},
+		{"foreach i, {name} in xs { i }", "foreach {name} xsi"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New("fn ([a, b], {c} = {}) { a }"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	fl := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(fl.Parameters) != 2 || fl.Parameters[0].Value != "[a, b]" || fl.Parameters[1].Value != "{c}" {
+		t.Fatalf("wrong parameters. got=%v", fl.Parameters)
+	}
+	if fl.Patterns["[a, b]"] == nil || fl.Patterns["{c}"] == nil {
+		t.Errorf("missing patterns. got=%v", fl.Patterns)
+	}
+	if _, ok := fl.Defaults["{c}"]; !ok {
+		t.Errorf("missing default for {c}. got=%v", fl.Defaults)
+	}
+}
+
+func TestBadPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, ...b, c] = xs", "1:15: ...b must be the last thing in the pattern"},
+		{"let {a, ...b} = xs", "1:9: hash patterns can't have a rest"},
+		{"let [1] = xs", "1:6: expected a name in the pattern, got INT"},
+		{"foreach [i], x in xs {}", "1:9: the index of foreach can't be a pattern"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.errors) == 0 {
+			t.Errorf("%s: expected an error", tt.input)
+			continue
+		}
+		if p.errors[0].Error() != tt.expected {
+			t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expected, p.errors[0].Error())
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	boolTests := []struct {
 		input     string
@@ -455,6 +576,19 @@ func testBooleanLiteral(t *testing.T, exp ast.Expression, value bool) bool {
 	return true
 }
 
+func testStringLiteral(t *testing.T, exp ast.Expression, value string) bool {
+	str, ok := exp.(*ast.StringLiteral)
+	if !ok {
+		t.Errorf("exp not *ast.StringLiteral. got=%T", exp)
+		return false
+	}
+	if str.Value != value {
+		t.Errorf("str.Value not %q. got=%q", value, str.Value)
+		return false
+	}
+	return true
+}
+
 func testLiteralExpression(
 	t *testing.T,
 	exp ast.Expression,
@@ -690,8 +824,8 @@ func checkParserErrors(t *testing.T, p *Parser) {
 		return
 	}
 	t.Errorf("parser has %d errors", len(p.errors))
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, err := range errors {
+		t.Errorf("parser error: %q", err.Error())
 	}
 	t.FailNow()
 }
@@ -713,6 +847,58 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	input := `"a {{x + 1}} b {{ \"c {{y}}\" }}";`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	str, ok := stmt.Expression.(*ast.InterpolatedString)
+	if !ok {
+		t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+	}
+	if len(str.Parts) != 4 {
+		t.Fatalf("wrong number of parts. got=%d", len(str.Parts))
+	}
+	testStringLiteral(t, str.Parts[0], "a ")
+	testInfixExpression(t, str.Parts[1], "x", "+", 1)
+	testStringLiteral(t, str.Parts[2], " b ")
+	nested, ok := str.Parts[3].(*ast.InterpolatedString)
+	if !ok || len(nested.Parts) != 2 {
+		t.Fatalf("part 3 not a nested *ast.InterpolatedString. got=%s", str.Parts[3])
+	}
+	testIdentifier(t, nested.Parts[1], "y")
+}
+
+func TestInterpolationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a {{}}"`, "1:6: expected an expression before }}"},
+		{`"a {{ x + }}"`, "1:11: expected an expression before }}"},
+		{`"a {{ x y }}"`, "1:9: expected }} after the interpolated expression, got IDENT"},
+		{"let s = \"a\n  {{ b }}\n  {{ c(, 1) }}\"", "3:8: no prefix parse function for , found"},
+		{`"a {{ \"{{]}}\" }}"`, "1:11: no prefix parse function for ] found"},
+		{`"a {{ x"`, "1:4: unterminated interpolation"},
+		{`"a`, "1:1: unterminated string"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.errors) != 1 {
+			t.Errorf("%s: expected 1 error, got=%v", tt.input, p.errors)
+			continue
+		}
+		if p.errors[0].Error() != tt.expected {
+			t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expected, p.errors[0].Error())
+		}
+	}
+}
+
 func TestParsingArrayLiteral(t *testing.T) {
 	input := `[1, 2*2, 3+3]`
 	l := lexer.New(input)
@@ -850,13 +1036,48 @@ func TestFieldExpression(t *testing.T) {
 			len(program.Statements))
 	}
 	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
-	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	member, ok := stmt.Expression.(*ast.MemberExpression)
 	if !ok {
-		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+		t.Fatalf("exp not *ast.MemberExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, member.Object, "a") {
+		return
 	}
-	if !testIdentifier(t, indexExp.Left, "a") {
+	if !testIdentifier(t, member.Property, "b") {
 		return
 	}
+	if member.DottedName() != "a.b" {
+		t.Errorf("wrong dotted name. got=%q", member.DottedName())
+	}
+}
+
+func TestChainedFieldExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		dotted   string
+	}{
+		{"a.b.c", "((a.b).c)", "a.b.c"},
+		{"f().x.y", "((f().x).y)", ""},
+		{"obj.method().other()", "((obj.method)().other)()", ""},
+		{"a.b[0].c", "(((a.b)[0]).c)", ""},
+		{"-a.b", "(-(a.b))", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if exp.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, exp.String())
+		}
+		member, ok := exp.(*ast.MemberExpression)
+		if ok && member.DottedName() != tt.dotted {
+			t.Errorf("%q: wrong dotted name. expected=%q, got=%q",
+				tt.input, tt.dotted, member.DottedName())
+		}
+	}
 }
 
 // Test operators: +=, -=, /=, and *=.
@@ -906,8 +1127,193 @@ func TestIncompleThings(t *testing.T) {
 			t.Errorf("unexpected error-count, got %d  expected %d", len(p.errors), 1)
 		}
 
-		if !strings.Contains(p.errors[0], "unterminated") {
-			t.Errorf("Unexpected error-message %s\n", p.errors[0])
+		if !strings.Contains(p.errors[0].Message, "unterminated") {
+			t.Errorf("Unexpected error-message %s\n", p.errors[0].Message)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := "let x = 1;\nlet = 2;"
+	l := lexer.NewWithFile("main.cz", input)
+	p := New(l)
+	_ = p.ParseProgram()
+
+	if len(p.errors) < 1 {
+		t.Fatalf("expected a parser error")
+	}
+	if !strings.HasPrefix(p.errors[0].Error(), "main.cz:2:5: ") {
+		t.Errorf("error has wrong position: %q", p.errors[0].Error())
+	}
+}
+
+// Test that we resynchronize after an error, and report the errors
+// from later statements rather than a cascade from the first one.
+func TestErrorRecovery(t *testing.T) {
+	input := `let a = (1 + ;
+let b = 2;
+let c = fn() {
+	let d = ;
+	d
+};
+let e = 3`
+	l := lexer.NewWithFile("main.cz", input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expected := []string{
+		"main.cz:1:14: no prefix parse function for ; found",
+		"main.cz:4:10: no prefix parse function for ; found",
+	}
+	if len(p.Errors()) != len(expected) {
+		for _, err := range p.Errors() {
+			t.Logf("parser error: %q", err.Error())
 		}
+		t.Fatalf("wrong number of errors. expected=%d, got=%d",
+			len(expected), len(p.Errors()))
+	}
+	for i, err := range p.Errors() {
+		if err.Error() != expected[i] {
+			t.Errorf("errors[%d] wrong. expected=%q, got=%q",
+				i, expected[i], err.Error())
+		}
+	}
+
+	// The good statements are still parsed.
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d",
+			len(program.Statements))
+	}
+}
+
+func TestParseErrorFields(t *testing.T) {
+	l := lexer.NewWithFile("main.cz", "mutable x 5;")
+	p := New(l)
+	_ = p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("expected 1 error, got=%d", len(p.Errors()))
+	}
+	err := p.Errors()[0]
+	if err.Expected != token.ASSIGN {
+		t.Errorf("wrong expected token. got=%q", err.Expected)
+	}
+	if err.Got != token.INT {
+		t.Errorf("wrong got token. got=%q", err.Got)
+	}
+	if err.Pos.Line != 1 || err.Pos.Column != 11 {
+		t.Errorf("wrong position. got=%s", err.Pos)
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	input := `outer: foreach x in xs {
+	for (true) {
+		break outer
+		continue
+	}
+}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	loop, ok := stmt.Expression.(*ast.ForeachStatement)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.ForeachStatement. got=%T",
+			stmt.Expression)
+	}
+	if loop.Label != "outer" {
+		t.Errorf("loop.Label not 'outer'. got=%q", loop.Label)
+	}
+
+	inner := loop.Body.Statements[0].(*ast.ExpressionStatement).
+		Expression.(*ast.ForLoopExpression)
+	brk, ok := inner.Consequence.Statements[0].(*ast.BreakStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.BreakStatement. got=%T",
+			inner.Consequence.Statements[0])
+	}
+	if brk.Label != "outer" {
+		t.Errorf("brk.Label not 'outer'. got=%q", brk.Label)
+	}
+	cont, ok := inner.Consequence.Statements[1].(*ast.ContinueStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ContinueStatement. got=%T",
+			inner.Consequence.Statements[1])
+	}
+	if cont.Label != "" {
+		t.Errorf("cont.Label not empty. got=%q", cont.Label)
+	}
+}
+
+func TestBadBreakContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break", "break outside of a loop"},
+		{"continue;", "continue outside of a loop"},
+		{"for (true) { break nope }", "unknown loop label nope"},
+		{"for (true) { fn() { break } }", "break outside of a loop"},
+		{"outer: let x = 1", "label outer must be followed by a loop, got LET instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		_ = p.ParseProgram()
+
+		if len(p.Errors()) < 1 {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if p.Errors()[0].Message != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q",
+				tt.input, tt.expected, p.Errors()[0].Message)
+		}
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	input := `try { x } catch (e) { y } finally { z }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	te, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T",
+			stmt.Expression)
+	}
+	if te.CatchIdent == nil || te.CatchIdent.Value != "e" {
+		t.Errorf("te.CatchIdent not 'e'. got=%v", te.CatchIdent)
+	}
+	if te.Catch == nil || te.Finally == nil {
+		t.Fatalf("expected catch and finally blocks. got=%q", te.String())
+	}
+	if te.String() != "try {x} catch e {y} finally {z}" {
+		t.Errorf("te.String() wrong. got=%q", te.String())
+	}
+
+	for _, input := range []string{
+		"try { x } catch { y }",
+		"try { x } finally { z }",
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
+
+	p = New(lexer.New("try { x }"))
+	p.ParseProgram()
+	if len(p.Errors()) < 1 {
+		t.Fatalf("expected an error for try without catch or finally")
+	}
+	if p.Errors()[0].Message != "try needs a catch or finally block" {
+		t.Errorf("wrong error. got=%q", p.Errors()[0].Message)
 	}
 }
@@ -14,7 +14,6 @@ import (
 	"github.com/zacanger/cozy/lexer"
 	"github.com/zacanger/cozy/object"
 	"github.com/zacanger/cozy/parser"
-	"github.com/zacanger/cozy/utils"
 )
 
 func getHistorySize() int {
@@ -59,19 +58,25 @@ func getInitFile() string {
 	return string(s)
 }
 
-// Start runs the REPL
-func Start(in io.Reader, out io.Writer, stdlib string) {
-	// set so we don't os.Exit on errors
-	utils.SetReplOrRun(true)
-	env := object.NewEnvironment()
+// Start runs the REPL until it's closed, or the program calls sys.exit,
+// returning the exit code.
+func Start(in io.Reader, out io.Writer, stdlib string) int {
+	// programs can check whether they're running in the REPL
+	os.Setenv("COZY_RUNNING_IN_REPL", "true")
+	// set so mutable variables are allowed at the top level
+	rt := evaluator.NewRuntime()
+	rt.SetRepl(true)
+	env := rt.NewEnvironment()
 
 	// set up initial program with stdlib and optional init file
 	initConfig := getInitFile()
-	initLex := lexer.New(stdlib + "\n" + initConfig + "\n")
+	initLex := lexer.NewWithFile("<init>", stdlib+"\n"+initConfig+"\n")
 	initPars := parser.New(initLex)
 	initProg := initPars.ParseProgram()
 	// put the initial program in the env
-	evaluator.Eval(initProg, env)
+	if code, ok := exitCode(evaluator.Eval(initProg, env)); ok {
+		return code
+	}
 
 	l, err := readline.NewEx(&readline.Config{
 		Prompt:            "> ",
@@ -100,7 +105,7 @@ func Start(in io.Reader, out io.Writer, stdlib string) {
 		}
 
 		line = strings.TrimSpace(line)
-		lex := lexer.New(line)
+		lex := lexer.NewWithFile("<repl>", line)
 		p := parser.New(lex)
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
@@ -110,9 +115,24 @@ func Start(in io.Reader, out io.Writer, stdlib string) {
 			continue
 		}
 		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
+		if code, ok := exitCode(evaluated); ok {
+			return code
+		}
+		if e, ok := evaluated.(*object.Error); ok && !e.BuiltinCall {
+			io.WriteString(out, e.Trace())
+			io.WriteString(out, "\n")
+		} else if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
 		}
 	}
+	return 0
+}
+
+// exitCode returns the code sys.exit was called with, if it was.
+func exitCode(obj object.Object) (int, bool) {
+	if e, ok := obj.(*object.Error); ok && e.Exit {
+		return *e.Code, true
+	}
+	return 0, false
 }
@@ -0,0 +1,57 @@
+// Package stdlib embeds the parts of the standard library which are
+// written in cozy itself.
+package stdlib
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+//go:embed *.cz
+var stdlibFs embed.FS
+
+// File is a single named file from the stdlib.
+type File struct {
+	Name   string
+	Source string
+}
+
+// Files returns every file in the stdlib, in the order they should be
+// evaluated.
+func Files() []File {
+	var files []File
+	fs.WalkDir(
+		stdlibFs,
+		".",
+		func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasSuffix(p, ".cz") {
+				c, err := stdlibFs.ReadFile(p)
+				if err != nil {
+					return err
+				}
+				files = append(files, File{
+					Name:   path.Join("stdlib", p),
+					Source: string(c),
+				})
+			}
+
+			return nil
+		})
+
+	return files
+}
+
+// String returns the whole stdlib as one string.
+func String() string {
+	s := ""
+	for _, f := range Files() {
+		s += f.Source
+		s += "\n"
+	}
+	return s
+}
@@ -2,13 +2,69 @@
 // written in the cozy language, as done by the parser.
 package token
 
+import "fmt"
+
 // Type is a string
 type Type string
 
+// Position describes where a token starts in the source.
+type Position struct {
+	// File is the name of the source file, if known.
+	File string `json:"file"`
+
+	// Line is the 1-based line number.
+	Line int `json:"line"`
+
+	// Column is the 1-based column number, counted in runes.
+	Column int `json:"column"`
+}
+
+// IsValid reports whether the position holds a line number.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position in file:line:column form. The file is
+// omitted if it isn't known.
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+		return "<unknown>"
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
 // Token struct represent the lexer token
 type Token struct {
 	Type    Type
 	Literal string
+
+	// Pos is where the token starts in the source.
+	Pos Position
+
+	// Parts are the pieces of an INTERPOLATED string.
+	Parts []Part
+}
+
+// Part is a piece of an interpolated string: either text, or the source
+// of an expression from between {{ and }}, with the position each of its
+// characters came from, so it can be lexed where it is.
+type Part struct {
+	// Text is the text, with its escapes done.
+	Text string
+
+	// IsExpr reports whether this is an expression.
+	IsExpr bool
+
+	// Source is the expression, and Positions is where each of its
+	// characters is, ending with where its closing }} is.
+	Source    []rune
+	Positions []Position
 }
 
 // pre-defined Type
@@ -21,15 +77,19 @@ const (
 	BIT_AND         = "&"
 	BIT_XOR         = "^"
 	BIT_NOT         = "~"
+	CATCH           = "CATCH"
 	BIT_OR          = "|"
+	BREAK           = "BREAK"
 	COLON           = ":"
 	COMMA           = ","
+	CONTINUE        = "CONTINUE"
 	CURRENT_ARGS    = "..."
 	DOCSTRING       = "DOCSTRING"
 	ELSE            = "ELSE"
 	EOF             = "EOF"
 	EQ              = "=="
 	FALSE           = "FALSE"
+	FINALLY         = "FINALLY"
 	FLOAT           = "FLOAT"
 	FOR             = "FOR"
 	FOREACH         = "FOREACH"
@@ -42,6 +102,7 @@ const (
 	IMPORT          = "IMPORT"
 	IN              = "IN"
 	INT             = "INT"
+	INTERPOLATED    = "INTERPOLATED"
 	LBRACE          = "{"
 	LBRACKET        = "["
 	BIT_LEFT_SHIFT  = "<<"
@@ -75,23 +136,29 @@ const (
 	SPREAD          = "...."
 	STRING          = "STRING"
 	TRUE            = "TRUE"
+	TRY             = "TRY"
 )
 
 // reversed keywords
 var keywords = map[string]Type{
-	"else":    ELSE,
-	"false":   FALSE,
-	"fn":      FUNCTION,
-	"for":     FOR,
-	"foreach": FOREACH,
-	"if":      IF,
-	"import":  IMPORT,
-	"in":      IN,
-	"let":     LET,
-	"mutable": MUTABLE,
-	"null":    NULL,
-	"return":  RETURN,
-	"true":    TRUE,
+	"break":    BREAK,
+	"catch":    CATCH,
+	"continue": CONTINUE,
+	"else":     ELSE,
+	"false":    FALSE,
+	"finally":  FINALLY,
+	"fn":       FUNCTION,
+	"for":      FOR,
+	"foreach":  FOREACH,
+	"if":       IF,
+	"import":   IMPORT,
+	"in":       IN,
+	"let":      LET,
+	"mutable":  MUTABLE,
+	"null":     NULL,
+	"return":   RETURN,
+	"true":     TRUE,
+	"try":      TRY,
 }
 
 // LookupIdentifier used to determinate whether identifier is keyword nor not
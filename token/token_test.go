@@ -20,3 +20,21 @@ func TestLookup(t *testing.T) {
 		}
 	}
 }
+
+func TestPositionString(t *testing.T) {
+	tests := []struct {
+		pos      Position
+		expected string
+	}{
+		{Position{File: "main.cz", Line: 12, Column: 7}, "main.cz:12:7"},
+		{Position{Line: 3, Column: 1}, "3:1"},
+		{Position{File: "main.cz"}, "main.cz"},
+		{Position{}, "<unknown>"},
+	}
+	for _, tt := range tests {
+		if tt.pos.String() != tt.expected {
+			t.Errorf("wrong position string, expected=%q, got=%q",
+				tt.expected, tt.pos.String())
+		}
+	}
+}